@@ -5,6 +5,11 @@ import (
 	"fmt"
 )
 
+// genPPDeploymentID is the deployment of the protocol parameters GenPP puts
+// in pp. Deployments other than this reference one set Config.Params to their
+// own.
+const genPPDeploymentID = "FIRMER"
+
 // pp:= GenPP(maxValuesPerLeaf:λ int)
 func GenPP() (pp Config) {
 
@@ -14,6 +19,12 @@ func GenPP() (pp Config) {
 		return Config{}
 	}
 
+	cfg.Params, err = NewProtocolParams(genPPDeploymentID)
+	if err != nil {
+		fmt.Println("Error when using GenPP() to generate pp:", err)
+		return Config{}
+	}
+
 	fmt.Println("GenPP Function executed successfully!")
 	return cfg
 }
@@ -130,3 +141,45 @@ func VerifyUpd(st *Tree, startSeqno Seqno, endSeqno Seqno, com_start Transparenc
 	}
 
 }
+
+// sth,st_t,t =SignedUpdate(st *Tree, S []KeyValuePair, ctx logger.ContextInterface)
+// The tree must have a signing key, see Tree.SetSigningKey.
+func SignedUpdate(st *Tree, S []KeyValuePair, ctx logger.ContextInterface) (sth SignedTreeHead, st_t *Tree, t Seqno) {
+	_, st_t, t = Update(st, S, ctx)
+	if st_t == nil {
+		return
+	}
+	sth, err := st_t.SignedTreeHead(ctx, nil, t)
+	if err != nil {
+		fmt.Println("Error when using SignedUpdate Function:", err)
+		return SignedTreeHead{}, nil, 0
+	}
+	return sth, st_t, t
+}
+
+// sth,st_t,t =SignedPCSUpdate(st *Tree, S []KeyValuePair, ctx logger.ContextInterface)
+// The returned t is the Seqno of the last version built by the rotation, which
+// is the one sth signs.
+func SignedPCSUpdate(st *Tree, S []KeyValuePair, ctx logger.ContextInterface) (sth SignedTreeHead, st_t *Tree, t Seqno) {
+	_, st_t, _ = PCSUpdate(st, S, ctx)
+	if st_t == nil {
+		return
+	}
+	sth, err := st_t.LatestSignedTreeHead(ctx, nil)
+	if err != nil {
+		fmt.Println("Error when using SignedPCSUpdate Function:", err)
+		return SignedTreeHead{}, nil, 0
+	}
+	return sth, st_t, sth.Seqno
+}
+
+// int=VerifySigned(sth SignedTreeHead, pk *PublicKey, label Key, value interface{}, t Seqno, π MerkleInclusionProof, ctx logger.ContextInterface, pp Config)
+// Like Verify, but first checks the server signature on the tree head and
+// returns -1 if it is invalid.
+func VerifySigned(sth SignedTreeHead, pk *PublicKey, label Key, value interface{}, t Seqno, π MerkleInclusionProof, ctx logger.ContextInterface, pp Config) int {
+	if err := sth.Verify(pp.Params, pk); err != nil {
+		fmt.Println("Verification Error:", err)
+		return -1
+	}
+	return Verify(sth.Digest, label, value, t, π, ctx, pp)
+}
@@ -0,0 +1,373 @@
+package merkle
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"io"
+	"math/big"
+
+	"FIRMER/logger"
+
+	"github.com/cloudflare/bn256"
+)
+
+// SesKeyGen is an authenticated key exchange between two devices D_U1 (the
+// initiator) and D_U2 (the responder), each holding its device key S_DU. Each
+// hello carries the PubKeyReq proof of the sender's directory record, which
+// the receiver checks against its trusted directory commitment com to learn
+// the sender's device public key Q_DU and long-term public key PK_U; a
+// revoked or outdated key aborts the exchange.
+//
+//	initiator                              responder
+//	Hello{ID_DU1, V_U1 = x_U1*g2, π_1} ->
+//	                                  <-   Hello{ID_DU2, V_U2 = x_U2*g2, π_2}
+//	Confirm{delta_U1}                 ->
+//	                                  <-   Confirm{delta_U2}
+//
+// with K = e(S_DU1, V_U2) * e(Q_DU2, x_U1*PK_U2) = e(S_DU2, V_U1) * e(Q_DU1,
+// x_U2*PK_U1) and T the transcript of both identities, both V_U, K and
+// x_U1*x_U2*g2, bound to com:
+//
+//	delta_U1 = hbar(T || 0), delta_U2 = hbar(T || 0 || 1),
+//	session key = hbar(T || 0 || 2)
+
+// AKEIdentity is what a device knows about itself.
+type AKEIdentity struct {
+	ID   []byte
+	S_DU *bn256.G1
+	// KeyProof is the PubKeyReq answer for ID, sent to the peer.
+	KeyProof PubKeyProof
+}
+
+// AKEPeer is what a device learned about its peer from the directory.
+type AKEPeer struct {
+	ID   []byte
+	Q_DU *bn256.G1
+	PK_U *PublicKey
+}
+
+// AKEHelloMessage carries a device identity, its ephemeral public value
+// (encoded as an EncodingTypeEphemeralKey) and the directory proof of its
+// keys.
+type AKEHelloMessage struct {
+	_struct struct{}    `codec:",toarray"` //nolint
+	ID      []byte      `codec:"i"`
+	V       []byte      `codec:"v"`
+	Proof   PubKeyProof `codec:"p"`
+}
+
+// AKEConfirmMessage carries a key confirmation value delta.
+type AKEConfirmMessage struct {
+	_struct struct{} `codec:",toarray"` //nolint
+	Delta   []byte   `codec:"d"`
+}
+
+type akeState int
+
+const (
+	akeStateStart akeState = iota
+	akeStateHelloSent
+	akeStateConfirmSent
+	akeStateDone
+	akeStateFailed
+)
+
+func (s akeState) String() string {
+	switch s {
+	case akeStateStart:
+		return "start"
+	case akeStateHelloSent:
+		return "hello sent"
+	case akeStateConfirmSent:
+		return "confirm sent"
+	case akeStateDone:
+		return "done"
+	default:
+		return "failed"
+	}
+}
+
+// akeSession is the state shared by both sides of the exchange.
+type akeSession struct {
+	state akeState
+
+	params ProtocolParams
+	self   AKEIdentity
+	dir    *DirectoryVerifier
+	x      *big.Int
+	V      *bn256.G2
+
+	// peerID is the expected peer identity, or nil to accept any.
+	peerID []byte
+	peer   AKEPeer
+
+	transcript []byte
+	sessionKey []byte
+}
+
+func newAKESession(params ProtocolParams, self AKEIdentity, peerID []byte, dir *DirectoryVerifier) akeSession {
+	return akeSession{params: params, self: self, peerID: peerID, dir: dir}
+}
+
+func (a *akeSession) hello() (AKEHelloMessage, error) {
+	x, err := GenerateRandomInZp()
+	if err != nil {
+		return AKEHelloMessage{}, err
+	}
+	a.x = x
+	a.V = new(bn256.G2).ScalarBaseMult(x)
+	return AKEHelloMessage{ID: a.self.ID, V: EncodeG2(EncodingTypeEphemeralKey, a.V), Proof: a.self.KeyProof}, nil
+}
+
+// handlePeerHello checks the peer's hello and its directory proof, and
+// computes the transcript. initiator tells which side a is, to order the
+// transcript.
+func (a *akeSession) handlePeerHello(ctx logger.ContextInterface, m AKEHelloMessage, initiator bool) error {
+	if a.peerID != nil && !hmac.Equal(m.ID, a.peerID) {
+		return NewUnexpectedPeerError(a.peerID, m.ID)
+	}
+	peer, err := a.dir.Verify(ctx, m.ID, m.Proof)
+	if err != nil {
+		return err
+	}
+	a.peer = peer
+	peerV, err := DecodeG2(EncodingTypeEphemeralKey, m.V)
+	if err != nil {
+		return err
+	}
+	K := new(bn256.GT).Add(
+		bn256.Pair(a.self.S_DU, peerV),
+		bn256.Pair(a.peer.Q_DU, new(bn256.G2).ScalarMult(a.peer.PK_U.gx, a.x)),
+	)
+	xV := new(bn256.G2).ScalarMult(peerV, a.x)
+
+	if initiator {
+		a.transcript = akeTranscript(a.params, a.dir.Commitment(), a.self.ID, a.peer.ID, a.V, peerV, K, xV)
+	} else {
+		a.transcript = akeTranscript(a.params, a.dir.Commitment(), a.peer.ID, a.self.ID, peerV, a.V, K, xV)
+	}
+	return nil
+}
+
+// akeTranscript is T = tag || com || M || K || x_U1*V_U2 where M = ID_DU1
+// || ID_DU2 || V_U1 || V_U2, with com and the identities length-prefixed so
+// that they cannot be shifted into each other.
+func akeTranscript(params ProtocolParams, com, ID1, ID2 []byte, V1, V2 *bn256.G2, K *bn256.GT, xV *bn256.G2) []byte {
+	var t []byte
+	t = append(t, params.keyExchangeTag...)
+	for _, id := range [][]byte{com, ID1, ID2} {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(id)))
+		t = append(t, l[:]...)
+		t = append(t, id...)
+	}
+	t = append(t, V1.Marshal()...)
+	t = append(t, V2.Marshal()...)
+	t = append(t, K.Marshal()...)
+	t = append(t, xV.Marshal()...)
+	return append(t, 0)
+}
+
+func (a *akeSession) delta(suffix ...byte) []byte {
+	return hbar(append(append([]byte{}, a.transcript...), suffix...))
+}
+
+// SessionKey returns the session key once the exchange completed.
+func (a *akeSession) SessionKey() ([]byte, error) {
+	if a.state != akeStateDone {
+		return nil, NewProtocolStateError("SessionKey", a.state.String())
+	}
+	return a.sessionKey, nil
+}
+
+// AKEInitiator is the initiating device's side of SesKeyGen.
+type AKEInitiator struct {
+	akeSession
+}
+
+// NewAKEInitiator returns an initiator for self talking to the device peerID,
+// whose keys are checked with dir.
+func NewAKEInitiator(params ProtocolParams, self AKEIdentity, peerID []byte, dir *DirectoryVerifier) (*AKEInitiator, error) {
+	if err := params.check("NewAKEInitiator"); err != nil {
+		return nil, err
+	}
+	return &AKEInitiator{newAKESession(params, self, peerID, dir)}, nil
+}
+
+// Start picks x_U1 and returns the initiator hello (step 1).
+func (a *AKEInitiator) Start() (AKEHelloMessage, error) {
+	if a.state != akeStateStart {
+		return AKEHelloMessage{}, NewProtocolStateError("Start", a.state.String())
+	}
+	m, err := a.hello()
+	if err != nil {
+		a.state = akeStateFailed
+		return AKEHelloMessage{}, err
+	}
+	a.state = akeStateHelloSent
+	return m, nil
+}
+
+// HandleHello computes K from the responder hello and returns delta_U1
+// (step 3).
+func (a *AKEInitiator) HandleHello(ctx logger.ContextInterface, m AKEHelloMessage) (AKEConfirmMessage, error) {
+	if a.state != akeStateHelloSent {
+		return AKEConfirmMessage{}, NewProtocolStateError("HandleHello", a.state.String())
+	}
+	a.state = akeStateFailed
+	if err := a.handlePeerHello(ctx, m, true); err != nil {
+		return AKEConfirmMessage{}, err
+	}
+	a.state = akeStateConfirmSent
+	return AKEConfirmMessage{Delta: a.delta()}, nil
+}
+
+// HandleConfirm checks delta_U2 and derives the session key (step 5).
+func (a *AKEInitiator) HandleConfirm(m AKEConfirmMessage) error {
+	if a.state != akeStateConfirmSent {
+		return NewProtocolStateError("HandleConfirm", a.state.String())
+	}
+	a.state = akeStateFailed
+	if !hmac.Equal(m.Delta, a.delta(1)) {
+		return NewKeyConfirmationError(a.peer.ID)
+	}
+	a.sessionKey = a.delta(2)
+	a.state = akeStateDone
+	return nil
+}
+
+// AKEResponder is the responding device's side of SesKeyGen.
+type AKEResponder struct {
+	akeSession
+}
+
+// NewAKEResponder returns a responder for self, accepting any device whose
+// keys check out with dir.
+func NewAKEResponder(params ProtocolParams, self AKEIdentity, dir *DirectoryVerifier) (*AKEResponder, error) {
+	if err := params.check("NewAKEResponder"); err != nil {
+		return nil, err
+	}
+	return &AKEResponder{newAKESession(params, self, nil, dir)}, nil
+}
+
+// Peer returns the verified initiator once the exchange completed.
+func (a *AKEResponder) Peer() (AKEPeer, error) {
+	if a.state != akeStateDone {
+		return AKEPeer{}, NewProtocolStateError("Peer", a.state.String())
+	}
+	return a.peer, nil
+}
+
+// HandleHello picks x_U2, computes K from the initiator hello and returns the
+// responder hello (steps 2 and 4).
+func (a *AKEResponder) HandleHello(ctx logger.ContextInterface, m AKEHelloMessage) (AKEHelloMessage, error) {
+	if a.state != akeStateStart {
+		return AKEHelloMessage{}, NewProtocolStateError("HandleHello", a.state.String())
+	}
+	a.state = akeStateFailed
+	hello, err := a.hello()
+	if err != nil {
+		return AKEHelloMessage{}, err
+	}
+	if err := a.handlePeerHello(ctx, m, false); err != nil {
+		return AKEHelloMessage{}, err
+	}
+	a.state = akeStateHelloSent
+	return hello, nil
+}
+
+// HandleConfirm checks delta_U1, derives the session key and returns
+// delta_U2 (step 4).
+func (a *AKEResponder) HandleConfirm(m AKEConfirmMessage) (AKEConfirmMessage, error) {
+	if a.state != akeStateHelloSent {
+		return AKEConfirmMessage{}, NewProtocolStateError("HandleConfirm", a.state.String())
+	}
+	a.state = akeStateFailed
+	if !hmac.Equal(m.Delta, a.delta()) {
+		return AKEConfirmMessage{}, NewKeyConfirmationError(a.peer.ID)
+	}
+	a.sessionKey = a.delta(2)
+	a.state = akeStateDone
+	return AKEConfirmMessage{Delta: a.delta(1)}, nil
+}
+
+// RunAKEInitiator runs the initiator side of SesKeyGen, receiving frames from
+// in and sending them to out, and returns the session key. It closes out when
+// it returns. Each side sends two frames, so channels with a buffer of two
+// never block a peer which gave up.
+func RunAKEInitiator(ctx logger.ContextInterface, a *AKEInitiator, in <-chan []byte, out chan<- []byte) ([]byte, error) {
+	defer close(out)
+	hello, err := a.Start()
+	if err != nil {
+		return nil, err
+	}
+	if err := sendFrame(out, MessageTypeAKEHello, hello); err != nil {
+		return nil, err
+	}
+	var peerHello AKEHelloMessage
+	if err := receiveFrame(in, MessageTypeAKEHello, &peerHello); err != nil {
+		return nil, err
+	}
+	confirm, err := a.HandleHello(ctx, peerHello)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendFrame(out, MessageTypeAKEConfirm, confirm); err != nil {
+		return nil, err
+	}
+	var peerConfirm AKEConfirmMessage
+	if err := receiveFrame(in, MessageTypeAKEConfirm, &peerConfirm); err != nil {
+		return nil, err
+	}
+	if err := a.HandleConfirm(peerConfirm); err != nil {
+		return nil, err
+	}
+	return a.SessionKey()
+}
+
+// RunAKEResponder runs the responder side of SesKeyGen like RunAKEInitiator.
+func RunAKEResponder(ctx logger.ContextInterface, a *AKEResponder, in <-chan []byte, out chan<- []byte) ([]byte, error) {
+	defer close(out)
+	var peerHello AKEHelloMessage
+	if err := receiveFrame(in, MessageTypeAKEHello, &peerHello); err != nil {
+		return nil, err
+	}
+	hello, err := a.HandleHello(ctx, peerHello)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendFrame(out, MessageTypeAKEHello, hello); err != nil {
+		return nil, err
+	}
+	var peerConfirm AKEConfirmMessage
+	if err := receiveFrame(in, MessageTypeAKEConfirm, &peerConfirm); err != nil {
+		return nil, err
+	}
+	confirm, err := a.HandleConfirm(peerConfirm)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendFrame(out, MessageTypeAKEConfirm, confirm); err != nil {
+		return nil, err
+	}
+	return a.SessionKey()
+}
+
+func sendFrame(out chan<- []byte, typ MessageType, m interface{}) error {
+	frame, err := EncodeMessage(typ, m)
+	if err != nil {
+		return err
+	}
+	out <- frame
+	return nil
+}
+
+// receiveFrame reads one frame of type typ from in. A closed channel means
+// the peer gave up.
+func receiveFrame(in <-chan []byte, typ MessageType, m interface{}) error {
+	frame, ok := <-in
+	if !ok {
+		return io.ErrUnexpectedEOF
+	}
+	return DecodeMessage(frame, typ, m)
+}
@@ -0,0 +1,201 @@
+package merkle
+
+import (
+	"math/big"
+	"testing"
+
+	"FIRMER/logger"
+
+	"github.com/cloudflare/bn256"
+	"github.com/stretchr/testify/require"
+)
+
+type akeTestDevice struct {
+	self   AKEIdentity
+	record DirectoryRecord
+}
+
+func newAKETestDevice(t *testing.T, ID, pw []byte, k_U int64) akeTestDevice {
+	_, PK, S, Q, err := DeviceKeyGen(testParams, ID, pw, big.NewInt(k_U))
+	require.NoError(t, err)
+	return akeTestDevice{
+		self:   AKEIdentity{ID: ID, S_DU: S},
+		record: DirectoryRecord{ID: ID, PK_U: &PublicKey{gx: PK}, Q_DU: Q},
+	}
+}
+
+// akeTestSetup publishes two devices in a new directory and returns them with
+// their PubKeyReq proofs, and a verifier trusting the directory.
+func akeTestSetup(t *testing.T) (logger.ContextInterface, *KeyDirectory, *DirectoryVerifier, AKEIdentity, AKEIdentity) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	pp := GenPP()
+	dir := NewKeyDirectory(Init(pp), Init(pp))
+	d1 := newAKETestDevice(t, []byte("device1"), []byte("password1"), 12345)
+	d2 := newAKETestDevice(t, []byte("device2"), []byte("password2"), 67890)
+	com, err := dir.Apply(ctx, []DirectoryRecord{d1.record, d2.record}, nil)
+	require.NoError(t, err)
+
+	d1.self.KeyProof, err = dir.PubKeyReq(ctx, d1.self.ID)
+	require.NoError(t, err)
+	d2.self.KeyProof, err = dir.PubKeyReq(ctx, d2.self.ID)
+	require.NoError(t, err)
+	return ctx, dir, NewDirectoryVerifier(pp, com.Digest()), d1.self, d2.self
+}
+
+// newTestAKE returns an initiator for self talking to peerID and a responder
+// for peer, both checking keys with dir.
+func newTestAKE(t *testing.T, self AKEIdentity, peerID []byte, peer AKEIdentity, dir *DirectoryVerifier) (*AKEInitiator, *AKEResponder) {
+	i, err := NewAKEInitiator(testParams, self, peerID, dir)
+	require.NoError(t, err)
+	return i, newTestAKEResponder(t, peer, dir)
+}
+
+func newTestAKEResponder(t *testing.T, self AKEIdentity, dir *DirectoryVerifier) *AKEResponder {
+	r, err := NewAKEResponder(testParams, self, dir)
+	require.NoError(t, err)
+	return r
+}
+
+type akeResult struct {
+	key []byte
+	err error
+}
+
+// runTestAKE runs the exchange between self, expecting peerID, and peer.
+func runTestAKE(t *testing.T, ctx logger.ContextInterface, self AKEIdentity, peerID []byte, peer AKEIdentity, dir *DirectoryVerifier) (akeResult, akeResult) {
+	i, r := newTestAKE(t, self, peerID, peer, dir)
+	return runAKE(ctx, i, r)
+}
+
+// runAKE runs both sides in their own goroutine, connected by channels.
+func runAKE(ctx logger.ContextInterface, i *AKEInitiator, r *AKEResponder) (akeResult, akeResult) {
+	toR, toI := make(chan []byte, 2), make(chan []byte, 2)
+	done := make(chan akeResult, 1)
+	go func() {
+		key, err := RunAKEResponder(ctx, r, toR, toI)
+		done <- akeResult{key, err}
+	}()
+	key, err := RunAKEInitiator(ctx, i, toI, toR)
+	return akeResult{key, err}, <-done
+}
+
+func TestAKEOverChannels(t *testing.T) {
+	ctx, _, dir, self1, self2 := akeTestSetup(t)
+	i, r := newTestAKE(t, self1, self2.ID, self2, dir)
+	ri, rr := runAKE(ctx, i, r)
+	require.NoError(t, ri.err)
+	require.NoError(t, rr.err)
+	require.Len(t, ri.key, 32)
+	require.Equal(t, ri.key, rr.key)
+	peer, err := r.Peer()
+	require.NoError(t, err)
+	require.Equal(t, self1.ID, peer.ID)
+
+	// A second run gives a fresh key.
+	ri2, rr2 := runTestAKE(t, ctx, self1, self2.ID, self2, dir)
+	require.NoError(t, ri2.err)
+	require.NoError(t, rr2.err)
+	require.NotEqual(t, ri.key, ri2.key)
+}
+
+func TestAKEDirectoryKeys(t *testing.T) {
+	ctx, d, dir, self1, self2 := akeTestSetup(t)
+
+	// A device presenting another device's key proof is rejected.
+	impostor := self1
+	impostor.KeyProof = self2.KeyProof
+	ri, rr := runTestAKE(t, ctx, impostor, self2.ID, self2, dir)
+	require.Error(t, rr.err)
+	require.Error(t, ri.err)
+	require.Nil(t, ri.key)
+
+	// The initiator expects another device.
+	ri, _ = runTestAKE(t, ctx, self1, []byte("device3"), self2, dir)
+	require.IsType(t, UnexpectedPeerError{}, ri.err)
+
+	// A device with the right proof but the wrong device key fails key
+	// confirmation.
+	wrong := self1
+	wrong.S_DU = self2.S_DU
+	_, rr = runTestAKE(t, ctx, wrong, self2.ID, self2, dir)
+	require.IsType(t, KeyConfirmationError{}, rr.err)
+
+	// Once device1 publishes a new key, its old proof is outdated against the
+	// new commitment, and so is the proof of device2 made before.
+	d3 := newAKETestDevice(t, self1.ID, []byte("newpassword"), 12345)
+	com, err := d.Apply(ctx, []DirectoryRecord{d3.record}, nil)
+	require.NoError(t, err)
+	dir2 := NewDirectoryVerifier(GenPP(), com.Digest())
+	_, rr = runTestAKE(t, ctx, self1, self2.ID, self2, dir2)
+	require.IsType(t, KeyOutdatedError{}, rr.err)
+
+	// A proof made at the new commitment for the old version is outdated too.
+	stale := self1.KeyProof
+	fresh, err := d.PubKeyReq(ctx, self1.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), fresh.Version)
+	stale.Commitment = fresh.Commitment
+	_, err = dir2.Verify(ctx, self1.ID, stale)
+	require.Error(t, err)
+
+	// After revocation, the directory refuses to serve the key.
+	_, err = d.Apply(ctx, nil, [][]byte{self1.ID})
+	require.NoError(t, err)
+	_, err = d.PubKeyReq(ctx, self1.ID)
+	require.IsType(t, KeyRevokedError{}, err)
+}
+
+func TestAKEStateMachines(t *testing.T) {
+	ctx, _, dir, self1, self2 := akeTestSetup(t)
+	i, r := newTestAKE(t, self1, self2.ID, self2, dir)
+
+	_, err := i.HandleHello(ctx, AKEHelloMessage{})
+	require.IsType(t, ProtocolStateError{}, err)
+	_, err = r.HandleConfirm(AKEConfirmMessage{})
+	require.IsType(t, ProtocolStateError{}, err)
+	_, err = i.SessionKey()
+	require.IsType(t, ProtocolStateError{}, err)
+
+	hello1, err := i.Start()
+	require.NoError(t, err)
+	hello2, err := r.HandleHello(ctx, hello1)
+	require.NoError(t, err)
+	_, err = r.HandleHello(ctx, hello1)
+	require.IsType(t, ProtocolStateError{}, err)
+	confirm1, err := i.HandleHello(ctx, hello2)
+	require.NoError(t, err)
+
+	// A tampered delta_U1 fails the responder for good.
+	tampered := AKEConfirmMessage{Delta: append([]byte{}, confirm1.Delta...)}
+	tampered.Delta[0] ^= 1
+	_, err = r.HandleConfirm(tampered)
+	require.IsType(t, KeyConfirmationError{}, err)
+	_, err = r.HandleConfirm(confirm1)
+	require.IsType(t, ProtocolStateError{}, err)
+
+	// A replayed hello with another V changes the transcript.
+	r = newTestAKEResponder(t, self2, dir)
+	_, err = r.HandleHello(ctx, hello1)
+	require.NoError(t, err)
+	confirm2, err := r.HandleConfirm(confirm1)
+	require.IsType(t, KeyConfirmationError{}, err)
+	require.Nil(t, confirm2.Delta)
+
+	_, err = newTestAKEResponder(t, self2, dir).HandleHello(ctx, AKEHelloMessage{ID: hello1.ID, V: []byte{0}, Proof: hello1.Proof})
+	require.Error(t, err)
+
+	// V_U = identity would make K independent of x_U.
+	identity := EncodeG2(EncodingTypeEphemeralKey, new(bn256.G2).ScalarBaseMult(big.NewInt(0)))
+	_, err = newTestAKEResponder(t, self2, dir).HandleHello(ctx, AKEHelloMessage{ID: hello1.ID, V: identity, Proof: hello1.Proof})
+	require.IsType(t, InvalidEncodingError{}, err)
+	_, err = DecodeG2(EncodingTypeEphemeralKey, identity)
+	require.IsType(t, InvalidEncodingError{}, err)
+}
+
+func TestAKERequiresProtocolParams(t *testing.T) {
+	_, _, dir, self1, self2 := akeTestSetup(t)
+	_, err := NewAKEInitiator(ProtocolParams{}, self1, self2.ID, dir)
+	require.IsType(t, MissingProtocolParamsError{}, err)
+	_, err = NewAKEResponder(ProtocolParams{}, self2, dir)
+	require.IsType(t, MissingProtocolParamsError{}, err)
+}
@@ -0,0 +1,144 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/cloudflare/bn256"
+)
+
+// Signature is a BLS signature, i.e. a point on curve G1
+type Signature struct {
+	s *bn256.G1
+}
+
+// ToBytes serializes the BLS signature to byte array.
+func (sig *Signature) ToBytes() []byte {
+	return sig.s.Marshal()
+}
+
+// SignatureFromBytes deserializes a BLS signature from byte array.
+func SignatureFromBytes(b []byte) (*Signature, error) {
+	s := new(bn256.G1)
+	rest, err := s.Unmarshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BLS signature: %v", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("invalid BLS signature: %d trailing bytes", len(rest))
+	}
+	if isG1Identity(s) {
+		return nil, fmt.Errorf("invalid BLS signature: point at infinity")
+	}
+	return &Signature{s: s}, nil
+}
+
+// GenerateKey generates a BLS key pair using randomness from r.
+func GenerateKey(r io.Reader) (*PrivateKey, error) {
+	x, gx, err := bn256.RandomG2(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate BLS key: %v", err)
+	}
+	return &PrivateKey{PublicKey: PublicKey{gx: gx}, x: x}, nil
+}
+
+// Sign computes the BLS signature x*H(msg) of msg, hashing msg under the
+// signature tag of params, which separates it from the other uses of HashG1.
+func (privKey *PrivateKey) Sign(params ProtocolParams, msg []byte) *Signature {
+	h := bn256.HashG1(msg, params.blsSignatureTag)
+	return &Signature{s: new(bn256.G1).ScalarMult(h, privKey.x)}
+}
+
+// Verify checks that e(sig, g2) == e(H(msg), pk), with H as in Sign.
+func (pubKey *PublicKey) Verify(params ProtocolParams, msg []byte, sig *Signature) bool {
+	if sig == nil || sig.s == nil || pubKey.gx == nil {
+		return false
+	}
+	h := bn256.HashG1(msg, params.blsSignatureTag)
+	negSig := new(bn256.G1).Neg(sig.s)
+	return pairingProductIsOne([]*bn256.G1{negSig, h}, []*bn256.G2{g2Generator(), pubKey.gx})
+}
+
+func isG1Identity(p *bn256.G1) bool {
+	return bytes.Equal(p.Marshal(), make([]byte, 64))
+}
+
+// isG2Identity relies on G2.Marshal encoding the identity as a single zero
+// byte, and other points with a leading 0x01.
+func isG2Identity(p *bn256.G2) bool {
+	return bytes.Equal(p.Marshal(), []byte{0})
+}
+
+func g2Generator() *bn256.G2 {
+	return new(bn256.G2).ScalarBaseMult(big.NewInt(1))
+}
+
+// pairingProductIsOne checks that the product of e(a[i], b[i]) is the identity
+// of GT, sharing one final exponentiation between all the pairings. Negations
+// must be applied on the G1 side: bn256.Miller mishandles negated G2 points.
+func pairingProductIsOne(a []*bn256.G1, b []*bn256.G2) bool {
+	acc := bn256.Miller(a[0], b[0])
+	for i := 1; i < len(a); i++ {
+		acc.Add(acc, bn256.Miller(a[i], b[i]))
+	}
+	one := new(bn256.GT).ScalarBaseMult(big.NewInt(0))
+	return bytes.Equal(acc.Finalize().Marshal(), one.Marshal())
+}
+
+// ProvePossession signs the public key itself, proving knowledge of the
+// private key. Aggregate verification is only safe over keys whose proof of
+// possession was checked, otherwise a rogue key pk' = pk_evil - pk_honest lets
+// an attacker forge aggregate signatures. The proof of possession tag of
+// params differs from the signature tag, so that a proof of possession can
+// never be replayed as a signature.
+func (privKey *PrivateKey) ProvePossession(params ProtocolParams) *Signature {
+	h := bn256.HashG1(privKey.gx.Marshal(), params.blsPossessionTag)
+	return &Signature{s: new(bn256.G1).ScalarMult(h, privKey.x)}
+}
+
+// VerifyPossession checks a proof of possession made by ProvePossession.
+func (pubKey *PublicKey) VerifyPossession(params ProtocolParams, pop *Signature) bool {
+	if pop == nil || pop.s == nil || pubKey.gx == nil {
+		return false
+	}
+	h := bn256.HashG1(pubKey.gx.Marshal(), params.blsPossessionTag)
+	negPop := new(bn256.G1).Neg(pop.s)
+	return pairingProductIsOne([]*bn256.G1{negPop, h}, []*bn256.G2{g2Generator(), pubKey.gx})
+}
+
+// AggregateSignatures combines signatures on the same message into one.
+func AggregateSignatures(sigs ...*Signature) (*Signature, error) {
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("no signatures to aggregate")
+	}
+	agg := new(bn256.G1).Set(sigs[0].s)
+	for _, sig := range sigs[1:] {
+		agg.Add(agg, sig.s)
+	}
+	return &Signature{s: agg}, nil
+}
+
+// AggregatePublicKeys combines public keys so that the aggregate of their
+// signatures on a message verifies under the result.
+func AggregatePublicKeys(pubKeys ...*PublicKey) (*PublicKey, error) {
+	if len(pubKeys) == 0 {
+		return nil, fmt.Errorf("no public keys to aggregate")
+	}
+	agg := new(bn256.G2).Set(pubKeys[0].gx)
+	for _, pk := range pubKeys[1:] {
+		agg.Add(agg, pk.gx)
+	}
+	return &PublicKey{gx: agg}, nil
+}
+
+// VerifyAggregate checks an aggregate signature of pubKeys on msg. The proofs
+// of possession of pubKeys must have been checked beforehand.
+func VerifyAggregate(params ProtocolParams, pubKeys []*PublicKey, msg []byte, sig *Signature) bool {
+	agg, err := AggregatePublicKeys(pubKeys...)
+	if err != nil {
+		return false
+	}
+	return agg.Verify(params, msg, sig)
+}
@@ -0,0 +1,102 @@
+package merkle
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/cloudflare/bn256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBLSSignVerify(t *testing.T) {
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pk := sk.GetPublicKey()
+
+	msg := []byte("digest")
+	sig := sk.Sign(testParams, msg)
+	require.True(t, pk.Verify(testParams, msg, sig))
+	require.False(t, pk.Verify(testParams, []byte("other digest"), sig))
+
+	sk2, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.False(t, sk2.GetPublicKey().Verify(testParams, msg, sig))
+
+	sig2, err := SignatureFromBytes(sig.ToBytes())
+	require.NoError(t, err)
+	require.True(t, pk.Verify(testParams, msg, sig2))
+
+	// Keys restored with FromBytes produce the same signatures.
+	var sk3 PrivateKey
+	require.NoError(t, sk3.FromBytes(sk.ToBytes()))
+	require.Equal(t, sig.ToBytes(), sk3.Sign(testParams, msg).ToBytes())
+}
+
+func TestBLSSignatureFromBytesErrors(t *testing.T) {
+	_, err := SignatureFromBytes(make([]byte, 63))
+	require.Error(t, err)
+	_, err = SignatureFromBytes(make([]byte, 64))
+	require.Error(t, err, "the point at infinity is not a valid signature")
+	bad := make([]byte, 64)
+	bad[63] = 1
+	_, err = SignatureFromBytes(bad)
+	require.Error(t, err, "(0, 1) is not on the curve")
+
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = SignatureFromBytes(append(sk.Sign(testParams, []byte("m")).ToBytes(), 0))
+	require.Error(t, err)
+}
+
+func TestIsG2Identity(t *testing.T) {
+	// G2.Marshal encodes the identity as a single zero byte.
+	require.True(t, isG2Identity(new(bn256.G2).ScalarBaseMult(big.NewInt(0))))
+	require.True(t, isG2Identity(new(bn256.G2).ScalarMult(g2Generator(), bn256.Order)))
+	require.False(t, isG2Identity(g2Generator()))
+}
+
+func TestBLSAggregate(t *testing.T) {
+	msg := []byte("digest")
+	var pks []*PublicKey
+	var sigs []*Signature
+	for i := 0; i < 4; i++ {
+		sk, err := GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		require.True(t, sk.GetPublicKey().VerifyPossession(testParams, sk.ProvePossession(testParams)))
+		pks = append(pks, sk.GetPublicKey())
+		sigs = append(sigs, sk.Sign(testParams, msg))
+	}
+	agg, err := AggregateSignatures(sigs...)
+	require.NoError(t, err)
+	require.True(t, VerifyAggregate(testParams, pks, msg, agg))
+	require.False(t, VerifyAggregate(testParams, pks[:3], msg, agg))
+	require.False(t, VerifyAggregate(testParams, pks, []byte("other digest"), agg))
+
+	agg3, err := AggregateSignatures(sigs[:3]...)
+	require.NoError(t, err)
+	require.True(t, VerifyAggregate(testParams, pks[:3], msg, agg3))
+
+	_, err = AggregateSignatures()
+	require.Error(t, err)
+	require.False(t, VerifyAggregate(testParams, nil, msg, agg))
+}
+
+func TestBLSRogueKeyNeedsPossession(t *testing.T) {
+	msg := []byte("digest")
+	honest, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	evil, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	// rogue = evil - honest, so honest + rogue = evil and the evil signature
+	// alone passes as an aggregate of both.
+	rogue := &PublicKey{gx: new(bn256.G2).Add(evil.gx, new(bn256.G2).Neg(honest.gx))}
+	require.True(t, VerifyAggregate(testParams, []*PublicKey{honest.GetPublicKey(), rogue}, msg, evil.Sign(testParams, msg)))
+
+	// The attacker can't prove possession of the rogue key.
+	require.False(t, rogue.VerifyPossession(testParams, evil.ProvePossession(testParams)))
+	require.False(t, rogue.VerifyPossession(testParams, evil.Sign(testParams, rogue.ToBytes())))
+	// Nor reuse a signature as a proof of possession.
+	require.False(t, honest.GetPublicKey().VerifyPossession(testParams, honest.Sign(testParams, honest.ToBytes())))
+}
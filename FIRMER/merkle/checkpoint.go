@@ -0,0 +1,338 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"FIRMER/logger"
+)
+
+// This file implements checkpoints in the C2SP signed-note format
+// (https://c2sp.org/signed-note and https://c2sp.org/tlog-checkpoint), so that
+// transparency digests can be handed to existing transparency-log witnesses.
+// A checkpoint commits to the history tree: its size is the Seqno of the
+// latest root, and its hash is the TransparencyDigest.
+
+// noteAlgEd25519 is the signed-note signature type identifier for Ed25519.
+const noteAlgEd25519 = 0x01
+
+// maxNoteSignatures bounds the number of signature lines parsed in a note.
+const maxNoteSignatures = 100
+
+// Checkpoint is the body of a checkpoint note.
+type Checkpoint struct {
+	Origin string
+	Size   Seqno
+	Hash   TransparencyDigest
+	// Extensions are the optional lines following the root hash.
+	Extensions []string
+}
+
+// CheckpointFromLatestRoot returns the checkpoint for the latest root of t.
+func CheckpointFromLatestRoot(ctx logger.ContextInterface, t *Tree, origin string) (Checkpoint, error) {
+	s, _, td, err := t.GetLatestRoot(ctx, nil)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	return Checkpoint{Origin: origin, Size: s, Hash: td}, nil
+}
+
+// Marshal returns the checkpoint text, i.e. the part of the note which is signed.
+func (c Checkpoint) Marshal() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n%d\n%s\n", c.Origin, c.Size, base64.StdEncoding.EncodeToString(c.Hash))
+	for _, e := range c.Extensions {
+		fmt.Fprintf(&b, "%s\n", e)
+	}
+	return b.Bytes()
+}
+
+// ParseCheckpoint parses checkpoint text produced by Marshal.
+func ParseCheckpoint(text []byte) (Checkpoint, error) {
+	if len(text) == 0 || text[len(text)-1] != '\n' {
+		return Checkpoint{}, fmt.Errorf("checkpoint must end with a newline")
+	}
+	lines := strings.Split(string(text[:len(text)-1]), "\n")
+	if len(lines) < 3 {
+		return Checkpoint{}, fmt.Errorf("checkpoint has %d lines, want at least 3", len(lines))
+	}
+	if lines[0] == "" {
+		return Checkpoint{}, fmt.Errorf("empty checkpoint origin")
+	}
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil || size < 0 || strconv.FormatInt(size, 10) != lines[1] {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint size %q", lines[1])
+	}
+	hash, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(hash) == 0 {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint hash %q", lines[2])
+	}
+	c := Checkpoint{Origin: lines[0], Size: Seqno(size), Hash: hash}
+	for _, e := range lines[3:] {
+		if e == "" {
+			return Checkpoint{}, fmt.Errorf("empty checkpoint extension line")
+		}
+		c.Extensions = append(c.Extensions, e)
+	}
+	return c, nil
+}
+
+func isValidNoteKeyName(name string) bool {
+	return name != "" && utf8.ValidString(name) && !strings.ContainsAny(name, "+ \t\n")
+}
+
+func noteKeyHash(name string, key []byte) uint32 {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte("\n"))
+	h.Write([]byte{noteAlgEd25519})
+	h.Write(key)
+	return binary.BigEndian.Uint32(h.Sum(nil))
+}
+
+// GenerateNoteKey generates an Ed25519 note key pair, encoded as in the Go
+// golang.org/x/mod/sumdb/note package: the signer key is
+// "PRIVATE+KEY+<name>+<hash>+<base64 key>", the verifier key
+// "<name>+<hash>+<base64 key>".
+func GenerateNoteKey(rand io.Reader, name string) (skey string, vkey string, err error) {
+	if !isValidNoteKeyName(name) {
+		return "", "", fmt.Errorf("invalid note key name %q", name)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand)
+	if err != nil {
+		return "", "", err
+	}
+	pubEnc := append([]byte{noteAlgEd25519}, pub...)
+	privEnc := append([]byte{noteAlgEd25519}, priv.Seed()...)
+	h := noteKeyHash(name, pub)
+	skey = fmt.Sprintf("PRIVATE+KEY+%s+%08x+%s", name, h, base64.StdEncoding.EncodeToString(privEnc))
+	vkey = fmt.Sprintf("%s+%08x+%s", name, h, base64.StdEncoding.EncodeToString(pubEnc))
+	return skey, vkey, nil
+}
+
+// parseNoteKey splits "<name>+<hash>+<base64 key>" and checks the key type.
+func parseNoteKey(s string) (name string, hash uint32, key []byte, err error) {
+	parts := strings.SplitN(s, "+", 3)
+	if len(parts) != 3 {
+		return "", 0, nil, fmt.Errorf("malformed note key")
+	}
+	name = parts[0]
+	if !isValidNoteKeyName(name) {
+		return "", 0, nil, fmt.Errorf("invalid note key name %q", name)
+	}
+	h, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil || len(parts[1]) != 8 {
+		return "", 0, nil, fmt.Errorf("malformed note key hash")
+	}
+	key, err = base64.StdEncoding.DecodeString(parts[2])
+	if err != nil || len(key) == 0 || key[0] != noteAlgEd25519 {
+		return "", 0, nil, fmt.Errorf("unsupported note key")
+	}
+	return name, uint32(h), key[1:], nil
+}
+
+// NoteSigner signs notes with an Ed25519 key.
+type NoteSigner struct {
+	name string
+	hash uint32
+	key  ed25519.PrivateKey
+}
+
+// NewNoteSigner decodes a signer key made by GenerateNoteKey.
+func NewNoteSigner(skey string) (*NoteSigner, error) {
+	if !strings.HasPrefix(skey, "PRIVATE+KEY+") {
+		return nil, fmt.Errorf("malformed note signer key")
+	}
+	name, hash, seed, err := parseNoteKey(strings.TrimPrefix(skey, "PRIVATE+KEY+"))
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid note signer key length")
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	if noteKeyHash(name, key.Public().(ed25519.PublicKey)) != hash {
+		return nil, fmt.Errorf("note signer key hash mismatch")
+	}
+	return &NoteSigner{name: name, hash: hash, key: key}, nil
+}
+
+// Name returns the key name.
+func (s *NoteSigner) Name() string {
+	return s.name
+}
+
+// NoteVerifier verifies note signatures made by one Ed25519 key.
+type NoteVerifier struct {
+	name string
+	hash uint32
+	key  ed25519.PublicKey
+}
+
+// NewNoteVerifier decodes a verifier key made by GenerateNoteKey.
+func NewNoteVerifier(vkey string) (*NoteVerifier, error) {
+	name, hash, key, err := parseNoteKey(vkey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid note verifier key length")
+	}
+	if noteKeyHash(name, key) != hash {
+		return nil, fmt.Errorf("note verifier key hash mismatch")
+	}
+	return &NoteVerifier{name: name, hash: hash, key: key}, nil
+}
+
+// Name returns the key name.
+func (v *NoteVerifier) Name() string {
+	return v.name
+}
+
+func checkNoteText(text []byte) error {
+	if len(text) == 0 || text[len(text)-1] != '\n' {
+		return fmt.Errorf("note text must end with a newline")
+	}
+	if !utf8.Valid(text) {
+		return fmt.Errorf("note text is not valid UTF-8")
+	}
+	for _, c := range text {
+		if c < 0x20 && c != '\n' {
+			return fmt.Errorf("note text contains control characters")
+		}
+	}
+	return nil
+}
+
+func noteSignatureLine(name string, hash uint32, sig []byte) string {
+	var hb [4]byte
+	binary.BigEndian.PutUint32(hb[:], hash)
+	return fmt.Sprintf("— %s %s\n", name, base64.StdEncoding.EncodeToString(append(hb[:], sig...)))
+}
+
+// SignNote signs text with every signer and returns the note.
+func SignNote(text []byte, signers ...*NoteSigner) ([]byte, error) {
+	if err := checkNoteText(text); err != nil {
+		return nil, err
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no note signers")
+	}
+	var b bytes.Buffer
+	b.Write(text)
+	b.WriteString("\n")
+	for _, s := range signers {
+		b.WriteString(noteSignatureLine(s.name, s.hash, ed25519.Sign(s.key, text)))
+	}
+	return b.Bytes(), nil
+}
+
+type noteSignature struct {
+	name string
+	hash uint32
+	sig  []byte
+}
+
+// splitNote separates the signed text from the signature lines of a note.
+func splitNote(note []byte) (text []byte, sigs []noteSignature, err error) {
+	i := bytes.LastIndex(note, []byte("\n\n"))
+	if i < 0 {
+		return nil, nil, fmt.Errorf("malformed note: no signatures")
+	}
+	text = note[:i+1]
+	if err := checkNoteText(text); err != nil {
+		return nil, nil, err
+	}
+	block := note[i+2:]
+	if len(block) == 0 || block[len(block)-1] != '\n' {
+		return nil, nil, fmt.Errorf("malformed note: signature block must end with a newline")
+	}
+	for _, line := range strings.Split(string(block[:len(block)-1]), "\n") {
+		if len(sigs) == maxNoteSignatures {
+			return nil, nil, fmt.Errorf("malformed note: too many signatures")
+		}
+		if !strings.HasPrefix(line, "— ") {
+			return nil, nil, fmt.Errorf("malformed note signature line %q", line)
+		}
+		fields := strings.Split(strings.TrimPrefix(line, "— "), " ")
+		if len(fields) != 2 || !isValidNoteKeyName(fields[0]) {
+			return nil, nil, fmt.Errorf("malformed note signature line %q", line)
+		}
+		raw, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(raw) < 5 {
+			return nil, nil, fmt.Errorf("malformed note signature line %q", line)
+		}
+		sigs = append(sigs, noteSignature{name: fields[0], hash: binary.BigEndian.Uint32(raw), sig: raw[4:]})
+	}
+	return text, sigs, nil
+}
+
+// OpenNote checks that note carries a valid signature from every one of
+// verifiers and returns the signed text. Signatures from unknown keys are
+// ignored.
+func OpenNote(note []byte, verifiers ...*NoteVerifier) ([]byte, error) {
+	if len(verifiers) == 0 {
+		return nil, fmt.Errorf("no note verifiers")
+	}
+	text, sigs, err := splitNote(note)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range verifiers {
+		found := false
+		for _, s := range sigs {
+			if s.name != v.name || s.hash != v.hash {
+				continue
+			}
+			if !ed25519.Verify(v.key, text, s.sig) {
+				return nil, fmt.Errorf("invalid note signature from %q", v.name)
+			}
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("note is not signed by %q", v.name)
+		}
+	}
+	return text, nil
+}
+
+// CosignNote adds a signature from signer to an existing note, without
+// checking the signatures already there.
+func CosignNote(note []byte, signer *NoteSigner) ([]byte, error) {
+	text, _, err := splitNote(note)
+	if err != nil {
+		return nil, err
+	}
+	line := noteSignatureLine(signer.name, signer.hash, ed25519.Sign(signer.key, text))
+	return append(append([]byte{}, note...), line...), nil
+}
+
+// SignCheckpoint returns c as a note signed by the log key.
+func SignCheckpoint(c Checkpoint, signer *NoteSigner) ([]byte, error) {
+	return SignNote(c.Marshal(), signer)
+}
+
+// OpenCheckpoint verifies a checkpoint note with the log key and checks that
+// it is for the expected origin.
+func OpenCheckpoint(note []byte, origin string, verifier *NoteVerifier) (Checkpoint, error) {
+	text, err := OpenNote(note, verifier)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	c, err := ParseCheckpoint(text)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if c.Origin != origin {
+		return Checkpoint{}, fmt.Errorf("checkpoint origin %q, want %q", c.Origin, origin)
+	}
+	return c, nil
+}
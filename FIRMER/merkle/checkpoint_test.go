@@ -0,0 +1,209 @@
+package merkle
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test vector from the documentation of golang.org/x/mod/sumdb/note.
+const (
+	testNoteSkey = "PRIVATE+KEY+PeterNeumann+c74f20a3+AYEKFALVFGyNhPJEMzD1QIDr+Y7hfZx09iUvxdXHKDFz"
+	testNoteVkey = "PeterNeumann+c74f20a3+ARpc2QcUPDhMQegwxbzhKqiBfsVkmqq/LDE4izWy10TW"
+	testNoteText = "If you think cryptography is the answer to your problem,\n" +
+		"then you don't know what your problem is.\n"
+	testNote = testNoteText + "\n" +
+		"— PeterNeumann x08go/ZJkuBS9UG/SffcvIAQxVBtiFupLLr8pAcElZInNIuGUgYN1FFYC2pZSNXgKvqfqdngotpRZb6KE6RyyBwJnAM=\n"
+)
+
+func TestNoteInterop(t *testing.T) {
+	signer, err := NewNoteSigner(testNoteSkey)
+	require.NoError(t, err)
+	verifier, err := NewNoteVerifier(testNoteVkey)
+	require.NoError(t, err)
+	require.Equal(t, "PeterNeumann", signer.Name())
+	require.Equal(t, "PeterNeumann", verifier.Name())
+
+	note, err := SignNote([]byte(testNoteText), signer)
+	require.NoError(t, err)
+	require.Equal(t, testNote, string(note))
+
+	text, err := OpenNote([]byte(testNote), verifier)
+	require.NoError(t, err)
+	require.Equal(t, testNoteText, string(text))
+
+	_, err = OpenNote([]byte(strings.Replace(testNote, "answer", "question", 1)), verifier)
+	require.Error(t, err)
+}
+
+func TestNoteKeys(t *testing.T) {
+	skey, vkey, err := GenerateNoteKey(rand.Reader, "example.com/log")
+	require.NoError(t, err)
+	signer, err := NewNoteSigner(skey)
+	require.NoError(t, err)
+	verifier, err := NewNoteVerifier(vkey)
+	require.NoError(t, err)
+
+	note, err := SignNote([]byte("hello\n"), signer)
+	require.NoError(t, err)
+	_, err = OpenNote(note, verifier)
+	require.NoError(t, err)
+
+	_, otherVkey, err := GenerateNoteKey(rand.Reader, "example.com/log")
+	require.NoError(t, err)
+	other, err := NewNoteVerifier(otherVkey)
+	require.NoError(t, err)
+	_, err = OpenNote(note, other)
+	require.Error(t, err)
+	_, err = OpenNote(note, verifier, other)
+	require.Error(t, err)
+
+	_, _, err = GenerateNoteKey(rand.Reader, "bad name")
+	require.Error(t, err)
+	for _, bad := range []string{
+		"",
+		"PeterNeumann",
+		"PeterNeumann+c74f20a4+ARpc2QcUPDhMQegwxbzhKqiBfsVkmqq/LDE4izWy10TW",
+		"PeterNeumann+c74f20a3+ZRpc2QcUPDhMQegwxbzhKqiBfsVkmqq/LDE4izWy10TW",
+		testNoteSkey,
+	} {
+		_, err = NewNoteVerifier(bad)
+		require.Error(t, err, bad)
+	}
+	_, err = NewNoteSigner(testNoteVkey)
+	require.Error(t, err)
+}
+
+func TestNoteMalformed(t *testing.T) {
+	verifier, err := NewNoteVerifier(testNoteVkey)
+	require.NoError(t, err)
+	for _, note := range []string{
+		testNoteText,
+		testNoteText + "\n",
+		strings.TrimSuffix(testNote, "\n"),
+		strings.Replace(testNote, "— ", "- ", 1),
+		strings.Replace(testNote, "x08go", "x08go=", 1),
+		"\x01" + testNote,
+	} {
+		_, err := OpenNote([]byte(note), verifier)
+		require.Error(t, err, note)
+	}
+
+	signer, err := NewNoteSigner(testNoteSkey)
+	require.NoError(t, err)
+	_, err = SignNote([]byte("no newline"), signer)
+	require.Error(t, err)
+	_, err = SignNote([]byte("tab\tline\n"), signer)
+	require.Error(t, err)
+}
+
+func TestCheckpointParse(t *testing.T) {
+	c := Checkpoint{Origin: "example.com/log", Size: 42, Hash: TransparencyDigest{1, 2, 3}, Extensions: []string{"ext"}}
+	text := c.Marshal()
+	require.Equal(t, "example.com/log\n42\nAQID\next\n", string(text))
+	c2, err := ParseCheckpoint(text)
+	require.NoError(t, err)
+	require.Equal(t, c, c2)
+
+	for _, bad := range []string{
+		"example.com/log\n42\nAQID",
+		"example.com/log\n42\n",
+		"\n42\nAQID\n",
+		"example.com/log\n042\nAQID\n",
+		"example.com/log\n-1\nAQID\n",
+		"example.com/log\n+1\nAQID\n",
+		"example.com/log\n42\n!!\n",
+		"example.com/log\n42\nAQID\n\n",
+	} {
+		_, err := ParseCheckpoint([]byte(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+func TestCheckpointFromTreeAndWitness(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	pp := GenPP()
+	st := Init(pp)
+	require.NotNil(t, st)
+	const origin = "firmer.example/directory"
+
+	logSkey, logVkey, err := GenerateNoteKey(rand.Reader, "firmer.example")
+	require.NoError(t, err)
+	logSigner, err := NewNoteSigner(logSkey)
+	require.NoError(t, err)
+	logVerifier, err := NewNoteVerifier(logVkey)
+	require.NoError(t, err)
+	witSkey, witVkey, err := GenerateNoteKey(rand.Reader, "witness.example")
+	require.NoError(t, err)
+	witSigner, err := NewNoteSigner(witSkey)
+	require.NoError(t, err)
+	witVerifier, err := NewNoteVerifier(witVkey)
+	require.NoError(t, err)
+
+	w := NewWitness(pp, origin, logVerifier, witSigner)
+	_, ok := w.Latest()
+	require.False(t, ok)
+
+	_, _, s1 := Update(st, GenerateInitS(1, 10), ctx)
+	c1, err := CheckpointFromLatestRoot(ctx, st, origin)
+	require.NoError(t, err)
+	require.Equal(t, s1, c1.Size)
+	note1, err := SignCheckpoint(c1, logSigner)
+	require.NoError(t, err)
+	cosigned, err := w.Update(ctx, note1, MerkleExtensionProof{})
+	require.NoError(t, err)
+	_, err = OpenNote(cosigned, logVerifier, witVerifier)
+	require.NoError(t, err)
+	opened, err := OpenCheckpoint(cosigned, origin, logVerifier)
+	require.NoError(t, err)
+	require.Equal(t, c1, opened)
+
+	for i := 0; i < 4; i++ {
+		Update(st, GenerateInitS(100+10*i, 102+10*i), ctx)
+	}
+	c2, err := CheckpointFromLatestRoot(ctx, st, origin)
+	require.NoError(t, err)
+	note2, err := SignCheckpoint(c2, logSigner)
+	require.NoError(t, err)
+
+	// Without a valid extension proof the witness refuses to move on.
+	_, err = w.Update(ctx, note2, MerkleExtensionProof{})
+	require.IsType(t, CheckpointRejectedError{}, err)
+	wrong, err := st.GetExtensionProof(ctx, nil, s1+1, c2.Size)
+	require.NoError(t, err)
+	_, err = w.Update(ctx, note2, wrong)
+	require.IsType(t, CheckpointRejectedError{}, err)
+	latest, _ := w.Latest()
+	require.Equal(t, c1, latest)
+
+	proof, err := st.GetExtensionProof(ctx, nil, s1, c2.Size)
+	require.NoError(t, err)
+	_, err = w.Update(ctx, note2, proof)
+	require.NoError(t, err)
+	latest, _ = w.Latest()
+	require.Equal(t, c2, latest)
+
+	// Rolling back, forking at the same size, or switching origin is refused.
+	_, err = w.Update(ctx, note1, MerkleExtensionProof{})
+	require.IsType(t, CheckpointRejectedError{}, err)
+	fork := c2
+	fork.Hash = c1.Hash
+	forkNote, err := SignCheckpoint(fork, logSigner)
+	require.NoError(t, err)
+	_, err = w.Update(ctx, forkNote, MerkleExtensionProof{})
+	require.IsType(t, CheckpointRejectedError{}, err)
+	other := c2
+	other.Origin = "other.example"
+	otherNote, err := SignCheckpoint(other, logSigner)
+	require.NoError(t, err)
+	_, err = w.Update(ctx, otherNote, MerkleExtensionProof{})
+	require.IsType(t, CheckpointRejectedError{}, err)
+
+	// Notes not signed by the log are refused.
+	forged, err := SignCheckpoint(c2, witSigner)
+	require.NoError(t, err)
+	_, err = w.Update(ctx, forged, MerkleExtensionProof{})
+	require.IsType(t, CheckpointRejectedError{}, err)
+}
@@ -2,6 +2,7 @@ package merkle
 
 import (
 	"fmt"
+	"runtime"
 
 	"FIRMER/vrf"
 )
@@ -36,14 +37,50 @@ type Config struct {
 	ConstructValueContainer func() interface{}
 
 	ECVRF vrf.ECVRF
+
+	// BatchableVRFProofs makes the tree set the VRFBatchPoints of the proofs
+	// it serves, so that verifiers can check their VRF proofs together. It
+	// adds two points to each proof, and costs the server one more VRF
+	// evaluation per proof, as only the VRF proofs are cached.
+	BatchableVRFProofs bool
+
+	// Params are the protocol parameters of the deployment, which domain
+	// separate the signatures on its tree heads.
+	Params ProtocolParams
+
+	// ParallelHideThreshold is the number of pairs from which a tree hides
+	// their keys in parallel. Zero means defaultParallelHideThreshold.
+	ParallelHideThreshold int
+
+	// HideWorkers is the number of keys a tree hides at once in parallel.
+	// Zero means GOMAXPROCS.
+	HideWorkers int
+}
+
+// defaultParallelHideThreshold is the default ParallelHideThreshold.
+const defaultParallelHideThreshold = 100
+
+func (c Config) parallelHideThreshold() int {
+	if c.ParallelHideThreshold <= 0 {
+		return defaultParallelHideThreshold
+	}
+	return c.ParallelHideThreshold
+}
+
+func (c Config) hideWorkers() int {
+	if c.HideWorkers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return c.HideWorkers
 }
 
 // NewConfig makes a new config object. It takes a a Hasher, logChildrenPerNode
 // which is the base 2 logarithm of the number of children per interior node,
 // maxValuesPerLeaf the maximum number of entries in a leaf before the leaf is
 // split into multiple nodes (at a lower level in the tree), keyByteLength the
-// length of the Keys which the tree will store, and a ConstructValueContainer function (so that
-// typed values can be pulled out of the Merkle Tree).
+// length of the Keys which the tree will store, a ConstructValueContainer function (so that
+// typed values can be pulled out of the Merkle Tree), and the VRF hiding the keys.
+// The keys are VRF outputs, so keyByteLength must be the output length of ecvrf.
 func NewConfig(e Encoder, logChildrenPerNode uint8, maxValuesPerLeaf int, keysByteLength int, constructValueFunc func() interface{},
 	ecvrf vrf.ECVRF) (Config, error) {
 	childrenPerNode := 1 << logChildrenPerNode
@@ -56,6 +93,11 @@ func NewConfig(e Encoder, logChildrenPerNode uint8, maxValuesPerLeaf int, keysBy
 	if logChildrenPerNode < 1 {
 		return Config{}, NewInvalidConfigError(fmt.Sprintf("Need at least 2 children per node, but logChildrenPerNode = %v", logChildrenPerNode))
 	}
+	if ecvrf != nil {
+		if n := ecvrf.Params().OutputLen(); n != 0 && n != keysByteLength {
+			return Config{}, NewInvalidConfigError(fmt.Sprintf("The VRF outputs %v bytes, but keysByteLength = %v", n, keysByteLength))
+		}
+	}
 	maxDepth := keysByteLength * 100000 / int(logChildrenPerNode)
 	return Config{Encoder: e, ChildrenPerNode: childrenPerNode,
 		MaxValuesPerLeaf: maxValuesPerLeaf, BitsPerIndex: logChildrenPerNode, KeysByteLength: keysByteLength,
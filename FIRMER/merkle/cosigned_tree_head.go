@@ -0,0 +1,152 @@
+package merkle
+
+import (
+	"fmt"
+	"sort"
+)
+
+// cosignedTreeHeadPrefix is prepended to the message auditors sign, so that
+// an auditor cosignature cannot be mistaken for a server tree head signature.
+var cosignedTreeHeadPrefix = []byte("FIRMER cosigned tree head v1\n")
+
+func cosignedTreeHeadMessage(sth SignedTreeHead) []byte {
+	msg := signedTreeHeadMessage(sth.Seqno, sth.Digest, sth.Timestamp)
+	return append(append([]byte{}, cosignedTreeHeadPrefix...), msg[len(signedTreeHeadPrefix):]...)
+}
+
+// Auditor is an independent party which cosigns tree heads it has checked.
+type Auditor struct {
+	ID        string
+	PublicKey *PublicKey
+	// Possession is the auditor's proof of possession of its private key,
+	// see PrivateKey.ProvePossession.
+	Possession *Signature
+}
+
+// AuditorSet is the set of auditors a client trusts, together with the
+// number of them which must cosign a tree head for the client to accept it.
+type AuditorSet struct {
+	params   ProtocolParams
+	auditors map[string]*PublicKey
+	quorum   int
+}
+
+// NewAuditorSet checks the proof of possession of every auditor and returns
+// the set, which checks signatures with params. quorum must be between 1 and
+// the number of auditors.
+func NewAuditorSet(params ProtocolParams, quorum int, auditors ...Auditor) (*AuditorSet, error) {
+	if quorum < 1 || quorum > len(auditors) {
+		return nil, fmt.Errorf("quorum %d is out of range for %d auditors", quorum, len(auditors))
+	}
+	set := &AuditorSet{params: params, auditors: make(map[string]*PublicKey), quorum: quorum}
+	for _, a := range auditors {
+		if _, found := set.auditors[a.ID]; found {
+			return nil, fmt.Errorf("duplicate auditor %q", a.ID)
+		}
+		if a.PublicKey == nil || !a.PublicKey.VerifyPossession(params, a.Possession) {
+			return nil, fmt.Errorf("invalid proof of possession for auditor %q", a.ID)
+		}
+		set.auditors[a.ID] = a.PublicKey
+	}
+	return set, nil
+}
+
+// Quorum returns the number of cosignatures required by the set.
+func (set *AuditorSet) Quorum() int {
+	return set.quorum
+}
+
+// Cosignature is one auditor's signature on a tree head.
+type Cosignature struct {
+	AuditorID string
+	Signature []byte
+}
+
+// CosignTreeHead is run by an auditor: it checks the server signature on sth
+// and cosigns it. Auditors are expected to have checked the tree head against
+// their own view of the directory (e.g. with an extension proof) beforehand.
+func CosignTreeHead(params ProtocolParams, auditorID string, sk *PrivateKey, sth SignedTreeHead, serverPK *PublicKey) (Cosignature, error) {
+	if err := sth.Verify(params, serverPK); err != nil {
+		return Cosignature{}, err
+	}
+	sig := sk.Sign(params, cosignedTreeHeadMessage(sth))
+	return Cosignature{AuditorID: auditorID, Signature: sig.ToBytes()}, nil
+}
+
+// CosignedTreeHead is a SignedTreeHead together with the aggregate signature
+// of the auditors listed in Signers.
+type CosignedTreeHead struct {
+	_struct          struct{}       `codec:",toarray"` //nolint
+	SignedTreeHead   SignedTreeHead `codec:"h"`
+	Signers          []string       `codec:"a"` // sorted auditor IDs
+	AuditorSignature []byte         `codec:"g"`
+}
+
+// Combine aggregates the cosignatures of auditors of the set into a
+// CosignedTreeHead. Every cosignature is checked, so that a single bad
+// cosignature is reported instead of producing an unverifiable result.
+func (set *AuditorSet) Combine(sth SignedTreeHead, cosigs []Cosignature) (CosignedTreeHead, error) {
+	msg := cosignedTreeHeadMessage(sth)
+	signers := make([]string, 0, len(cosigs))
+	sigs := make([]*Signature, 0, len(cosigs))
+	seen := make(map[string]bool)
+	for _, c := range cosigs {
+		pk, found := set.auditors[c.AuditorID]
+		if !found {
+			return CosignedTreeHead{}, NewInvalidCosignedTreeHeadError(sth.Seqno, fmt.Errorf("unknown auditor %q", c.AuditorID))
+		}
+		if seen[c.AuditorID] {
+			return CosignedTreeHead{}, NewInvalidCosignedTreeHeadError(sth.Seqno, fmt.Errorf("duplicate auditor %q", c.AuditorID))
+		}
+		seen[c.AuditorID] = true
+		sig, err := SignatureFromBytes(c.Signature)
+		if err != nil {
+			return CosignedTreeHead{}, NewInvalidCosignedTreeHeadError(sth.Seqno, err)
+		}
+		if !pk.Verify(set.params, msg, sig) {
+			return CosignedTreeHead{}, NewInvalidCosignedTreeHeadError(sth.Seqno, fmt.Errorf("bad cosignature from auditor %q", c.AuditorID))
+		}
+		signers = append(signers, c.AuditorID)
+		sigs = append(sigs, sig)
+	}
+	if len(sigs) < set.quorum {
+		return CosignedTreeHead{}, NewQuorumNotReachedError(len(sigs), set.quorum)
+	}
+	agg, err := AggregateSignatures(sigs...)
+	if err != nil {
+		return CosignedTreeHead{}, err
+	}
+	sort.Strings(signers)
+	return CosignedTreeHead{SignedTreeHead: sth, Signers: signers, AuditorSignature: agg.ToBytes()}, nil
+}
+
+// Verify checks the server signature on the tree head and that a quorum of
+// auditors of the set cosigned it.
+func (set *AuditorSet) Verify(cth CosignedTreeHead, serverPK *PublicKey) error {
+	sth := cth.SignedTreeHead
+	if err := sth.Verify(set.params, serverPK); err != nil {
+		return err
+	}
+	pks := make([]*PublicKey, 0, len(cth.Signers))
+	for i, id := range cth.Signers {
+		if i > 0 && cth.Signers[i-1] >= id {
+			return NewInvalidCosignedTreeHeadError(sth.Seqno, fmt.Errorf("signers are not sorted and unique"))
+		}
+		pk, found := set.auditors[id]
+		if !found {
+			return NewInvalidCosignedTreeHeadError(sth.Seqno, fmt.Errorf("unknown auditor %q", id))
+		}
+		pks = append(pks, pk)
+	}
+	if len(pks) < set.quorum {
+		return NewQuorumNotReachedError(len(pks), set.quorum)
+	}
+	sig, err := SignatureFromBytes(cth.AuditorSignature)
+	if err != nil {
+		return NewInvalidCosignedTreeHeadError(sth.Seqno, err)
+	}
+	if !VerifyAggregate(set.params, pks, cosignedTreeHeadMessage(sth), sig) {
+		return NewInvalidCosignedTreeHeadError(sth.Seqno, fmt.Errorf("bad aggregate signature"))
+	}
+	return nil
+}
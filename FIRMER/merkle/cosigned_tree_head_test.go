@@ -0,0 +1,97 @@
+package merkle
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAuditors(t *testing.T, n int) ([]Auditor, []*PrivateKey) {
+	var auditors []Auditor
+	var sks []*PrivateKey
+	for i := 0; i < n; i++ {
+		sk, err := GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		auditors = append(auditors, Auditor{ID: fmt.Sprintf("auditor%d", i), PublicKey: sk.GetPublicKey(), Possession: sk.ProvePossession(testParams)})
+		sks = append(sks, sk)
+	}
+	return auditors, sks
+}
+
+func TestCosignedTreeHead(t *testing.T) {
+	server, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	serverPK := server.GetPublicKey()
+	sth := NewSignedTreeHead(testParams, server, 3, TransparencyDigest{9, 9, 9}, time.Now())
+
+	auditors, sks := newTestAuditors(t, 4)
+	set, err := NewAuditorSet(testParams, 3, auditors...)
+	require.NoError(t, err)
+	require.Equal(t, 3, set.Quorum())
+
+	var cosigs []Cosignature
+	for i := 3; i >= 1; i-- {
+		c, err := CosignTreeHead(testParams, auditors[i].ID, sks[i], sth, serverPK)
+		require.NoError(t, err)
+		cosigs = append(cosigs, c)
+	}
+
+	cth, err := set.Combine(sth, cosigs)
+	require.NoError(t, err)
+	require.Equal(t, []string{"auditor1", "auditor2", "auditor3"}, cth.Signers)
+	require.NoError(t, set.Verify(cth, serverPK))
+
+	// Below quorum.
+	_, err = set.Combine(sth, cosigs[:2])
+	require.IsType(t, QuorumNotReachedError{}, err)
+	short := cth
+	short.Signers = cth.Signers[:2]
+	require.IsType(t, QuorumNotReachedError{}, set.Verify(short, serverPK))
+
+	// Claiming a signer which did not sign.
+	lying := cth
+	lying.Signers = []string{"auditor0", "auditor1", "auditor2"}
+	require.IsType(t, InvalidCosignedTreeHeadError{}, set.Verify(lying, serverPK))
+
+	// Listing a signer twice to reach the quorum.
+	dup := cth
+	dup.Signers = []string{"auditor1", "auditor1", "auditor2"}
+	require.Error(t, set.Verify(dup, serverPK))
+	_, err = set.Combine(sth, append(cosigs[:2:2], cosigs[0]))
+	require.Error(t, err)
+
+	// Cosignatures on another tree head, or a bad server signature.
+	other := NewSignedTreeHead(testParams, server, 4, TransparencyDigest{9, 9, 9}, time.Now())
+	moved := cth
+	moved.SignedTreeHead = other
+	require.IsType(t, InvalidCosignedTreeHeadError{}, set.Verify(moved, serverPK))
+	forged := cth
+	forged.SignedTreeHead.Digest = TransparencyDigest{1}
+	require.IsType(t, InvalidSignedTreeHeadError{}, set.Verify(forged, serverPK))
+
+	// A server signature can't be passed off as an auditor cosignature.
+	_, err = set.Combine(sth, []Cosignature{{AuditorID: "auditor0", Signature: sth.Signature}, cosigs[0], cosigs[1]})
+	require.IsType(t, InvalidCosignedTreeHeadError{}, err)
+
+	// Auditors refuse to cosign a tree head with a bad server signature.
+	_, err = CosignTreeHead(testParams, auditors[0].ID, sks[0], forged.SignedTreeHead, serverPK)
+	require.Error(t, err)
+}
+
+func TestNewAuditorSetErrors(t *testing.T) {
+	auditors, sks := newTestAuditors(t, 2)
+	_, err := NewAuditorSet(testParams, 0, auditors...)
+	require.Error(t, err)
+	_, err = NewAuditorSet(testParams, 3, auditors...)
+	require.Error(t, err)
+	_, err = NewAuditorSet(testParams, 1, auditors[0], auditors[0])
+	require.Error(t, err)
+
+	bad := auditors[1]
+	bad.Possession = sks[0].ProvePossession(testParams)
+	_, err = NewAuditorSet(testParams, 1, auditors[0], bad)
+	require.Error(t, err)
+}
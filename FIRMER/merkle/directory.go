@@ -0,0 +1,302 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"FIRMER/logger"
+
+	"github.com/cloudflare/bn256"
+	"github.com/pkg/errors"
+)
+
+// The FIRMER key directory is made of two RZKS trees which advance together,
+// one epoch at a time:
+//
+//   - tree A holds every device key record ever published, under the label
+//     DirectoryLabel(ID_DU, version), with versions counting up from 1;
+//   - tree O holds the labels of the records which have been revoked.
+//
+// The directory commitment of an epoch is com = hbar(com_A || com_O). A record
+// is current if its label is in A, its label is not in O and the label of the
+// next version is not in A. PubKeyReq proves all three against com.
+
+// directoryRevokedValue is the value stored in tree O for revoked labels.
+const directoryRevokedValue = "revoked"
+
+// DirectoryLabel returns the label of version v of the record of ID.
+func DirectoryLabel(ID []byte, v uint32) Key {
+	label := make([]byte, len(ID)+5)
+	copy(label, ID)
+	binary.BigEndian.PutUint32(label[len(ID)+1:], v)
+	return label
+}
+
+// DirectoryRecord is the key material published for a device.
+type DirectoryRecord struct {
+	ID   []byte
+	PK_U *PublicKey
+	Q_DU *bn256.G1
+}
+
+// value encodes the record as the string stored in tree A: the hex of the
+// encodings of PK_U and Q_DU.
+func (r DirectoryRecord) value() string {
+	return hex.EncodeToString(append(r.PK_U.Encode(), EncodeG1(EncodingTypeDevicePublicKey, r.Q_DU)...))
+}
+
+func parseDirectoryRecord(ID []byte, value string) (DirectoryRecord, error) {
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		return DirectoryRecord{}, err
+	}
+	// The encoding of a public key, other than the identity, takes 131 bytes.
+	if len(b) < 131 {
+		return DirectoryRecord{}, fmt.Errorf("invalid directory record length %d", len(b))
+	}
+	PK_U, err := DecodePublicKey(b[:131])
+	if err != nil {
+		return DirectoryRecord{}, err
+	}
+	Q_DU, err := DecodeG1(EncodingTypeDevicePublicKey, b[131:])
+	if err != nil {
+		return DirectoryRecord{}, err
+	}
+	return DirectoryRecord{ID: ID, PK_U: PK_U, Q_DU: Q_DU}, nil
+}
+
+// DirectoryCommitment is the pair of tree digests of one directory epoch.
+type DirectoryCommitment struct {
+	_struct struct{}           `codec:",toarray"` //nolint
+	Seqno   Seqno              `codec:"s"`
+	A       TransparencyDigest `codec:"a"`
+	O       TransparencyDigest `codec:"o"`
+}
+
+// Digest returns com = hbar(com_A || com_O), which clients trust.
+func (c DirectoryCommitment) Digest() []byte {
+	return hbar(append(append([]byte{}, c.A...), c.O...))
+}
+
+// PubKeyProof is the answer to PubKeyReq: the current record of a device with
+// the proofs that it is current at some directory epoch.
+type PubKeyProof struct {
+	_struct    struct{}             `codec:",toarray"` //nolint
+	Commitment DirectoryCommitment  `codec:"c"`
+	Version    uint32               `codec:"v"`
+	Record     string               `codec:"r"`
+	Inclusion  MerkleInclusionProof `codec:"i"`
+	NotRevoked MerkleInclusionProof `codec:"o"`
+	NoNewer    MerkleInclusionProof `codec:"n"`
+}
+
+// KeyDirectory is the server side of the directory.
+type KeyDirectory struct {
+	sync.Mutex
+
+	A, O *Tree
+
+	versions   map[string]uint32
+	commitment DirectoryCommitment
+}
+
+// NewKeyDirectory returns an empty directory with trees A and O. The trees
+// must store string values, as the ones from Init(GenPP()) do.
+func NewKeyDirectory(A, O *Tree) *KeyDirectory {
+	return &KeyDirectory{A: A, O: O, versions: make(map[string]uint32)}
+}
+
+// Commitment returns the commitment of the latest epoch.
+func (d *KeyDirectory) Commitment() DirectoryCommitment {
+	d.Lock()
+	defer d.Unlock()
+	return d.commitment
+}
+
+// Apply publishes one epoch: each record in publish becomes the next version
+// for its ID, and the current version of each ID in revoke is revoked. The
+// epoch is checked in full before either tree is built, so that an invalid
+// one leaves both trees as they were.
+func (d *KeyDirectory) Apply(ctx logger.ContextInterface, publish []DirectoryRecord, revoke [][]byte) (DirectoryCommitment, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	versions := make(map[string]uint32)
+	var added, revoked []KeyValuePair
+	for _, r := range publish {
+		if _, ok := versions[string(r.ID)]; ok {
+			return DirectoryCommitment{}, fmt.Errorf("two records for %q in one epoch", r.ID)
+		}
+		v := d.versions[string(r.ID)] + 1
+		versions[string(r.ID)] = v
+		added = append(added, KeyValuePair{Key: DirectoryLabel(r.ID, v), Value: r.value()})
+	}
+	revokedIDs := make(map[string]bool)
+	for _, ID := range revoke {
+		v, ok := d.versions[string(ID)]
+		if !ok {
+			return DirectoryCommitment{}, fmt.Errorf("no record to revoke for %q", ID)
+		}
+		if revokedIDs[string(ID)] {
+			continue
+		}
+		revokedIDs[string(ID)] = true
+		revoked = append(revoked, KeyValuePair{Key: DirectoryLabel(ID, v), Value: directoryRevokedValue})
+	}
+
+	// A and O must stay at the same Seqno: if O is not built, the epoch of A
+	// is rolled back too.
+	if err := ctxErr(ctx); err != nil {
+		return DirectoryCommitment{}, err
+	}
+	end, err := d.beginApply(ctx)
+	if err != nil {
+		return DirectoryCommitment{}, err
+	}
+	sA, comA, err := d.A.Build(ctx, nil, added, nil, false)
+	if err != nil {
+		return DirectoryCommitment{}, end(err)
+	}
+	sO, comO, err := d.O.Build(ctx, nil, revoked, nil, false)
+	if err != nil {
+		return DirectoryCommitment{}, end(err)
+	}
+	if sA != sO {
+		return DirectoryCommitment{}, end(fmt.Errorf("directory trees out of step at seqnos %d and %d", sA, sO))
+	}
+	if err := end(nil); err != nil {
+		return DirectoryCommitment{}, err
+	}
+	for ID, v := range versions {
+		d.versions[ID] = v
+	}
+	d.commitment = DirectoryCommitment{Seqno: sA, A: comA, O: comO}
+	return d.commitment, nil
+}
+
+// beginApply takes a savepoint on the engines of A and O, and returns the
+// function to call with the error of the epoch when it ends. If the epoch
+// failed, that function undoes the writes to both trees. Engines which are
+// not RollbackEngines are left to the caller, as for Tree.Build.
+func (d *KeyDirectory) beginApply(ctx logger.ContextInterface) (end func(error) error, err error) {
+	var engines []RollbackEngine
+	for _, t := range []*Tree{d.A, d.O} {
+		if re, ok := t.eng.(RollbackEngine); ok {
+			if err := re.Savepoint(ctx, nil); err != nil {
+				for j := len(engines) - 1; j >= 0; j-- {
+					engines[j].ReleaseSavepoint(ctx, nil)
+				}
+				return nil, err
+			}
+			engines = append(engines, re)
+		}
+	}
+	return func(err error) error {
+		for j := len(engines) - 1; j >= 0; j-- {
+			if err == nil {
+				if rerr := engines[j].ReleaseSavepoint(ctx, nil); rerr != nil {
+					return rerr
+				}
+			} else if rerr := engines[j].RollbackToSavepoint(ctx, nil); rerr != nil {
+				return errors.Wrapf(err, "rollback failed: %v", rerr)
+			}
+		}
+		return err
+	}, nil
+}
+
+// PubKeyReq returns the current record of ID with its proofs at the latest
+// epoch. It fails if the record of ID was revoked.
+func (d *KeyDirectory) PubKeyReq(ctx logger.ContextInterface, ID []byte) (PubKeyProof, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	v, ok := d.versions[string(ID)]
+	if !ok {
+		return PubKeyProof{}, fmt.Errorf("no record for %q", ID)
+	}
+	s := d.commitment.Seqno
+	label := DirectoryLabel(ID, v)
+
+	found, value, inclusion, err := d.A.QueryKey(ctx, nil, s, label)
+	if err != nil {
+		return PubKeyProof{}, err
+	}
+	record, isString := value.(string)
+	if !found || !isString {
+		return PubKeyProof{}, fmt.Errorf("record %d of %q is missing from tree A", v, ID)
+	}
+	revoked, _, notRevoked, err := d.O.QueryKey(ctx, nil, s, label)
+	if err != nil {
+		return PubKeyProof{}, err
+	}
+	if revoked {
+		return PubKeyProof{}, NewKeyRevokedError(ID, v)
+	}
+	_, _, noNewer, err := d.A.QueryKey(ctx, nil, s, DirectoryLabel(ID, v+1))
+	if err != nil {
+		return PubKeyProof{}, err
+	}
+	return PubKeyProof{
+		Commitment: d.commitment,
+		Version:    v,
+		Record:     record,
+		Inclusion:  inclusion,
+		NotRevoked: notRevoked,
+		NoNewer:    noNewer,
+	}, nil
+}
+
+// DirectoryVerifier checks PubKeyReq proofs against a trusted directory
+// commitment com.
+type DirectoryVerifier struct {
+	verifier MerkleProofVerifier
+	trusted  []byte
+}
+
+// NewDirectoryVerifier returns a verifier trusting the directory commitment
+// digest com (see DirectoryCommitment.Digest).
+func NewDirectoryVerifier(cfg Config, com []byte) *DirectoryVerifier {
+	return &DirectoryVerifier{verifier: NewMerkleProofVerifier(cfg), trusted: com}
+}
+
+// Commitment returns the trusted directory commitment digest.
+func (v *DirectoryVerifier) Commitment() []byte {
+	return v.trusted
+}
+
+// Verify checks that p proves the current record of ID at the trusted
+// directory commitment and returns it as an AKEPeer.
+func (v *DirectoryVerifier) Verify(ctx logger.ContextInterface, ID []byte, p PubKeyProof) (AKEPeer, error) {
+	if !bytes.Equal(p.Commitment.Digest(), v.trusted) {
+		return AKEPeer{}, NewKeyOutdatedError(ID, p.Version, fmt.Errorf("proof is not for the trusted directory commitment"))
+	}
+	label := DirectoryLabel(ID, p.Version)
+	errs := v.verifier.VerifyProofs(ctx, []ProofToVerify{
+		{KVP: KeyValuePair{Key: label, Value: p.Record}, Proof: &p.Inclusion, ExpRootHash: p.Commitment.A},
+		{KVP: KeyValuePair{Key: label}, Proof: &p.NotRevoked, ExpRootHash: p.Commitment.O},
+		{KVP: KeyValuePair{Key: DirectoryLabel(ID, p.Version+1)}, Proof: &p.NoNewer, ExpRootHash: p.Commitment.A},
+	})
+	if errs[0] != nil {
+		return AKEPeer{}, errs[0]
+	}
+	if errs[1] != nil {
+		// Only a valid proof that the label is in O shows a revocation.
+		revoked := KeyValuePair{Key: label, Value: directoryRevokedValue}
+		if v.verifier.VerifyInclusionProof(ctx, revoked, &p.NotRevoked, p.Commitment.O) == nil {
+			return AKEPeer{}, NewKeyRevokedError(ID, p.Version)
+		}
+		return AKEPeer{}, errs[1]
+	}
+	if errs[2] != nil {
+		return AKEPeer{}, NewKeyOutdatedError(ID, p.Version, errs[2])
+	}
+	r, err := parseDirectoryRecord(ID, p.Record)
+	if err != nil {
+		return AKEPeer{}, err
+	}
+	return AKEPeer{ID: ID, Q_DU: r.Q_DU, PK_U: r.PK_U}, nil
+}
@@ -0,0 +1,154 @@
+package merkle
+
+import (
+	"context"
+	"testing"
+
+	"FIRMER/logger"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyDirectoryApplyKeepsTreesInStep(t *testing.T) {
+	ctx, d, _, self1, self2 := akeTestSetup(t)
+	com := d.Commitment()
+
+	// An invalid epoch builds neither tree.
+	d3 := newAKETestDevice(t, []byte("device3"), []byte("password3"), 13579)
+	_, err := d.Apply(ctx, []DirectoryRecord{d3.record}, [][]byte{[]byte("unknown")})
+	require.Error(t, err)
+	_, err = d.Apply(ctx, []DirectoryRecord{d3.record, d3.record}, nil)
+	require.Error(t, err)
+	require.Equal(t, com, d.Commitment())
+	sA, _, _, err := d.A.GetLatestRoot(ctx, nil)
+	require.NoError(t, err)
+	sO, _, _, err := d.O.GetLatestRoot(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, com.Seqno, sA)
+	require.Equal(t, com.Seqno, sO)
+
+	// Revoking an ID twice in one epoch revokes it once.
+	com, err = d.Apply(ctx, []DirectoryRecord{d3.record}, [][]byte{self1.ID, self1.ID})
+	require.NoError(t, err)
+	_, err = d.PubKeyReq(ctx, self1.ID)
+	require.IsType(t, KeyRevokedError{}, err)
+	p3, err := d.PubKeyReq(ctx, d3.self.ID)
+	require.NoError(t, err)
+	_, err = NewDirectoryVerifier(GenPP(), com.Digest()).Verify(ctx, d3.self.ID, p3)
+	require.NoError(t, err)
+
+	com, err = d.Apply(ctx, nil, [][]byte{self2.ID})
+	require.NoError(t, err)
+	require.Equal(t, p3.Commitment.Seqno+1, com.Seqno)
+}
+
+func TestDirectoryVerifierRevocation(t *testing.T) {
+	ctx, d, _, self1, _ := akeTestSetup(t)
+	com, err := d.Apply(ctx, nil, [][]byte{self1.ID})
+	require.NoError(t, err)
+	v := NewDirectoryVerifier(GenPP(), com.Digest())
+
+	// A proof against the latest commitment that shows the label in O.
+	p := self1.KeyProof
+	label := DirectoryLabel(self1.ID, p.Version)
+	p.Commitment = com
+	_, _, p.Inclusion, err = d.A.QueryKey(ctx, nil, com.Seqno, label)
+	require.NoError(t, err)
+	_, _, p.NoNewer, err = d.A.QueryKey(ctx, nil, com.Seqno, DirectoryLabel(self1.ID, p.Version+1))
+	require.NoError(t, err)
+	revoked, _, inO, err := d.O.QueryKey(ctx, nil, com.Seqno, label)
+	require.NoError(t, err)
+	require.True(t, revoked)
+	p.NotRevoked = inO
+	_, err = v.Verify(ctx, self1.ID, p)
+	require.IsType(t, KeyRevokedError{}, err)
+
+	// A malformed proof is a verification failure, not a revocation.
+	p.NotRevoked = MerkleInclusionProof{}
+	_, err = v.Verify(ctx, self1.ID, p)
+	require.Error(t, err)
+	require.IsType(t, ProofVerificationFailedError{}, err)
+}
+
+// cancellingEngine cancels the context of the directory once a Build on it
+// succeeded, between the builds of A and O.
+type cancellingEngine struct {
+	*InMemoryStorageEngine
+	cancel func()
+}
+
+func (e *cancellingEngine) ReleaseSavepoint(ctx logger.ContextInterface, t Transaction) error {
+	if e.cancel != nil {
+		e.cancel()
+	}
+	return e.InMemoryStorageEngine.ReleaseSavepoint(ctx, t)
+}
+
+func TestKeyDirectoryApplyCancelled(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	pp := GenPP()
+	eng := &cancellingEngine{InMemoryStorageEngine: NewInMemoryStorageEngine(pp)}
+	A, err := NewTree(pp, 2, eng, RootVersionV1)
+	require.NoError(t, err)
+	d := NewKeyDirectory(A, Init(pp))
+	d1 := newAKETestDevice(t, []byte("device1"), []byte("password1"), 12345)
+	com, err := d.Apply(ctx, []DirectoryRecord{d1.record}, nil)
+	require.NoError(t, err)
+	nodes := len(eng.Nodes)
+
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eng.cancel = cancel
+	d2 := newAKETestDevice(t, []byte("device2"), []byte("password2"), 67890)
+	_, err = d.Apply(logger.NewContext(cctx, logger.NewTestLogger(t)), []DirectoryRecord{d2.record}, [][]byte{d1.self.ID})
+	require.ErrorIs(t, err, context.Canceled)
+
+	// A was rolled back with O.
+	require.Equal(t, com, d.Commitment())
+	sA, _, _, err := d.A.GetLatestRoot(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, com.Seqno, sA)
+	require.Len(t, eng.Nodes, nodes)
+
+	// The directory publishes the next epoch normally.
+	eng.cancel = nil
+	com, err = d.Apply(ctx, []DirectoryRecord{d2.record}, [][]byte{d1.self.ID})
+	require.NoError(t, err)
+	p2, err := d.PubKeyReq(ctx, d2.self.ID)
+	require.NoError(t, err)
+	_, err = NewDirectoryVerifier(pp, com.Digest()).Verify(ctx, d2.self.ID, p2)
+	require.NoError(t, err)
+	_, err = d.PubKeyReq(ctx, d1.self.ID)
+	require.IsType(t, KeyRevokedError{}, err)
+
+	// An epoch cancelled before it starts builds neither tree.
+	_, err = d.Apply(logger.NewContext(cctx, logger.NewTestLogger(t)), nil, [][]byte{d2.self.ID})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, com, d.Commitment())
+}
+
+func TestInMemoryStorageEngineNestedSavepoints(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	cfg, err := newConfigForTestWithVRF(SHA512_256Encoder{}, 1, 1)
+	require.NoError(t, err)
+	eng := NewInMemoryStorageEngine(cfg)
+	tree, err := NewTree(cfg, 2, eng, RootVersionV1)
+	require.NoError(t, err)
+
+	// A released Build is undone with the enclosing savepoint.
+	require.NoError(t, eng.Savepoint(ctx, nil))
+	_, _, err = tree.Build(ctx, nil, GenerateInitS(1, 10), nil, false)
+	require.NoError(t, err)
+	require.NoError(t, eng.RollbackToSavepoint(ctx, nil))
+	_, _, _, err = tree.GetLatestRoot(ctx, nil)
+	require.Error(t, err)
+	require.Error(t, eng.ReleaseSavepoint(ctx, nil))
+
+	require.NoError(t, eng.Savepoint(ctx, nil))
+	s, _, err := tree.Build(ctx, nil, GenerateInitS(1, 10), nil, false)
+	require.NoError(t, err)
+	require.NoError(t, eng.ReleaseSavepoint(ctx, nil))
+	latest, _, _, err := tree.GetLatestRoot(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, s, latest)
+}
@@ -0,0 +1,81 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/cloudflare/bn256"
+)
+
+// DLEQProof is a Chaum-Pedersen proof that log_g(K) == log_P(Q) in G1, where g
+// is the generator of G1. In the OPRF it shows that sigma = k_U*pw_U* was
+// computed with the key k_U behind the published value K = k_U*g.
+type DLEQProof struct {
+	_struct struct{} `codec:",toarray"` //nolint
+	C       []byte   `codec:"c"`
+	S       []byte   `codec:"s"`
+}
+
+// OPRFPublicValue returns K = k_U*g, which the OPRF server publishes so that
+// clients can check its evaluations.
+func OPRFPublicValue(k_U *big.Int) *bn256.G1 {
+	return new(bn256.G1).ScalarBaseMult(k_U)
+}
+
+// dleqChallenge hashes the statement and commitments under the DLEQ tag of
+// params, which separates it from other hashes.
+func dleqChallenge(params ProtocolParams, K, P, Q, A1, A2 *bn256.G1) *big.Int {
+	h := sha256.New()
+	h.Write(params.dleqTag)
+	for _, p := range []*bn256.G1{g1Generator(), K, P, Q, A1, A2} {
+		h.Write(p.Marshal())
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(h.Sum(nil)), bn256.Order)
+}
+
+func g1Generator() *bn256.G1 {
+	return new(bn256.G1).ScalarBaseMult(big.NewInt(1))
+}
+
+// ProveDLEQ proves that K = k*g and Q = k*P for the same k.
+func ProveDLEQ(params ProtocolParams, k *big.Int, K, P, Q *bn256.G1) (DLEQProof, error) {
+	t, err := GenerateRandomInZp()
+	if err != nil {
+		return DLEQProof{}, err
+	}
+	A1 := new(bn256.G1).ScalarBaseMult(t)
+	A2 := new(bn256.G1).ScalarMult(P, t)
+	c := dleqChallenge(params, K, P, Q, A1, A2)
+
+	// s = t - c*k mod q
+	s := new(big.Int).Mul(c, k)
+	s.Sub(t, s)
+	s.Mod(s, bn256.Order)
+	return DLEQProof{C: c.Bytes(), S: s.Bytes()}, nil
+}
+
+// VerifyDLEQ checks a proof made by ProveDLEQ.
+func VerifyDLEQ(params ProtocolParams, K, P, Q *bn256.G1, proof DLEQProof) error {
+	if K == nil || P == nil || Q == nil || isG1Identity(K) {
+		return NewInvalidDLEQProofError(fmt.Errorf("missing statement point or identity K"))
+	}
+	if len(proof.C) > 32 || len(proof.S) > 32 {
+		return NewInvalidDLEQProofError(fmt.Errorf("proof scalars too long"))
+	}
+	c := new(big.Int).SetBytes(proof.C)
+	s := new(big.Int).SetBytes(proof.S)
+	if c.Cmp(bn256.Order) >= 0 || s.Cmp(bn256.Order) >= 0 {
+		return NewInvalidDLEQProofError(fmt.Errorf("proof scalars out of range"))
+	}
+
+	// A1 = s*g + c*K, A2 = s*P + c*Q
+	A1 := new(bn256.G1).ScalarBaseMult(s)
+	A1.Add(A1, new(bn256.G1).ScalarMult(K, c))
+	A2 := new(bn256.G1).ScalarMult(P, s)
+	A2.Add(A2, new(bn256.G1).ScalarMult(Q, c))
+	if dleqChallenge(params, K, P, Q, A1, A2).Cmp(c) != 0 {
+		return NewInvalidDLEQProofError(fmt.Errorf("challenge mismatch"))
+	}
+	return nil
+}
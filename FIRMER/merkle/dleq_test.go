@@ -0,0 +1,44 @@
+package merkle
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/cloudflare/bn256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDLEQ(t *testing.T) {
+	k, err := GenerateRandomInZp()
+	require.NoError(t, err)
+	K := OPRFPublicValue(k)
+	P := testParams.HashPassword([]byte("password"))
+	Q := new(bn256.G1).ScalarMult(P, k)
+
+	proof, err := ProveDLEQ(testParams, k, K, P, Q)
+	require.NoError(t, err)
+	require.NoError(t, VerifyDLEQ(testParams, K, P, Q, proof))
+
+	// Same proof with a different evaluation, base or public value.
+	other := new(bn256.G1).ScalarMult(P, big.NewInt(2))
+	require.IsType(t, InvalidDLEQProofError{}, VerifyDLEQ(testParams, K, P, other, proof))
+	require.IsType(t, InvalidDLEQProofError{}, VerifyDLEQ(testParams, K, other, Q, proof))
+	require.IsType(t, InvalidDLEQProofError{}, VerifyDLEQ(testParams, other, P, Q, proof))
+
+	// A proof for a different key does not verify against K.
+	k2 := new(big.Int).Add(k, big.NewInt(1))
+	proof2, err := ProveDLEQ(testParams, k2, OPRFPublicValue(k2), P, new(bn256.G1).ScalarMult(P, k2))
+	require.NoError(t, err)
+	require.Error(t, VerifyDLEQ(testParams, K, P, Q, proof2))
+
+	tampered := proof
+	tampered.S = bn256.Order.Bytes()
+	require.IsType(t, InvalidDLEQProofError{}, VerifyDLEQ(testParams, K, P, Q, tampered))
+	tampered.S = make([]byte, 33)
+	require.IsType(t, InvalidDLEQProofError{}, VerifyDLEQ(testParams, K, P, Q, tampered))
+
+	// Missing points and an identity K fail instead of panicking.
+	require.IsType(t, InvalidDLEQProofError{}, VerifyDLEQ(testParams, nil, P, Q, proof))
+	require.IsType(t, InvalidDLEQProofError{}, VerifyDLEQ(testParams, K, P, nil, proof))
+	require.IsType(t, InvalidDLEQProofError{}, VerifyDLEQ(testParams, OPRFPublicValue(big.NewInt(0)), P, Q, proof))
+}
@@ -0,0 +1,280 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/cloudflare/bn256"
+)
+
+// Keys and protocol values are encoded as one version byte, one EncodingType
+// byte and the value:
+//
+//   - scalars as 32 bytes big endian, in [1, bn256.Order);
+//   - G1 points as bn256.G1.Marshal, 64 bytes;
+//   - G2 points as bn256.G2.Marshal, 129 bytes;
+//   - GT elements as bn256.GT.Marshal, 384 bytes.
+//
+// Decoding only accepts the canonical encoding of a value of the right type,
+// and rejects points which are not on the curve, not in the prime order
+// subgroup, or the identity. The text form is PEM, whose type names the
+// EncodingType, around the binary form.
+
+const encodingVersion = 1
+
+// EncodingType tags the kind of value an encoding holds.
+type EncodingType uint8
+
+const (
+	// EncodingTypePublicKey is PK_U, in G2.
+	EncodingTypePublicKey EncodingType = iota + 1
+	// EncodingTypePrivateKey is s_U, a scalar.
+	EncodingTypePrivateKey
+	// EncodingTypeDeviceKey is S_DU, in G1.
+	EncodingTypeDeviceKey
+	// EncodingTypeDevicePublicKey is Q_DU, in G1.
+	EncodingTypeDevicePublicKey
+	// EncodingTypeEphemeralKey is V_U of SesKeyGen, in G2.
+	EncodingTypeEphemeralKey
+	// EncodingTypeSessionSecret is K_U of SesKeyGen, in GT.
+	EncodingTypeSessionSecret
+	// EncodingTypeSignature is a BLS signature, in G1.
+	EncodingTypeSignature
+)
+
+var encodingTypePEMNames = map[EncodingType]string{
+	EncodingTypePublicKey:       "FIRMER PUBLIC KEY",
+	EncodingTypePrivateKey:      "FIRMER PRIVATE KEY",
+	EncodingTypeDeviceKey:       "FIRMER DEVICE KEY",
+	EncodingTypeDevicePublicKey: "FIRMER DEVICE PUBLIC KEY",
+	EncodingTypeEphemeralKey:    "FIRMER EPHEMERAL KEY",
+	EncodingTypeSessionSecret:   "FIRMER SESSION SECRET",
+	EncodingTypeSignature:       "FIRMER SIGNATURE",
+}
+
+func (t EncodingType) String() string {
+	if name, ok := encodingTypePEMNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown encoding type %d", uint8(t))
+}
+
+const scalarLength = 32
+
+func encodeTagged(typ EncodingType, body []byte) []byte {
+	return append([]byte{encodingVersion, byte(typ)}, body...)
+}
+
+// decodeTagged checks the version and type of b and returns its body.
+func decodeTagged(typ EncodingType, b []byte) ([]byte, error) {
+	if len(b) < 2 {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("too short"))
+	}
+	if b[0] != encodingVersion {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("unknown version %d", b[0]))
+	}
+	if got := EncodingType(b[1]); got != typ {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("found a %s", got))
+	}
+	return b[2:], nil
+}
+
+func decodeScalar(typ EncodingType, b []byte) (*big.Int, error) {
+	if len(b) != scalarLength {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("scalar of %d bytes", len(b)))
+	}
+	x := new(big.Int).SetBytes(b)
+	if x.Sign() == 0 || x.Cmp(bn256.Order) >= 0 {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("scalar out of range"))
+	}
+	return x, nil
+}
+
+func decodeG1(typ EncodingType, b []byte) (*bn256.G1, error) {
+	p := new(bn256.G1)
+	rest, err := p.Unmarshal(b)
+	if err != nil {
+		return nil, NewInvalidEncodingError(typ, err)
+	}
+	// G1 has cofactor 1, so a point on the curve is in the subgroup.
+	if len(rest) != 0 || !bytes.Equal(p.Marshal(), b) {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("non-canonical G1 point"))
+	}
+	if isG1Identity(p) {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("point at infinity"))
+	}
+	return p, nil
+}
+
+func decodeG2(typ EncodingType, b []byte) (*bn256.G2, error) {
+	p := new(bn256.G2)
+	rest, err := p.Unmarshal(b)
+	if err != nil {
+		return nil, NewInvalidEncodingError(typ, err)
+	}
+	if len(rest) != 0 || !bytes.Equal(p.Marshal(), b) {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("non-canonical G2 point"))
+	}
+	if isG2Identity(p) {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("point at infinity"))
+	}
+	if !isG2Identity(new(bn256.G2).ScalarMult(p, bn256.Order)) {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("G2 point not in the prime order subgroup"))
+	}
+	return p, nil
+}
+
+func decodeGT(typ EncodingType, b []byte) (*bn256.GT, error) {
+	e := new(bn256.GT)
+	rest, err := e.Unmarshal(b)
+	if err != nil {
+		return nil, NewInvalidEncodingError(typ, err)
+	}
+	if len(rest) != 0 || !bytes.Equal(e.Marshal(), b) {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("non-canonical GT element"))
+	}
+	one := new(bn256.GT).ScalarBaseMult(big.NewInt(0)).Marshal()
+	if bytes.Equal(e.Marshal(), one) {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("identity element"))
+	}
+	if !bytes.Equal(new(bn256.GT).ScalarMult(e, bn256.Order).Marshal(), one) {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("GT element not in the prime order subgroup"))
+	}
+	return e, nil
+}
+
+// Encode returns the binary encoding of the public key.
+func (pubKey *PublicKey) Encode() []byte {
+	return encodeTagged(EncodingTypePublicKey, pubKey.gx.Marshal())
+}
+
+// DecodePublicKey decodes a public key encoded with PublicKey.Encode.
+func DecodePublicKey(b []byte) (*PublicKey, error) {
+	body, err := decodeTagged(EncodingTypePublicKey, b)
+	if err != nil {
+		return nil, err
+	}
+	gx, err := decodeG2(EncodingTypePublicKey, body)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{gx: gx}, nil
+}
+
+// Encode returns the binary encoding of the private key.
+func (privKey *PrivateKey) Encode() []byte {
+	body := make([]byte, scalarLength)
+	privKey.x.FillBytes(body)
+	return encodeTagged(EncodingTypePrivateKey, body)
+}
+
+// DecodePrivateKey decodes a private key encoded with PrivateKey.Encode.
+func DecodePrivateKey(b []byte) (*PrivateKey, error) {
+	body, err := decodeTagged(EncodingTypePrivateKey, b)
+	if err != nil {
+		return nil, err
+	}
+	x, err := decodeScalar(EncodingTypePrivateKey, body)
+	if err != nil {
+		return nil, err
+	}
+	var privKey PrivateKey
+	if err := privKey.FromBytes(x.Bytes()); err != nil {
+		return nil, err
+	}
+	return &privKey, nil
+}
+
+// Encode returns the binary encoding of the signature.
+func (sig *Signature) Encode() []byte {
+	return encodeTagged(EncodingTypeSignature, sig.s.Marshal())
+}
+
+// DecodeSignature decodes a signature encoded with Signature.Encode.
+func DecodeSignature(b []byte) (*Signature, error) {
+	body, err := decodeTagged(EncodingTypeSignature, b)
+	if err != nil {
+		return nil, err
+	}
+	s, err := decodeG1(EncodingTypeSignature, body)
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{s: s}, nil
+}
+
+// EncodeG1 encodes a G1 value of type typ, i.e. EncodingTypeDeviceKey or
+// EncodingTypeDevicePublicKey.
+func EncodeG1(typ EncodingType, p *bn256.G1) []byte {
+	return encodeTagged(typ, p.Marshal())
+}
+
+// DecodeG1 decodes a G1 value of type typ encoded with EncodeG1.
+func DecodeG1(typ EncodingType, b []byte) (*bn256.G1, error) {
+	body, err := decodeTagged(typ, b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeG1(typ, body)
+}
+
+// EncodeG2 encodes a G2 value of type typ, i.e. EncodingTypeEphemeralKey.
+func EncodeG2(typ EncodingType, p *bn256.G2) []byte {
+	return encodeTagged(typ, p.Marshal())
+}
+
+// DecodeG2 decodes a G2 value of type typ encoded with EncodeG2.
+func DecodeG2(typ EncodingType, b []byte) (*bn256.G2, error) {
+	body, err := decodeTagged(typ, b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeG2(typ, body)
+}
+
+// EncodeGT encodes a GT value of type typ, i.e. EncodingTypeSessionSecret.
+func EncodeGT(typ EncodingType, e *bn256.GT) []byte {
+	return encodeTagged(typ, e.Marshal())
+}
+
+// DecodeGT decodes a GT value of type typ encoded with EncodeGT.
+func DecodeGT(typ EncodingType, b []byte) (*bn256.GT, error) {
+	body, err := decodeTagged(typ, b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGT(typ, body)
+}
+
+// EncodePEM returns the text form of a binary encoding.
+func EncodePEM(b []byte) ([]byte, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("encoding too short")
+	}
+	name, ok := encodingTypePEMNames[EncodingType(b[1])]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding type %d", b[1])
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: name, Bytes: b}), nil
+}
+
+// DecodePEM returns the binary encoding of type typ held in the text form
+// data. The result still has to be decoded with the function for typ.
+func DecodePEM(typ EncodingType, data []byte) ([]byte, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("no PEM block"))
+	}
+	if len(bytes.TrimSpace(rest)) != 0 || len(block.Headers) != 0 {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("unexpected data around the PEM block"))
+	}
+	if block.Type != typ.String() {
+		return nil, NewInvalidEncodingError(typ, fmt.Errorf("found a PEM block of type %q", block.Type))
+	}
+	if _, err := decodeTagged(typ, block.Bytes); err != nil {
+		return nil, err
+	}
+	return block.Bytes, nil
+}
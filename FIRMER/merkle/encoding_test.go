@@ -0,0 +1,128 @@
+package merkle
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/cloudflare/bn256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodingRoundTrips(t *testing.T) {
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pk := sk.GetPublicKey()
+	sig := sk.Sign(testParams, []byte("msg"))
+	_, g1, err := bn256.RandomG1(rand.Reader)
+	require.NoError(t, err)
+	_, g2, err := bn256.RandomG2(rand.Reader)
+	require.NoError(t, err)
+	gt := bn256.Pair(g1, g2)
+
+	b := pk.Encode()
+	require.Len(t, b, 131)
+	pk2, err := DecodePublicKey(b)
+	require.NoError(t, err)
+	require.Equal(t, pk.ToBytes(), pk2.ToBytes())
+
+	sk2, err := DecodePrivateKey(sk.Encode())
+	require.NoError(t, err)
+	require.Equal(t, sk.ToBytes(), sk2.ToBytes())
+	require.Equal(t, pk.ToBytes(), sk2.GetPublicKey().ToBytes())
+
+	sig2, err := DecodeSignature(sig.Encode())
+	require.NoError(t, err)
+	require.True(t, pk.Verify(testParams, []byte("msg"), sig2))
+
+	for _, typ := range []EncodingType{EncodingTypeDeviceKey, EncodingTypeDevicePublicKey} {
+		p, err := DecodeG1(typ, EncodeG1(typ, g1))
+		require.NoError(t, err)
+		require.Equal(t, g1.Marshal(), p.Marshal())
+	}
+	p2, err := DecodeG2(EncodingTypeEphemeralKey, EncodeG2(EncodingTypeEphemeralKey, g2))
+	require.NoError(t, err)
+	require.Equal(t, g2.Marshal(), p2.Marshal())
+	e, err := DecodeGT(EncodingTypeSessionSecret, EncodeGT(EncodingTypeSessionSecret, gt))
+	require.NoError(t, err)
+	require.Equal(t, gt.Marshal(), e.Marshal())
+
+	text, err := EncodePEM(pk.Encode())
+	require.NoError(t, err)
+	require.Contains(t, string(text), "-----BEGIN FIRMER PUBLIC KEY-----")
+	b, err = DecodePEM(EncodingTypePublicKey, text)
+	require.NoError(t, err)
+	require.Equal(t, pk.Encode(), b)
+}
+
+func TestEncodingRejects(t *testing.T) {
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pk := sk.GetPublicKey()
+	_, g1, err := bn256.RandomG1(rand.Reader)
+	require.NoError(t, err)
+
+	invalid := func(err error) {
+		t.Helper()
+		require.IsType(t, InvalidEncodingError{}, err)
+	}
+
+	// Wrong type, version, length.
+	_, err = DecodeG1(EncodingTypeDeviceKey, EncodeG1(EncodingTypeDevicePublicKey, g1))
+	invalid(err)
+	b := pk.Encode()
+	b[0] = 2
+	_, err = DecodePublicKey(b)
+	invalid(err)
+	_, err = DecodePublicKey(append(pk.Encode(), 0))
+	invalid(err)
+	_, err = DecodePublicKey(nil)
+	invalid(err)
+
+	// Identities and out of range scalars.
+	_, err = DecodePublicKey(encodeTagged(EncodingTypePublicKey, []byte{0}))
+	invalid(err)
+	_, err = DecodeG1(EncodingTypeDeviceKey, EncodeG1(EncodingTypeDeviceKey, new(bn256.G1).ScalarBaseMult(new(big.Int))))
+	invalid(err)
+	_, err = DecodePrivateKey(encodeTagged(EncodingTypePrivateKey, make([]byte, 32)))
+	invalid(err)
+	order := make([]byte, 32)
+	bn256.Order.FillBytes(order)
+	_, err = DecodePrivateKey(encodeTagged(EncodingTypePrivateKey, order))
+	invalid(err)
+	one := new(bn256.GT).ScalarBaseMult(new(big.Int))
+	_, err = DecodeGT(EncodingTypeSessionSecret, EncodeGT(EncodingTypeSessionSecret, one))
+	invalid(err)
+
+	// The same point with x + p instead of x.
+	p, _ := new(big.Int).SetString("65000549695646603732796438742359905742825358107623003571877145026864184071783", 10)
+	m := g1.Marshal()
+	x := new(big.Int).Add(new(big.Int).SetBytes(m[:32]), p)
+	if x.BitLen() <= 256 {
+		x.FillBytes(m[:32])
+		_, err = DecodeG1(EncodingTypeDeviceKey, encodeTagged(EncodingTypeDeviceKey, m))
+		invalid(err)
+	}
+
+	// A point off the curve.
+	m = g1.Marshal()
+	m[63] ^= 1
+	_, err = DecodeG1(EncodingTypeDeviceKey, encodeTagged(EncodingTypeDeviceKey, m))
+	invalid(err)
+
+	// An element of GT's field which is not in the order q subgroup.
+	m = bn256.Pair(g1, sk.GetPublicKey().gx).Marshal()
+	m[len(m)-1] ^= 1
+	_, err = DecodeGT(EncodingTypeSessionSecret, encodeTagged(EncodingTypeSessionSecret, m))
+	invalid(err)
+
+	// PEM blocks of another type or with extra data.
+	text, err := EncodePEM(pk.Encode())
+	require.NoError(t, err)
+	_, err = DecodePEM(EncodingTypePrivateKey, text)
+	invalid(err)
+	_, err = DecodePEM(EncodingTypePublicKey, append(text, text...))
+	invalid(err)
+	_, err = DecodePEM(EncodingTypePublicKey, []byte("not pem"))
+	invalid(err)
+}
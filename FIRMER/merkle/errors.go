@@ -1,6 +1,9 @@
 package merkle
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // InvalidConfigError happens when trying to construct an invalid tree configuration.
 type InvalidConfigError struct {
@@ -96,3 +99,359 @@ func (e InvalidSeqnoError) Error() string {
 func NewInvalidSeqnoError(s Seqno, reason error) InvalidSeqnoError {
 	return InvalidSeqnoError{s: s, reason: reason}
 }
+
+// InvalidJSONError is returned when a JSON encoded proof or root cannot be
+// decoded.
+type InvalidJSONError struct {
+	typ    string
+	reason string
+}
+
+func (e InvalidJSONError) Error() string {
+	return fmt.Sprintf("Invalid JSON Error (%s): %s", e.typ, e.reason)
+}
+
+// NewInvalidJSONError returns a new error
+func NewInvalidJSONError(typ string, reason string) InvalidJSONError {
+	return InvalidJSONError{typ: typ, reason: reason}
+}
+
+// InvalidSignedTreeHeadError is returned when a SignedTreeHead does not verify.
+type InvalidSignedTreeHeadError struct {
+	s      Seqno
+	reason error
+}
+
+func (e InvalidSignedTreeHeadError) Error() string {
+	return fmt.Sprintf("Invalid Signed Tree Head Error (Seqno: %v): %s", e.s, e.reason)
+}
+
+// NewInvalidSignedTreeHeadError returns a new error
+func NewInvalidSignedTreeHeadError(s Seqno, reason error) InvalidSignedTreeHeadError {
+	return InvalidSignedTreeHeadError{s: s, reason: reason}
+}
+
+// InvalidCosignedTreeHeadError is returned when the auditor signatures on a
+// CosignedTreeHead do not verify.
+type InvalidCosignedTreeHeadError struct {
+	s      Seqno
+	reason error
+}
+
+func (e InvalidCosignedTreeHeadError) Error() string {
+	return fmt.Sprintf("Invalid Cosigned Tree Head Error (Seqno: %v): %s", e.s, e.reason)
+}
+
+// NewInvalidCosignedTreeHeadError returns a new error
+func NewInvalidCosignedTreeHeadError(s Seqno, reason error) InvalidCosignedTreeHeadError {
+	return InvalidCosignedTreeHeadError{s: s, reason: reason}
+}
+
+// QuorumNotReachedError is returned when fewer auditors than required
+// cosigned a tree head.
+type QuorumNotReachedError struct {
+	got    int
+	quorum int
+}
+
+func (e QuorumNotReachedError) Error() string {
+	return fmt.Sprintf("Quorum Not Reached: %d cosignatures, %d required", e.got, e.quorum)
+}
+
+// NewQuorumNotReachedError returns a new error
+func NewQuorumNotReachedError(got int, quorum int) QuorumNotReachedError {
+	return QuorumNotReachedError{got: got, quorum: quorum}
+}
+
+// CheckpointRejectedError is returned when a witness refuses a checkpoint.
+type CheckpointRejectedError struct {
+	reason error
+}
+
+func (e CheckpointRejectedError) Error() string {
+	return fmt.Sprintf("Checkpoint Rejected: %s", e.reason)
+}
+
+// NewCheckpointRejectedError returns a new error
+func NewCheckpointRejectedError(reason error) CheckpointRejectedError {
+	return CheckpointRejectedError{reason: reason}
+}
+
+// InconsistentTreeHeadsError is returned when two tree heads could not be
+// shown to belong to the same history.
+type InconsistentTreeHeadsError struct {
+	s1     Seqno
+	s2     Seqno
+	reason error
+}
+
+func (e InconsistentTreeHeadsError) Error() string {
+	return fmt.Sprintf("Inconsistent Tree Heads (Seqnos: %v, %v): %s", e.s1, e.s2, e.reason)
+}
+
+// NewInconsistentTreeHeadsError returns a new error
+func NewInconsistentTreeHeadsError(s1 Seqno, s2 Seqno, reason error) InconsistentTreeHeadsError {
+	return InconsistentTreeHeadsError{s1: s1, s2: s2, reason: reason}
+}
+
+// UnexpectedMessageError is returned when a peer receives a different protocol
+// message than the one it expects next.
+type UnexpectedMessageError struct {
+	want MessageType
+	got  MessageType
+}
+
+func (e UnexpectedMessageError) Error() string {
+	return fmt.Sprintf("Unexpected Message: got type %d, want %d", e.got, e.want)
+}
+
+// NewUnexpectedMessageError returns a new error
+func NewUnexpectedMessageError(want MessageType, got MessageType) UnexpectedMessageError {
+	return UnexpectedMessageError{want: want, got: got}
+}
+
+// ProtocolStateError is returned when a protocol step is run out of order.
+type ProtocolStateError struct {
+	step  string
+	state string
+}
+
+func (e ProtocolStateError) Error() string {
+	return fmt.Sprintf("Protocol State Error: %s called in state %s", e.step, e.state)
+}
+
+// NewProtocolStateError returns a new error
+func NewProtocolStateError(step string, state string) ProtocolStateError {
+	return ProtocolStateError{step: step, state: state}
+}
+
+// MissingProtocolParamsError is returned when a protocol step is given the
+// zero ProtocolParams, whose domain tags belong to no deployment.
+type MissingProtocolParamsError struct {
+	step string
+}
+
+func (e MissingProtocolParamsError) Error() string {
+	return fmt.Sprintf("Missing protocol parameters: %s needs the parameters of a deployment", e.step)
+}
+
+// NewMissingProtocolParamsError returns a new error
+func NewMissingProtocolParamsError(step string) MissingProtocolParamsError {
+	return MissingProtocolParamsError{step: step}
+}
+
+// CommitmentMismatchError is returned when a revealed value does not match the
+// commitment sent earlier.
+type CommitmentMismatchError struct{}
+
+func (e CommitmentMismatchError) Error() string {
+	return "Commitment does not match the revealed values."
+}
+
+// NewCommitmentMismatchError returns a new error
+func NewCommitmentMismatchError() CommitmentMismatchError {
+	return CommitmentMismatchError{}
+}
+
+// SASMismatchError is returned when the user did not confirm that the short
+// authentication strings shown on both devices match.
+type SASMismatchError struct{}
+
+func (e SASMismatchError) Error() string {
+	return "Short authentication strings do not match."
+}
+
+// NewSASMismatchError returns a new error
+func NewSASMismatchError() SASMismatchError {
+	return SASMismatchError{}
+}
+
+// InvalidDLEQProofError is returned when a DLEQ proof does not verify, e.g.
+// because an OPRF evaluation was not made with the published server key.
+type InvalidDLEQProofError struct {
+	reason error
+}
+
+func (e InvalidDLEQProofError) Error() string {
+	return fmt.Sprintf("Invalid DLEQ proof: %s", e.reason)
+}
+
+// NewInvalidDLEQProofError returns a new error
+func NewInvalidDLEQProofError(reason error) InvalidDLEQProofError {
+	return InvalidDLEQProofError{reason: reason}
+}
+
+// UnexpectedPeerError is returned when a peer presents a different identity
+// than the one expected.
+type UnexpectedPeerError struct {
+	want []byte
+	got  []byte
+}
+
+func (e UnexpectedPeerError) Error() string {
+	return fmt.Sprintf("Unexpected peer: expected %q, got %q", e.want, e.got)
+}
+
+// NewUnexpectedPeerError returns a new error
+func NewUnexpectedPeerError(want, got []byte) UnexpectedPeerError {
+	return UnexpectedPeerError{want: want, got: got}
+}
+
+// KeyConfirmationError is returned when the key confirmation value of a peer
+// does not match, i.e. both sides did not derive the same key from the same
+// transcript.
+type KeyConfirmationError struct {
+	peer []byte
+}
+
+func (e KeyConfirmationError) Error() string {
+	return fmt.Sprintf("Key confirmation from peer %q failed.", e.peer)
+}
+
+// NewKeyConfirmationError returns a new error
+func NewKeyConfirmationError(peer []byte) KeyConfirmationError {
+	return KeyConfirmationError{peer: peer}
+}
+
+// KeyRevokedError is returned when the directory record of a device has been
+// revoked.
+type KeyRevokedError struct {
+	id      []byte
+	version uint32
+}
+
+func (e KeyRevokedError) Error() string {
+	return fmt.Sprintf("Key version %d of %q is revoked.", e.version, e.id)
+}
+
+// NewKeyRevokedError returns a new error
+func NewKeyRevokedError(id []byte, version uint32) KeyRevokedError {
+	return KeyRevokedError{id: id, version: version}
+}
+
+// KeyOutdatedError is returned when a directory record cannot be shown to be
+// the latest one of a device at the trusted directory commitment.
+type KeyOutdatedError struct {
+	id      []byte
+	version uint32
+	reason  error
+}
+
+func (e KeyOutdatedError) Error() string {
+	return fmt.Sprintf("Key version %d of %q is outdated: %s", e.version, e.id, e.reason)
+}
+
+// NewKeyOutdatedError returns a new error
+func NewKeyOutdatedError(id []byte, version uint32, reason error) KeyOutdatedError {
+	return KeyOutdatedError{id: id, version: version, reason: reason}
+}
+
+// MessageAuthenticationError is returned when a session message does not
+// decrypt, e.g. because it was tampered with.
+type MessageAuthenticationError struct{}
+
+func (e MessageAuthenticationError) Error() string {
+	return "Message authentication failed."
+}
+
+// NewMessageAuthenticationError returns a new error
+func NewMessageAuthenticationError() MessageAuthenticationError {
+	return MessageAuthenticationError{}
+}
+
+// ReplayedMessageError is returned when a session message was already
+// received.
+type ReplayedMessageError struct {
+	seqno uint64
+}
+
+func (e ReplayedMessageError) Error() string {
+	return fmt.Sprintf("Message %d was already received.", e.seqno)
+}
+
+// NewReplayedMessageError returns a new error
+func NewReplayedMessageError(seqno uint64) ReplayedMessageError {
+	return ReplayedMessageError{seqno: seqno}
+}
+
+// MessageOutOfWindowError is returned when a session message is too far from
+// the next expected one to be accepted.
+type MessageOutOfWindowError struct {
+	seqno uint64
+	next  uint64
+}
+
+func (e MessageOutOfWindowError) Error() string {
+	return fmt.Sprintf("Message %d is out of the window (next expected: %d).", e.seqno, e.next)
+}
+
+// NewMessageOutOfWindowError returns a new error
+func NewMessageOutOfWindowError(seqno, next uint64) MessageOutOfWindowError {
+	return MessageOutOfWindowError{seqno: seqno, next: next}
+}
+
+// InvalidEncodingError is returned when decoding a key or protocol value
+// fails.
+type InvalidEncodingError struct {
+	typ    EncodingType
+	reason error
+}
+
+func (e InvalidEncodingError) Error() string {
+	return fmt.Sprintf("Invalid encoding of a %s: %s", e.typ, e.reason)
+}
+
+// NewInvalidEncodingError returns a new error
+func NewInvalidEncodingError(typ EncodingType, reason error) InvalidEncodingError {
+	return InvalidEncodingError{typ: typ, reason: reason}
+}
+
+// OPRFKeyNotFoundError is returned when a user has no OPRF key.
+type OPRFKeyNotFoundError struct {
+	user []byte
+}
+
+func (e OPRFKeyNotFoundError) Error() string {
+	return fmt.Sprintf("No OPRF key for %q.", e.user)
+}
+
+// NewOPRFKeyNotFoundError returns a new error
+func NewOPRFKeyNotFoundError(user []byte) OPRFKeyNotFoundError {
+	return OPRFKeyNotFoundError{user: user}
+}
+
+// OPRFThrottledError is returned when a user made too many OPRF evaluation
+// attempts.
+type OPRFThrottledError struct {
+	user       []byte
+	retryAfter time.Duration
+}
+
+func (e OPRFThrottledError) Error() string {
+	return fmt.Sprintf("Too many OPRF evaluations for %q, retry in %s.", e.user, e.retryAfter)
+}
+
+// RetryAfter returns how long until the next attempt is allowed.
+func (e OPRFThrottledError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// NewOPRFThrottledError returns a new error
+func NewOPRFThrottledError(user []byte, retryAfter time.Duration) OPRFThrottledError {
+	return OPRFThrottledError{user: user, retryAfter: retryAfter}
+}
+
+// KeyStoreAuthenticationError is returned when a key store file does not
+// decrypt, because the passphrase is wrong or the file was tampered with.
+type KeyStoreAuthenticationError struct {
+	name string
+}
+
+func (e KeyStoreAuthenticationError) Error() string {
+	return fmt.Sprintf("Key store file %q does not decrypt: wrong passphrase or tampered file.", e.name)
+}
+
+// NewKeyStoreAuthenticationError returns a new error
+func NewKeyStoreAuthenticationError(name string) KeyStoreAuthenticationError {
+	return KeyStoreAuthenticationError{name: name}
+}
@@ -11,10 +11,6 @@ import (
 	"github.com/cloudflare/bn256"
 )
 
-var (
-	salt = []byte{11, 12, 13, 14} //to be used in HashG1
-)
-
 // PublicKey is the BLS public key, i.e. a point on curve G2
 type PublicKey struct {
 	gx *bn256.G2
@@ -87,20 +83,6 @@ func GenerateRandomInZp() (*big.Int, error) {
 	return r, nil
 }
 
-// ComputeCommitment computes the commitment as hash(pwUStar || R_DU || d)
-func ComputeCommitment(pwUStar *bn256.G1, R_DU, d []byte) []byte {
-	// Serialize pwUStar
-	pwUStarBytes := pwUStar.Marshal()
-
-	// Concatenate pwUStar, R_DU, and d
-	combined := append(pwUStarBytes, R_DU...)
-	combined = append(combined, d...)
-
-	// Compute the hash
-	hash := sha256.Sum256(combined)
-	return hash[:]
-}
-
 // XORBytes computes the XOR of two byte slices
 func XORBytes(a, b []byte) ([]byte, error) {
 	if len(a) != len(b) {
@@ -120,17 +102,26 @@ func hbar(data []byte) []byte {
 	return hash.Sum(nil)
 }
 
-func DeviceKeyGen(ID_DU []byte, pw_U []byte, k_U *big.Int) (*big.Int, *bn256.G2, *bn256.G1, *bn256.G1, error) {
+// DeviceKeyGen derives the user's long-term key pair (s_U, PK_U) and the
+// device key pair (S_DU, Q_DU) with the current key derivation version.
+func DeviceKeyGen(params ProtocolParams, ID_DU []byte, pw_U []byte, k_U *big.Int) (*big.Int, *bn256.G2, *bn256.G1, *bn256.G1, error) {
+	return DeviceKeyGenWithVersion(params, CurrentKeyDerivationVersion, ID_DU, pw_U, k_U)
+}
+
+// DeviceKeyGenWithVersion is DeviceKeyGen with an explicit key derivation
+// version, to recompute keys made with an older version.
+func DeviceKeyGenWithVersion(params ProtocolParams, version KeyDerivationVersion, ID_DU []byte, pw_U []byte, k_U *big.Int) (*big.Int, *bn256.G2, *bn256.G1, *bn256.G1, error) {
+	if err := params.check("DeviceKeyGen"); err != nil {
+		return nil, nil, nil, nil, err
+	}
 	// Generate device-specific public key Q_DU
-	Q_DU := bn256.HashG1(ID_DU, nil)
-	Hpw_U := bn256.HashG1(pw_U, nil)
+	Q_DU := params.HashDeviceID(ID_DU)
+	Hpw_U := params.HashPassword(pw_U)
 	pw_UStar := new(bn256.G1).ScalarMult(Hpw_U, k_U)
-	combined := append(pw_UStar.Marshal(), pw_U...)
-	if len(combined) > 32 {
-		combined = combined[:32]
+	privKey, err := DeriveLongTermKey(params, version, pw_UStar, pw_U)
+	if err != nil {
+		return nil, nil, nil, nil, err
 	}
-	var privKey PrivateKey
-	privKey.FromBytes(combined)
 
 	//Compute device-specific private key S_DU
 	s_U := privKey.x
@@ -26,25 +26,12 @@ func TestRegKeyGen(t *testing.T) {
 	pw_U := []byte("password123")
 
 	// Compute H(pw_U)
-	H_pw_U := bn256.HashG1(pw_U, salt)
+	H_pw_U := testParams.HashPassword(pw_U)
 	// Compute k_U * H(pw_U)
 	result := new(bn256.G1).ScalarMult(H_pw_U, k_U)
 
-	// Convert the result of k_U * H(pw_U) to a byte array
-	resultBytes := result.Marshal()
-
-	// concatenated byte array
-	combined := append(resultBytes, pw_U...)
-	// Print the concatenated byte array
-	//fmt.Println("Combined []byte:", hex.EncodeToString(combined))
-
-	if len(combined) > 32 {
-		combined = combined[:32]
-	}
-
-	// Creates and generates a private key from a concatenated byte array
-	var privKey PrivateKey
-	err = privKey.FromBytes(combined)
+	// Derive the private key from k_U * H(pw_U) and pw_U
+	privKey, err := DeriveLongTermKey(testParams, CurrentKeyDerivationVersion, result, pw_U)
 	if err != nil {
 		fmt.Println("Error generating private key:", err)
 		return
@@ -65,7 +52,7 @@ func TestDeviceKeyGen(t *testing.T) {
 	ID_DU := []byte("Device123")
 
 	// Generate the device-specific public key Q_DU
-	Q_DU := bn256.HashG1(ID_DU, salt)
+	Q_DU := testParams.HashDeviceID(ID_DU)
 
 	// Serialize the generated public key into a byte array
 	Q_DU_Bytes := Q_DU.Marshal()
@@ -93,7 +80,7 @@ func TestDeviceKeyGen(t *testing.T) {
 	pw_U := []byte("password123")
 
 	// Generate H(pw_U) as a point on G1
-	Hpw_U := bn256.HashG1(pw_U, salt)
+	Hpw_U := testParams.HashPassword(pw_U)
 
 	// Randomly select r in Z*_p
 	r, err := GenerateRandomInZp()
@@ -106,7 +93,7 @@ func TestDeviceKeyGen(t *testing.T) {
 	pw_UStar := new(bn256.G1).ScalarMult(Hpw_U, r)
 
 	// Compute commitment Com = h(pw_U* || R_DU || d)
-	commitment := ComputeCommitment(pw_UStar, R_DU, d)
+	commitment := testParams.ComputeCommitment(pw_UStar, R_DU, d)
 
 	// Output results
 	fmt.Println("pw_UStar:", hex.EncodeToString(pw_UStar.Marshal()))
@@ -135,7 +122,7 @@ func TestDeviceKeyGen(t *testing.T) {
 	fmt.Println("Checksum verification succeeded.")
 
 	// Validate commitment
-	if !bytes.Equal(commitment, ComputeCommitment(pw_UStar, R_DU, d)) {
+	if !bytes.Equal(commitment, testParams.ComputeCommitment(pw_UStar, R_DU, d)) {
 		fmt.Println("Commitment verification failed.")
 		return
 	}
@@ -159,18 +146,8 @@ func TestDeviceKeyGen(t *testing.T) {
 	// Compute r^-1 * sigma in G1
 	rInvSigma := new(bn256.G1).ScalarMult(sigma, rInv)
 
-	// Splice rInvSigma and pw_U together
-	combined := append(rInvSigma.Marshal(), pw_U...)
-
-	// Print the concatenated byte array
-	//fmt.Println("Combined []byte:", hex.EncodeToString(combined))
-
-	if len(combined) > 32 {
-		combined = combined[:32]
-	}
-	// Generates the private key from the concatenated byte array
-	var privKey PrivateKey
-	err = privKey.FromBytes(combined)
+	// Derive the private key from rInvSigma and pw_U
+	privKey, err := DeriveLongTermKey(testParams, CurrentKeyDerivationVersion, rInvSigma, pw_U)
 	if err != nil {
 		fmt.Println("Error generating private key:", err)
 		return
@@ -194,7 +171,7 @@ func TestSesKeyGen(t *testing.T) {
 	pw_U1 := []byte("password1")
 	k_U1 := new(big.Int).SetInt64(12345)
 
-	_, PkU1, SDu1, QDu1, err := DeviceKeyGen(ID_DU1, pw_U1, k_U1)
+	_, PkU1, SDu1, QDu1, err := DeviceKeyGen(testParams, ID_DU1, pw_U1, k_U1)
 	if err != nil {
 		fmt.Println("Error in first call:", err)
 	} else {
@@ -210,7 +187,7 @@ func TestSesKeyGen(t *testing.T) {
 	pw_U2 := []byte("password2")
 	k_U2 := new(big.Int).SetInt64(67890)
 
-	_, PkU2, SDu2, QDu2, err2 := DeviceKeyGen(ID_DU2, pw_U2, k_U2)
+	_, PkU2, SDu2, QDu2, err2 := DeviceKeyGen(testParams, ID_DU2, pw_U2, k_U2)
 	if err2 != nil {
 		fmt.Println("Error in second call:", err)
 	} else {
@@ -311,22 +288,12 @@ func TestKeyUpdate(t *testing.T) {
 	pw_U := []byte("passwordnew")
 
 	// Compute H(pw_U)
-	H_pw_U := bn256.HashG1(pw_U, salt)
+	H_pw_U := testParams.HashPassword(pw_U)
 	// Compute k_U * H(pw_U)
 	result := new(bn256.G1).ScalarMult(H_pw_U, k_U)
 
-	// Concatenate k_U * H(pw_U) and pw_U together
-	resultBytes := result.Marshal()
-	combined := append(resultBytes, pw_U...)
-	// Print the concatenated byte array
-	//fmt.Println("Combined []byte:", hex.EncodeToString(combined))
-	if len(combined) > 32 {
-		combined = combined[:32]
-	}
-
-	// Generate the new private key from the concatenated byte array
-	var privKey PrivateKey
-	err = privKey.FromBytes(combined)
+	// Derive the new private key from k_U * H(pw_U) and pw_U
+	privKey, err := DeriveLongTermKey(testParams, CurrentKeyDerivationVersion, result, pw_U)
 	if err != nil {
 		fmt.Println("Error generating private key:", err)
 		return
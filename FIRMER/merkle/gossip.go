@@ -0,0 +1,187 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"FIRMER/logger"
+)
+
+// Each client only sees the digests the server sends it, so a malicious server
+// could show different histories to different clients (a split view). Clients
+// detect this by gossiping the signed tree heads they received: since the
+// server signs exactly one digest per Seqno, two validly signed heads with the
+// same Seqno and different digests are a compact proof of misbehavior which
+// anyone holding the server public key can check.
+
+// TreeHeadSource is the view of the directory a client is served. *Tree
+// implements it.
+type TreeHeadSource interface {
+	SignedTreeHead(ctx logger.ContextInterface, tr Transaction, s Seqno) (SignedTreeHead, error)
+	GetExtensionProof(ctx logger.ContextInterface, tr Transaction, fromSeqno, toSeqno Seqno) (MerkleExtensionProof, error)
+}
+
+// EquivocationProof is two tree heads signed by the server for the same Seqno
+// but with different digests, which cannot both be part of one history tree.
+type EquivocationProof struct {
+	_struct struct{}       `codec:",toarray"` //nolint
+	A       SignedTreeHead `codec:"a"`
+	B       SignedTreeHead `codec:"b"`
+}
+
+// Verify checks that the proof really shows the server with public key pk
+// equivocating.
+func (e EquivocationProof) Verify(params ProtocolParams, pk *PublicKey) error {
+	if err := e.A.Verify(params, pk); err != nil {
+		return err
+	}
+	if err := e.B.Verify(params, pk); err != nil {
+		return err
+	}
+	if e.A.Seqno != e.B.Seqno {
+		return fmt.Errorf("tree heads have different seqnos %d and %d", e.A.Seqno, e.B.Seqno)
+	}
+	if bytes.Equal(e.A.Digest, e.B.Digest) {
+		return fmt.Errorf("tree heads have the same digest")
+	}
+	return nil
+}
+
+// GossipPeer is a client taking part in gossip. It follows its own view of
+// the directory through source, and checks the tree heads other clients send
+// it against that view.
+type GossipPeer struct {
+	sync.Mutex
+
+	params   ProtocolParams
+	serverPK *PublicKey
+	source   TreeHeadSource
+	verifier MerkleProofVerifier
+
+	latest    SignedTreeHead
+	hasLatest bool
+}
+
+// NewGossipPeer returns a peer verifying tree heads with serverPK and the
+// protocol parameters of cfg, and reading its own view of the directory from
+// source.
+func NewGossipPeer(cfg Config, serverPK *PublicKey, source TreeHeadSource) *GossipPeer {
+	return &GossipPeer{params: cfg.Params, serverPK: serverPK, source: source, verifier: NewMerkleProofVerifier(cfg)}
+}
+
+// Latest returns the latest tree head the peer observed in its own view.
+func (p *GossipPeer) Latest() (SignedTreeHead, bool) {
+	p.Lock()
+	defer p.Unlock()
+	return p.latest, p.hasLatest
+}
+
+// Observe records a tree head the peer received from the server. It must
+// verify and extend the previously observed one. If it has the Seqno of the
+// previously observed head but a different digest, the server equivocated to
+// the peer itself: Observe returns the EquivocationProof and keeps the
+// previous head.
+func (p *GossipPeer) Observe(ctx logger.ContextInterface, sth SignedTreeHead) (*EquivocationProof, error) {
+	if err := sth.Verify(p.params, p.serverPK); err != nil {
+		return nil, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.hasLatest {
+		if sth.Seqno < p.latest.Seqno {
+			return nil, NewInconsistentTreeHeadsError(p.latest.Seqno, sth.Seqno, fmt.Errorf("tree head is older than the latest observed one"))
+		}
+		if sth.Seqno == p.latest.Seqno {
+			if !bytes.Equal(sth.Digest, p.latest.Digest) {
+				return &EquivocationProof{A: p.latest, B: sth}, nil
+			}
+			return nil, nil
+		}
+		if err := p.checkExtension(ctx, p.latest, sth); err != nil {
+			return nil, err
+		}
+	}
+	p.latest = sth
+	p.hasLatest = true
+	return nil, nil
+}
+
+func (p *GossipPeer) checkExtension(ctx logger.ContextInterface, from, to SignedTreeHead) error {
+	proof, err := p.source.GetExtensionProof(ctx, nil, from.Seqno, to.Seqno)
+	if err != nil {
+		return NewInconsistentTreeHeadsError(from.Seqno, to.Seqno, err)
+	}
+	if err := p.verifier.VerifyExtensionProof(ctx, &proof, from.Seqno, from.Digest, to.Seqno, to.Digest); err != nil {
+		return NewInconsistentTreeHeadsError(from.Seqno, to.Seqno, err)
+	}
+	return nil
+}
+
+// Receive checks a tree head gossiped by another client against the peer's
+// own view. It returns nil, nil if they are consistent, and an
+// EquivocationProof if the server signed a different digest for the same
+// Seqno in the peer's view. Other inconsistencies, which cannot be proven to
+// third parties, are returned as an InconsistentTreeHeadsError.
+func (p *GossipPeer) Receive(ctx logger.ContextInterface, sth SignedTreeHead) (*EquivocationProof, error) {
+	if err := sth.Verify(p.params, p.serverPK); err != nil {
+		return nil, err
+	}
+	p.Lock()
+	latest, hasLatest := p.latest, p.hasLatest
+	p.Unlock()
+	if !hasLatest {
+		return nil, nil
+	}
+
+	var own SignedTreeHead
+	if sth.Seqno == latest.Seqno {
+		own = latest
+	} else {
+		var err error
+		own, err = p.source.SignedTreeHead(ctx, nil, sth.Seqno)
+		if err != nil {
+			if sth.Seqno < latest.Seqno {
+				return nil, NewInconsistentTreeHeadsError(latest.Seqno, sth.Seqno, err)
+			}
+			// The peer's view has not reached sth yet: it must extend it.
+			return nil, p.checkExtension(ctx, latest, sth)
+		}
+		if err := own.Verify(p.params, p.serverPK); err != nil {
+			return nil, err
+		}
+		if own.Seqno != sth.Seqno {
+			return nil, NewInconsistentTreeHeadsError(latest.Seqno, sth.Seqno, fmt.Errorf("source returned the tree head of seqno %d", own.Seqno))
+		}
+		// own comes from the source, which could answer from another
+		// history: it must be on the one the peer observed.
+		if own.Seqno < latest.Seqno {
+			err = p.checkExtension(ctx, own, latest)
+		} else {
+			err = p.checkExtension(ctx, latest, own)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(own.Digest, sth.Digest) {
+		return &EquivocationProof{A: own, B: sth}, nil
+	}
+	return nil, nil
+}
+
+// Gossip exchanges the latest tree heads of a and b, in both directions. It
+// returns the first equivocation proof or error found.
+func Gossip(ctx logger.ContextInterface, a, b *GossipPeer) (*EquivocationProof, error) {
+	for _, pair := range [][2]*GossipPeer{{a, b}, {b, a}} {
+		sth, ok := pair[0].Latest()
+		if !ok {
+			continue
+		}
+		proof, err := pair[1].Receive(ctx, sth)
+		if proof != nil || err != nil {
+			return proof, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,199 @@
+package merkle
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"FIRMER/logger"
+	"FIRMER/msgpack"
+	"github.com/stretchr/testify/require"
+)
+
+func newSignedTestTree(t *testing.T, sk *PrivateKey) (Config, *Tree) {
+	pp := GenPP()
+	st := Init(pp)
+	require.NotNil(t, st)
+	require.NoError(t, st.SetSigningKey(NewLoggerContextTodoForTesting(t), sk))
+	return pp, st
+}
+
+func TestGossipConsistent(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pp, st := newSignedTestTree(t, sk)
+
+	alice := NewGossipPeer(pp, sk.GetPublicKey(), st)
+	bob := NewGossipPeer(pp, sk.GetPublicKey(), st)
+
+	proof, err := Gossip(ctx, alice, bob)
+	require.NoError(t, err)
+	require.Nil(t, proof)
+
+	sth1, _, _ := SignedUpdate(st, GenerateInitS(1, 10), ctx)
+	_, err = alice.Observe(ctx, sth1)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		SignedUpdate(st, GenerateInitS(100+10*i, 102+10*i), ctx)
+	}
+	sth4, err := st.LatestSignedTreeHead(ctx, nil)
+	require.NoError(t, err)
+	_, err = bob.Observe(ctx, sth1)
+	require.NoError(t, err)
+	_, err = bob.Observe(ctx, sth4)
+	require.NoError(t, err)
+
+	// Alice lags behind Bob, in both directions the views are consistent.
+	proof, err = Gossip(ctx, alice, bob)
+	require.NoError(t, err)
+	require.Nil(t, proof)
+	proof, err = Gossip(ctx, bob, alice)
+	require.NoError(t, err)
+	require.Nil(t, proof)
+
+	// Observing an older head is refused.
+	_, err = bob.Observe(ctx, sth1)
+	require.IsType(t, InconsistentTreeHeadsError{}, err)
+
+	// Observing the latest head again is fine.
+	proof, err = bob.Observe(ctx, sth4)
+	require.NoError(t, err)
+	require.Nil(t, proof)
+}
+
+func TestGossipSplitView(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pk := sk.GetPublicKey()
+
+	// A malicious server keeps two trees and signs both with its key.
+	pp, st1 := newSignedTestTree(t, sk)
+	_, st2 := newSignedTestTree(t, sk)
+	S := GenerateInitS(1, 10)
+	SignedUpdate(st1, S, ctx)
+	SignedUpdate(st2, S, ctx)
+	a2, _, _ := SignedUpdate(st1, GenerateAddS(2), ctx)
+	b2, _, _ := SignedUpdate(st2, GenerateAddS2(2), ctx)
+	b3, _, _ := SignedUpdate(st2, GenerateInitS(100, 102), ctx)
+	require.Equal(t, a2.Seqno, b2.Seqno)
+
+	alice := NewGossipPeer(pp, pk, st1)
+	bob := NewGossipPeer(pp, pk, st2)
+	_, err = alice.Observe(ctx, a2)
+	require.NoError(t, err)
+	_, err = bob.Observe(ctx, b3)
+	require.NoError(t, err)
+
+	// Bob is ahead of Alice, but his view has a different head at her Seqno.
+	proof, err := Gossip(ctx, alice, bob)
+	require.NoError(t, err)
+	require.NotNil(t, proof)
+	require.NoError(t, proof.Verify(pp.Params, pk))
+
+	// The proof can be sent to and checked by anyone.
+	enc, err := msgpack.EncodeCanonical(proof)
+	require.NoError(t, err)
+	var dec EquivocationProof
+	require.NoError(t, msgpack.Decode(&dec, enc))
+	require.NoError(t, dec.Verify(pp.Params, pk))
+
+	other, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.Error(t, dec.Verify(pp.Params, other.GetPublicKey()))
+
+	// Alice can't check Bob's newer head against her view.
+	_, err = alice.Receive(ctx, b3)
+	require.IsType(t, InconsistentTreeHeadsError{}, err)
+
+	// Not proofs of equivocation.
+	require.Error(t, EquivocationProof{A: a2, B: a2}.Verify(pp.Params, pk))
+	require.Error(t, EquivocationProof{A: a2, B: b3}.Verify(pp.Params, pk))
+	forged := b2
+	forged.Digest = TransparencyDigest{1}
+	require.Error(t, EquivocationProof{A: a2, B: forged}.Verify(pp.Params, pk))
+	_, err = alice.Receive(ctx, forged)
+	require.IsType(t, InvalidSignedTreeHeadError{}, err)
+}
+
+func TestGossipObserveEquivocation(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pk := sk.GetPublicKey()
+
+	// The server shows Alice two different heads for the same Seqno.
+	pp, st1 := newSignedTestTree(t, sk)
+	_, st2 := newSignedTestTree(t, sk)
+	S := GenerateInitS(1, 10)
+	SignedUpdate(st1, S, ctx)
+	SignedUpdate(st2, S, ctx)
+	a2, _, _ := SignedUpdate(st1, GenerateAddS(2), ctx)
+	b2, _, _ := SignedUpdate(st2, GenerateAddS2(2), ctx)
+	require.Equal(t, a2.Seqno, b2.Seqno)
+
+	alice := NewGossipPeer(pp, pk, st1)
+	_, err = alice.Observe(ctx, a2)
+	require.NoError(t, err)
+	proof, err := alice.Observe(ctx, b2)
+	require.NoError(t, err)
+	require.NotNil(t, proof)
+	require.NoError(t, proof.Verify(pp.Params, pk))
+	require.Equal(t, a2, proof.A)
+	require.Equal(t, b2, proof.B)
+
+	// Alice keeps the head she observed first.
+	latest, ok := alice.Latest()
+	require.True(t, ok)
+	require.Equal(t, a2, latest)
+}
+
+// forkedSource serves the view of a tree, except for the signed tree heads
+// of the Seqnos in heads.
+type forkedSource struct {
+	*Tree
+	heads map[Seqno]SignedTreeHead
+}
+
+func (f forkedSource) SignedTreeHead(ctx logger.ContextInterface, tr Transaction, s Seqno) (SignedTreeHead, error) {
+	if sth, ok := f.heads[s]; ok {
+		return sth, nil
+	}
+	return f.Tree.SignedTreeHead(ctx, tr, s)
+}
+
+func TestGossipReceiveChecksSourceHistory(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pk := sk.GetPublicKey()
+
+	pp, st1 := newSignedTestTree(t, sk)
+	_, st2 := newSignedTestTree(t, sk)
+	S := GenerateInitS(1, 10)
+	SignedUpdate(st1, S, ctx)
+	SignedUpdate(st2, S, ctx)
+	a2, _, _ := SignedUpdate(st1, GenerateAddS(2), ctx)
+	SignedUpdate(st2, GenerateAddS2(2), ctx)
+	b3, _, _ := SignedUpdate(st2, GenerateInitS(100, 102), ctx)
+	a3, _, _ := SignedUpdate(st1, GenerateInitS(200, 202), ctx)
+
+	// Bob follows st2, but the source answers with Alice's fork for the
+	// Seqno of the head she gossips.
+	bob := NewGossipPeer(pp, pk, forkedSource{st2, map[Seqno]SignedTreeHead{a2.Seqno: a2}})
+	_, err = bob.Observe(ctx, b3)
+	require.NoError(t, err)
+	proof, err := bob.Receive(ctx, a2)
+	require.Nil(t, proof)
+	require.IsType(t, InconsistentTreeHeadsError{}, err)
+
+	// Likewise for a Seqno newer than Bob's latest head.
+	b2, err := st2.SignedTreeHead(ctx, nil, a2.Seqno)
+	require.NoError(t, err)
+	carol := NewGossipPeer(pp, pk, forkedSource{st2, map[Seqno]SignedTreeHead{a3.Seqno: a3}})
+	_, err = carol.Observe(ctx, b2)
+	require.NoError(t, err)
+	proof, err = carol.Receive(ctx, a3)
+	require.Nil(t, proof)
+	require.IsType(t, InconsistentTreeHeadsError{}, err)
+}
@@ -28,7 +28,8 @@ type VRFEntry struct {
 // In memory StorageEngine implementation, used for tests. It ignores
 // Transaction arguments, so it can't be used for concurrency tests.
 type InMemoryStorageEngine struct {
-	Roots map[Seqno]RootMetadata
+	Roots           map[Seqno]RootMetadata
+	SignedTreeHeads map[Seqno]SignedTreeHead
 
 	KeyMap   map[Period]map[string]HiddenKey
 	VRFCache *sync.Map
@@ -36,14 +37,18 @@ type InMemoryStorageEngine struct {
 	SortedKVPRs map[Period]*bst.Tree
 	Nodes       map[Period]map[string]*NodeRecord
 
-	VRFPrivateKeys map[Period]*vrf.PrivateKey
-
 	VRFRotationProofs map[Period]vrf.RotationProof
 	ArrayDat          map[int][]byte
 	Lc                int
 
 	phBuf []PositionHashPair
 
+	// undo holds the functions undoing the writes since the innermost
+	// savepoint, in the order of the writes. It is nil if there is no
+	// savepoint. outerUndo holds those of the enclosing savepoints.
+	undo      []func()
+	outerUndo [][]func()
+
 	// used to make prefix queries efficient. Not otherwise necessary
 	//PositionToKeys map[string](map[string]bool)
 	cfg Config
@@ -51,6 +56,74 @@ type InMemoryStorageEngine struct {
 
 // var _ StorageEngine = &InMemoryStorageEngine{}
 
+var _ RollbackEngine = &InMemoryStorageEngine{}
+
+func (i *InMemoryStorageEngine) Savepoint(ctx logger.ContextInterface, t Transaction) error {
+	i.outerUndo = append(i.outerUndo, i.undo)
+	i.undo = []func(){}
+	return nil
+}
+
+// popSavepoint makes the enclosing savepoint the innermost one.
+func (i *InMemoryStorageEngine) popSavepoint() []func() {
+	outer := i.outerUndo[len(i.outerUndo)-1]
+	i.outerUndo = i.outerUndo[:len(i.outerUndo)-1]
+	return outer
+}
+
+func (i *InMemoryStorageEngine) RollbackToSavepoint(ctx logger.ContextInterface, t Transaction) error {
+	if i.undo == nil {
+		return errors.New("no savepoint")
+	}
+	for j := len(i.undo) - 1; j >= 0; j-- {
+		i.undo[j]()
+	}
+	i.undo = i.popSavepoint()
+	return nil
+}
+
+func (i *InMemoryStorageEngine) ReleaseSavepoint(ctx logger.ContextInterface, t Transaction) error {
+	if i.undo == nil {
+		return errors.New("no savepoint")
+	}
+	undo := i.undo
+	if i.undo = i.popSavepoint(); i.undo != nil {
+		i.undo = append(i.undo, undo...)
+	}
+	return nil
+}
+
+// onRollback records f to undo a write, if there is a savepoint.
+func (i *InMemoryStorageEngine) onRollback(f func()) {
+	if i.undo != nil {
+		i.undo = append(i.undo, f)
+	}
+}
+
+// removeLeaf removes nd from tr. Since Insert only adds leaves, nd is still a
+// leaf when the insertions after it have been undone.
+func removeLeaf(tr *bst.Tree, nd *bst.Node) {
+	if tr.Root == nd {
+		tr.Root = nil
+		return
+	}
+	for p := tr.Root; p != nil; {
+		if p.Key.Less(nd.Key) {
+			if p.Right == nd {
+				p.Right = nil
+				return
+			}
+			p = p.Right
+		} else {
+			if p.Left == nd {
+				p.Left = nil
+				return
+			}
+			p = p.Left
+		}
+	}
+}
+
 type SortedKVPR []*KVPRecord
 
 func (s SortedKVPR) Len() int {
@@ -83,13 +156,13 @@ type KVPRecord struct {
 func NewInMemoryStorageEngine(cfg Config) *InMemoryStorageEngine {
 	i := InMemoryStorageEngine{}
 	i.Roots = make(map[Seqno]RootMetadata)
+	i.SignedTreeHeads = make(map[Seqno]SignedTreeHead)
 	i.KeyMap = make(map[Period]map[string]HiddenKey)
 	i.VRFCache = new(sync.Map)
 	i.SortedKVPRs = make(map[Period]*bst.Tree)
 	i.Nodes = make(map[Period]map[string]*NodeRecord)
 	i.cfg = cfg
 	i.VRFRotationProofs = make(map[Period]vrf.RotationProof)
-	i.VRFPrivateKeys = make(map[Period]*vrf.PrivateKey)
 	i.ArrayDat = make(map[int][]byte)
 	return &i
 }
@@ -116,7 +189,16 @@ func (i *InMemoryStorageEngine) StoreVRFCache(c logger.ContextInterface, t Trans
 	}
 	mmap := m.(*sync.Map)
 	for j, k := range key {
-		mmap.Store(k.String(), VRFEntry{hk[j], proof[j]})
+		k := k.String()
+		old, found := mmap.Load(k)
+		i.onRollback(func() {
+			if found {
+				mmap.Store(k, old)
+			} else {
+				mmap.Delete(k)
+			}
+		})
+		mmap.Store(k, VRFEntry{hk[j], proof[j]})
 	}
 	return nil
 }
@@ -139,6 +221,20 @@ func (i *InMemoryStorageEngine) LookupVRFCache(c logger.ContextInterface, t Tran
 	return vrfentry.value, vrfentry.proof, nil
 }
 
+func (i *InMemoryStorageEngine) LookupVRFCacheBatch(c logger.ContextInterface, t Transaction,
+	per Period, keys []Key) ([]HiddenKey, [][]byte, error) {
+	hks := make([]HiddenKey, len(keys))
+	proofs := make([][]byte, len(keys))
+	for j, key := range keys {
+		hk, proof, err := i.LookupVRFCache(c, t, per, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		hks[j], proofs[j] = hk, proof
+	}
+	return hks, proofs, nil
+}
+
 func (i *InMemoryStorageEngine) StorePairs(c logger.ContextInterface, t Transaction,
 	s Seqno, p Period, kevps []HiddenKeyValuePair) error {
 
@@ -146,7 +242,9 @@ func (i *InMemoryStorageEngine) StorePairs(c logger.ContextInterface, t Transact
 		if i.KeyMap[p] == nil {
 			i.KeyMap[p] = make(map[string]HiddenKey)
 		}
-		i.KeyMap[p][string(kevp.Key)] = kevp.HiddenKey
+		keyMap, k := i.KeyMap[p], string(kevp.Key)
+		old, found := keyMap[k]
+		keyMap[k] = kevp.HiddenKey
 
 		nd := bst.NewNode(&KVPRecord{kevp: kevp, s: s, next: nil})
 		if i.SortedKVPRs[p] == nil {
@@ -154,6 +252,15 @@ func (i *InMemoryStorageEngine) StorePairs(c logger.ContextInterface, t Transact
 		} else {
 			i.SortedKVPRs[p].Insert(nd)
 		}
+		bstree := i.SortedKVPRs[p]
+		i.onRollback(func() {
+			if found {
+				keyMap[k] = old
+			} else {
+				delete(keyMap, k)
+			}
+			removeLeaf(bstree, nd)
+		})
 	}
 	return nil
 }
@@ -175,9 +282,17 @@ func (i *InMemoryStorageEngine) storeNode(c logger.ContextInterface, t Transacti
 		i.Nodes[per] = make(map[string]*NodeRecord)
 	}
 
-	oldNodeRec := i.Nodes[per][strKey]
+	nodes := i.Nodes[per]
+	oldNodeRec := nodes[strKey]
 	newp := p.Clone()
-	i.Nodes[per][strKey] = &NodeRecord{s: s, p: *newp, h: h, next: oldNodeRec}
+	nodes[strKey] = &NodeRecord{s: s, p: *newp, h: h, next: oldNodeRec}
+	i.onRollback(func() {
+		if oldNodeRec != nil {
+			nodes[strKey] = oldNodeRec
+		} else {
+			delete(nodes, strKey)
+		}
+	})
 	if oldNodeRec != nil && oldNodeRec.s > s { // > instead of >= to allow updating same node multiple times in a build
 		return errors.New("engine does not support out of order insertions")
 	}
@@ -185,6 +300,14 @@ func (i *InMemoryStorageEngine) storeNode(c logger.ContextInterface, t Transacti
 }
 
 func (i *InMemoryStorageEngine) StoreRoot(c logger.ContextInterface, t Transaction, r RootMetadata) error {
+	old, found := i.Roots[r.Seqno]
+	i.onRollback(func() {
+		if found {
+			i.Roots[r.Seqno] = old
+		} else {
+			delete(i.Roots, r.Seqno)
+		}
+	})
 	i.Roots[r.Seqno] = r
 	return nil
 }
@@ -210,6 +333,40 @@ func (i *InMemoryStorageEngine) LookupRoot(c logger.ContextInterface, t Transact
 	return RootMetadata{}, NewInvalidSeqnoError(s, fmt.Errorf("No root at seqno %v", s))
 }
 
+func (i *InMemoryStorageEngine) StoreSignedTreeHead(c logger.ContextInterface, t Transaction, sth SignedTreeHead) error {
+	old, found := i.SignedTreeHeads[sth.Seqno]
+	i.onRollback(func() {
+		if found {
+			i.SignedTreeHeads[sth.Seqno] = old
+		} else {
+			delete(i.SignedTreeHeads, sth.Seqno)
+		}
+	})
+	i.SignedTreeHeads[sth.Seqno] = sth
+	return nil
+}
+
+func (i *InMemoryStorageEngine) LookupSignedTreeHead(c logger.ContextInterface, t Transaction, s Seqno) (SignedTreeHead, error) {
+	sth, found := i.SignedTreeHeads[s]
+	if found {
+		return sth, nil
+	}
+	return SignedTreeHead{}, NewInvalidSeqnoError(s, fmt.Errorf("No signed tree head at seqno %v", s))
+}
+
+func (i *InMemoryStorageEngine) LookupLatestSignedTreeHead(c logger.ContextInterface, t Transaction) (SignedTreeHead, error) {
+	if len(i.SignedTreeHeads) == 0 {
+		return SignedTreeHead{}, NewNoLatestRootFoundError()
+	}
+	max := Seqno(0)
+	for k := range i.SignedTreeHeads {
+		if k > max {
+			max = k
+		}
+	}
+	return i.SignedTreeHeads[max], nil
+}
+
 func (i *InMemoryStorageEngine) LookupNode(c logger.ContextInterface, t Transaction, s Seqno, per Period, p *Position) ([]byte, error) {
 	node, found := i.Nodes[per][string(p.GetBytes())]
 	if !found {
@@ -310,12 +467,43 @@ func (i *InMemoryStorageEngine) LookupAllPairs(ctx logger.ContextInterface, t Tr
 	return kevps, nil
 }
 
-func (i *InMemoryStorageEngine) StoreVRFPrivateKey(ctx logger.ContextInterface, t Transaction, p Period, sk *vrf.PrivateKey) (err error) {
-	i.VRFPrivateKeys[p] = sk
-	return nil
+// LookupPairsAfter returns, ordered by HiddenKey, at most limit of the pairs
+// at the specified Seqno whose HiddenKey is greater than after.
+func (i *InMemoryStorageEngine) LookupPairsAfter(ctx logger.ContextInterface, t Transaction,
+	s Seqno, per Period, after HiddenKey, limit int) (kevps []HiddenKeyValuePair, err error) {
+	var traverse func(nd *bst.Node)
+	traverse = func(nd *bst.Node) {
+		if nd == nil || len(kevps) >= limit {
+			return
+		}
+		kvpr := nd.Key.(*KVPRecord)
+		greater := after == nil || kvpr.kevp.HiddenKey.Cmp(after) > 0
+		if greater {
+			traverse(nd.Left)
+			for ; kvpr != nil && len(kevps) < limit; kvpr = kvpr.next {
+				if kvpr.s <= s {
+					kevps = append(kevps, kvpr.kevp)
+					break
+				}
+			}
+		}
+		traverse(nd.Right)
+	}
+	if bstree := i.SortedKVPRs[per]; bstree != nil {
+		traverse(bstree.Root)
+	}
+	return kevps, nil
 }
 
 func (i *InMemoryStorageEngine) StoreVRFRotationProof(ctx logger.ContextInterface, t Transaction, p Period, pi vrf.RotationProof) error {
+	old, found := i.VRFRotationProofs[p]
+	i.onRollback(func() {
+		if found {
+			i.VRFRotationProofs[p] = old
+		} else {
+			delete(i.VRFRotationProofs, p)
+		}
+	})
 	i.VRFRotationProofs[p] = pi
 	return nil
 }
@@ -324,15 +512,15 @@ func (i *InMemoryStorageEngine) LookupVRFRotationProof(ctx logger.ContextInterfa
 	return i.VRFRotationProofs[p], nil
 }
 
-func (i *InMemoryStorageEngine) LookupVRFPrivateKey(ctx logger.ContextInterface, t Transaction, p Period) (*vrf.PrivateKey, error) {
-	sk, ok := i.VRFPrivateKeys[p]
-	if !ok {
-		return nil, fmt.Errorf("no private key for period %d", p)
-	}
-	return sk, nil
-}
-
 func (s *InMemoryStorageEngine) ArraySet(ctx logger.ContextInterface, t Transaction, i int, x []byte) error {
+	old, found := s.ArrayDat[i]
+	s.onRollback(func() {
+		if found {
+			s.ArrayDat[i] = old
+		} else {
+			delete(s.ArrayDat, i)
+		}
+	})
 	s.ArrayDat[i] = x
 	return nil
 }
@@ -29,6 +29,17 @@ type StorageEngine interface {
 	// If there is no root for the specified Seqno, an InvalidSeqnoError is returned.
 	LookupRoot(logger.ContextInterface, Transaction, Seqno) (RootMetadata, error)
 
+	// StoreSignedTreeHead stores the tree head signed for sth.Seqno.
+	StoreSignedTreeHead(ctx logger.ContextInterface, tr Transaction, sth SignedTreeHead) error
+
+	// LookupSignedTreeHead returns the tree head signed for Seqno s. If there
+	// is none, an InvalidSeqnoError is returned.
+	LookupSignedTreeHead(ctx logger.ContextInterface, tr Transaction, s Seqno) (SignedTreeHead, error)
+
+	// LookupLatestSignedTreeHead returns the signed tree head with the highest
+	// Seqno. If there is none, a NoLatestRootFound error is returned.
+	LookupLatestSignedTreeHead(ctx logger.ContextInterface, tr Transaction) (SignedTreeHead, error)
+
 	// LookupNode returns, for any position, the hash of the node with the
 	// highest Seqno s' <= s which was stored at position p. For example, if
 	// StoreNode(ctx, t, 5, p, hash5) and StoreNode(ctx, 6, p, hash6) and
@@ -48,6 +59,10 @@ type StorageEngine interface {
 	StoreVRFCache(c logger.ContextInterface, t Transaction, per Period, k []Key, hk []HiddenKey, vrf_proof [][]byte) error
 	LookupVRFCache(c logger.ContextInterface, t Transaction, per Period, k Key) (HiddenKey, []byte, error)
 
+	// LookupVRFCacheBatch is analogous to LookupVRFCache, for several keys.
+	// The hidden key and proof of a key not in the cache are nil.
+	LookupVRFCacheBatch(c logger.ContextInterface, t Transaction, per Period, ks []Key) ([]HiddenKey, [][]byte, error)
+
 	LookupPair(c logger.ContextInterface, t Transaction, per Period, s Seqno, k HiddenKey) (HiddenKeyValuePair, error)
 
 	// LookupPairsUnderPosition returns all HiddenKeyValuePairs (ordered by
@@ -59,20 +74,58 @@ type StorageEngine interface {
 	// LookupAllPairs returns all the keys and encoded values at the specified Seqno.
 	LookupAllPairs(ctx logger.ContextInterface, t Transaction, s Seqno, per Period) ([]HiddenKeyValuePair, error)
 
+	// LookupPairsAfter returns, ordered by HiddenKey, at most limit of the
+	// pairs at the specified Seqno whose HiddenKey is greater than after, or
+	// the first ones if after is nil. It allows going over all the pairs in
+	// batches.
+	LookupPairsAfter(ctx logger.ContextInterface, t Transaction, s Seqno, per Period, after HiddenKey, limit int) ([]HiddenKeyValuePair, error)
+
 	// May be too large to fit in standard database column
 	StoreVRFRotationProof(ctx logger.ContextInterface, t Transaction, p Period, pi vrf.RotationProof) error
 
 	// nil for p=1
 	LookupVRFRotationProof(ctx logger.ContextInterface, t Transaction, p Period) (vrf.RotationProof, error)
 
-	// Outsourced to secure storage in practice
+	LookupPlayers(ctx logger.ContextInterface, t Transaction, id [][]byte) ([][]byte, error)
+	StorePlayers(ctx logger.ContextInterface, t Transaction, id [][]byte, player [][]byte) error
+}
+
+// RollbackEngine is a StorageEngine which can undo its writes in a
+// transaction since a savepoint. A Tree whose engine is a RollbackEngine undoes
+// the writes of a failed or cancelled Build or Rotate, so that it leaves no
+// partial epoch. With other engines, the caller must abort the transaction.
+type RollbackEngine interface {
+	StorageEngine
+
+	// Savepoint starts recording the writes of t. Savepoints nest: a
+	// savepoint taken while another one is open belongs to it.
+	Savepoint(ctx logger.ContextInterface, t Transaction) error
+
+	// RollbackToSavepoint undoes the writes of t since the innermost
+	// savepoint, and removes it.
+	RollbackToSavepoint(ctx logger.ContextInterface, t Transaction) error
+
+	// ReleaseSavepoint removes the innermost savepoint, keeping the writes of
+	// t. They are still undone if the enclosing savepoint is rolled back.
+	ReleaseSavepoint(ctx logger.ContextInterface, t Transaction) error
+}
+
+// KeyStore stores the secret keys of a tree: the VRF private key of each
+// period and the BLS key signing its tree heads. It is kept apart from the
+// StorageEngine so that the keys can live in secure storage.
+type KeyStore interface {
 	StoreVRFPrivateKey(ctx logger.ContextInterface, t Transaction, p Period, sk *vrf.PrivateKey) error
 
-	// Outsourced to secure storage in practice
+	// LookupVRFPrivateKey returns the VRF private key of period p. It returns
+	// an error if no key was stored for p.
 	LookupVRFPrivateKey(ctx logger.ContextInterface, t Transaction, p Period) (*vrf.PrivateKey, error)
 
-	LookupPlayers(ctx logger.ContextInterface, t Transaction, id [][]byte) ([][]byte, error)
-	StorePlayers(ctx logger.ContextInterface, t Transaction, id [][]byte, player [][]byte) error
+	// StoreSigningKey replaces the tree head signing key. A nil sk removes it.
+	StoreSigningKey(ctx logger.ContextInterface, t Transaction, sk *PrivateKey) error
+
+	// LookupSigningKey returns the tree head signing key, or nil if there is
+	// none.
+	LookupSigningKey(ctx logger.ContextInterface, t Transaction) (*PrivateKey, error)
 }
 
 // Transaction references a DB transaction.
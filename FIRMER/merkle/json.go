@@ -0,0 +1,249 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The JSON encoding of proofs is meant for clients which cannot use msgpack
+// (browsers, mobile apps). Byte fields are encoded as lowercase hex strings,
+// nil byte slices as null, and every field of an object is required, so that
+// decoding a JSON proof gives back exactly the value the server encoded. The
+// one exception is the opt-in vrf_batch_points, which is left out when empty
+// so that proofs without it keep their encoding.
+
+// hexBytes is a []byte which encodes to JSON as a canonical (lowercase) hex
+// string. A nil slice is encoded as null, so that nil and empty slices survive
+// a round trip.
+type hexBytes []byte
+
+func (h hexBytes) MarshalJSON() ([]byte, error) {
+	if h == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *hexBytes) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*h = nil
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	d, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(d) != s {
+		return fmt.Errorf("hex string %q is not lowercase", s)
+	}
+	*h = d
+	return nil
+}
+
+func toHexBytesSlice(bs [][]byte) []hexBytes {
+	if bs == nil {
+		return nil
+	}
+	ret := make([]hexBytes, len(bs))
+	for i, b := range bs {
+		ret[i] = b
+	}
+	return ret
+}
+
+func fromHexBytesSlice(hs []hexBytes) [][]byte {
+	if hs == nil {
+		return nil
+	}
+	ret := make([][]byte, len(hs))
+	for i, h := range hs {
+		ret[i] = h
+	}
+	return ret
+}
+
+// strictUnmarshalJSON decodes data into v, which must be a pointer to a struct
+// whose json tags are exactly fields. Missing and unknown fields are rejected,
+// except that a field written with a trailing "?" may be missing.
+func strictUnmarshalJSON(typ string, data []byte, v interface{}, fields ...string) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return NewInvalidJSONError(typ, err.Error())
+	}
+	if raw == nil {
+		return NewInvalidJSONError(typ, "expected an object, got null")
+	}
+	for _, f := range fields {
+		name := strings.TrimSuffix(f, "?")
+		if _, found := raw[name]; !found && name == f {
+			return NewInvalidJSONError(typ, fmt.Sprintf("missing field %q", f))
+		}
+		delete(raw, name)
+	}
+	if len(raw) > 0 {
+		unknown := make([]string, 0, len(raw))
+		for f := range raw {
+			unknown = append(unknown, f)
+		}
+		sort.Strings(unknown)
+		return NewInvalidJSONError(typ, fmt.Sprintf("unknown field %q", unknown[0]))
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return NewInvalidJSONError(typ, err.Error())
+	}
+	return nil
+}
+
+func (d TransparencyDigest) MarshalJSON() ([]byte, error) {
+	return hexBytes(d).MarshalJSON()
+}
+
+func (d *TransparencyDigest) UnmarshalJSON(b []byte) error {
+	var h hexBytes
+	if err := h.UnmarshalJSON(b); err != nil {
+		return NewInvalidJSONError("TransparencyDigest", err.Error())
+	}
+	*d = TransparencyDigest(h)
+	return nil
+}
+
+type rootMetadataJSON struct {
+	RootVersion   RootVersion `json:"root_version"`
+	Seqno         Seqno       `json:"seqno"`
+	BareRootHash  hexBytes    `json:"bare_root_hash"`
+	Period        Period      `json:"period"`
+	VRFPublicKeyX hexBytes    `json:"vrf_public_key_x"`
+	VRFPublicKeyY hexBytes    `json:"vrf_public_key_y"`
+	AddOnsHash    hexBytes    `json:"add_ons_hash"`
+}
+
+func (r RootMetadata) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rootMetadataJSON{
+		RootVersion:   r.RootVersion,
+		Seqno:         r.Seqno,
+		BareRootHash:  r.BareRootHash,
+		Period:        r.Period,
+		VRFPublicKeyX: r.VRFPublicKeyX,
+		VRFPublicKeyY: r.VRFPublicKeyY,
+		AddOnsHash:    r.AddOnsHash,
+	})
+}
+
+func (r *RootMetadata) UnmarshalJSON(b []byte) error {
+	var j rootMetadataJSON
+	if err := strictUnmarshalJSON("RootMetadata", b, &j, "root_version", "seqno",
+		"bare_root_hash", "period", "vrf_public_key_x", "vrf_public_key_y", "add_ons_hash"); err != nil {
+		return err
+	}
+	*r = RootMetadata{
+		RootVersion:   j.RootVersion,
+		Seqno:         j.Seqno,
+		BareRootHash:  j.BareRootHash,
+		Period:        j.Period,
+		VRFPublicKeyX: j.VRFPublicKeyX,
+		VRFPublicKeyY: j.VRFPublicKeyY,
+		AddOnsHash:    j.AddOnsHash,
+	}
+	return nil
+}
+
+type keyHashPairJSON struct {
+	HiddenKey    hexBytes `json:"hidden_key"`
+	Hash         hexBytes `json:"hash"`
+	AddedAtSeqno Seqno    `json:"added_at_seqno"`
+}
+
+func (k KeyHashPair) MarshalJSON() ([]byte, error) {
+	return json.Marshal(keyHashPairJSON{
+		HiddenKey:    hexBytes(k.HiddenKey),
+		Hash:         k.Hash,
+		AddedAtSeqno: k.AddedAtSeqno,
+	})
+}
+
+func (k *KeyHashPair) UnmarshalJSON(b []byte) error {
+	var j keyHashPairJSON
+	if err := strictUnmarshalJSON("KeyHashPair", b, &j, "hidden_key", "hash", "added_at_seqno"); err != nil {
+		return err
+	}
+	*k = KeyHashPair{
+		HiddenKey:    HiddenKey(j.HiddenKey),
+		Hash:         j.Hash,
+		AddedAtSeqno: j.AddedAtSeqno,
+	}
+	return nil
+}
+
+type merkleInclusionProofJSON struct {
+	OtherPairsInLeaf    []KeyHashPair `json:"other_pairs_in_leaf"`
+	AddedAtSeqno        Seqno         `json:"added_at_seqno"`
+	SiblingHashesOnPath []hexBytes    `json:"sibling_hashes_on_path"`
+	RootMetadataNoHash  RootMetadata  `json:"root_metadata"`
+	HtSiblings          []hexBytes    `json:"ht_siblings"`
+	Entropy             hexBytes      `json:"entropy"`
+	VRFProof            hexBytes      `json:"vrf_proof"`
+	VRFBatchPoints      hexBytes      `json:"vrf_batch_points,omitempty"`
+}
+
+// MarshalJSON encodes the proof for non-Go clients. OtherPairsInLeaf is
+// encoded as null when nil and as a (possibly empty) array otherwise, as the
+// distinction matters to the verifier.
+func (p MerkleInclusionProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(merkleInclusionProofJSON{
+		OtherPairsInLeaf:    p.OtherPairsInLeaf,
+		AddedAtSeqno:        p.AddedAtSeqno,
+		SiblingHashesOnPath: toHexBytesSlice(p.SiblingHashesOnPath),
+		RootMetadataNoHash:  p.RootMetadataNoHash,
+		HtSiblings:          toHexBytesSlice(p.HtSiblings),
+		Entropy:             hexBytes(p.Entropy),
+		VRFProof:            p.VRFProof,
+		VRFBatchPoints:      p.VRFBatchPoints,
+	})
+}
+
+func (p *MerkleInclusionProof) UnmarshalJSON(b []byte) error {
+	var j merkleInclusionProofJSON
+	if err := strictUnmarshalJSON("MerkleInclusionProof", b, &j, "other_pairs_in_leaf", "added_at_seqno",
+		"sibling_hashes_on_path", "root_metadata", "ht_siblings", "entropy", "vrf_proof", "vrf_batch_points?"); err != nil {
+		return err
+	}
+	*p = MerkleInclusionProof{
+		OtherPairsInLeaf:    j.OtherPairsInLeaf,
+		AddedAtSeqno:        j.AddedAtSeqno,
+		SiblingHashesOnPath: fromHexBytesSlice(j.SiblingHashesOnPath),
+		RootMetadataNoHash:  j.RootMetadataNoHash,
+		HtSiblings:          fromHexBytesSlice(j.HtSiblings),
+		Entropy:             Entropy(j.Entropy),
+		VRFProof:            j.VRFProof,
+		VRFBatchPoints:      j.VRFBatchPoints,
+	}
+	return nil
+}
+
+type merkleExtensionProofJSON struct {
+	HistoryTreeNodeHashes []hexBytes `json:"history_tree_node_hashes"`
+}
+
+func (p MerkleExtensionProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(merkleExtensionProofJSON{
+		HistoryTreeNodeHashes: toHexBytesSlice(p.HistoryTreeNodeHashes),
+	})
+}
+
+func (p *MerkleExtensionProof) UnmarshalJSON(b []byte) error {
+	var j merkleExtensionProofJSON
+	if err := strictUnmarshalJSON("MerkleExtensionProof", b, &j, "history_tree_node_hashes"); err != nil {
+		return err
+	}
+	*p = MerkleExtensionProof{
+		HistoryTreeNodeHashes: fromHexBytesSlice(j.HistoryTreeNodeHashes),
+	}
+	return nil
+}
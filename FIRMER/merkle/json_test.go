@@ -0,0 +1,144 @@
+package merkle
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONProofsRoundTrip(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	pp := GenPP()
+	st := Init(pp)
+	require.NotNil(t, st)
+
+	S1 := GenerateInitS(1, 50)
+	com_start, _, startSeqno := Update(st, S1, ctx)
+	S2 := GenerateAddS(6)
+	com_end, _, endSeqno := Update(st, S2, ctx)
+
+	// Membership proof.
+	π, value, tSeq := Query(st, endSeqno, S2[1].Key, ctx)
+	enc, err := json.Marshal(π)
+	require.NoError(t, err)
+	var π2 MerkleInclusionProof
+	require.NoError(t, json.Unmarshal(enc, &π2))
+	require.Equal(t, π, π2)
+	require.Equal(t, 1, Verify(com_end, S2[1].Key, value, tSeq, π2, ctx, pp))
+	enc2, err := json.Marshal(π2)
+	require.NoError(t, err)
+	require.Equal(t, enc, enc2)
+
+	// Non-membership proof, where nil and empty OtherPairsInLeaf differ.
+	π, value, tSeq = Query(st, startSeqno, S2[1].Key, ctx)
+	enc, err = json.Marshal(π)
+	require.NoError(t, err)
+	π2 = MerkleInclusionProof{}
+	require.NoError(t, json.Unmarshal(enc, &π2))
+	require.Equal(t, π, π2)
+	require.Equal(t, π.OtherPairsInLeaf == nil, π2.OtherPairsInLeaf == nil)
+	require.Equal(t, 0, Verify(com_start, S2[1].Key, value, tSeq, π2, ctx, pp))
+
+	// Extension proof and digests.
+	eProof, err := st.GetExtensionProof(ctx, nil, startSeqno, endSeqno)
+	require.NoError(t, err)
+	enc, err = json.Marshal(struct {
+		Proof MerkleExtensionProof `json:"proof"`
+		Start TransparencyDigest   `json:"start"`
+		End   TransparencyDigest   `json:"end"`
+	}{eProof, com_start, com_end})
+	require.NoError(t, err)
+	var dec struct {
+		Proof MerkleExtensionProof `json:"proof"`
+		Start TransparencyDigest   `json:"start"`
+		End   TransparencyDigest   `json:"end"`
+	}
+	require.NoError(t, json.Unmarshal(enc, &dec))
+	require.Equal(t, eProof, dec.Proof)
+	verifier := NewMerkleProofVerifier(pp)
+	require.NoError(t, verifier.VerifyExtensionProof(ctx, &dec.Proof, startSeqno, dec.Start, endSeqno, dec.End))
+
+	// Root metadata.
+	_, root, _, err := st.GetLatestRoot(ctx, nil)
+	require.NoError(t, err)
+	enc, err = json.Marshal(root)
+	require.NoError(t, err)
+	var root2 RootMetadata
+	require.NoError(t, json.Unmarshal(enc, &root2))
+	require.Equal(t, root, root2)
+}
+
+func TestJSONNilAndEmptyBytes(t *testing.T) {
+	p := MerkleInclusionProof{
+		OtherPairsInLeaf:    []KeyHashPair{},
+		SiblingHashesOnPath: [][]byte{{}, nil, {0x01}},
+		Entropy:             Entropy{},
+	}
+	enc, err := json.Marshal(p)
+	require.NoError(t, err)
+	var p2 MerkleInclusionProof
+	require.NoError(t, json.Unmarshal(enc, &p2))
+	require.Equal(t, p, p2)
+	require.NotNil(t, p2.OtherPairsInLeaf)
+	require.NotNil(t, p2.Entropy)
+	require.Nil(t, p2.VRFProof)
+	require.Nil(t, p2.SiblingHashesOnPath[1])
+	require.NotNil(t, p2.SiblingHashesOnPath[0])
+}
+
+func TestJSONVRFBatchPoints(t *testing.T) {
+	// Proofs without points are encoded as before.
+	enc, err := json.Marshal(MerkleInclusionProof{VRFProof: []byte{0x01}})
+	require.NoError(t, err)
+	require.NotContains(t, string(enc), "vrf_batch_points")
+	var p MerkleInclusionProof
+	require.NoError(t, json.Unmarshal(enc, &p))
+	require.Nil(t, p.VRFBatchPoints)
+
+	enc, err = json.Marshal(MerkleInclusionProof{VRFProof: []byte{0x01}, VRFBatchPoints: []byte{0x02, 0x03}})
+	require.NoError(t, err)
+	require.Contains(t, string(enc), `"vrf_batch_points":"0203"`)
+	require.NoError(t, json.Unmarshal(enc, &p))
+	require.Equal(t, []byte{0x02, 0x03}, p.VRFBatchPoints)
+}
+
+func TestJSONStrictDecoding(t *testing.T) {
+	valid := `{"root_version":1,"seqno":2,"bare_root_hash":"00ff","period":0,` +
+		`"vrf_public_key_x":"01","vrf_public_key_y":"02","add_ons_hash":null}`
+	var r RootMetadata
+	require.NoError(t, json.Unmarshal([]byte(valid), &r))
+	require.Equal(t, []byte{0x00, 0xff}, r.BareRootHash)
+
+	for _, in := range []string{
+		// missing field
+		`{"root_version":1,"seqno":2,"bare_root_hash":"00ff","period":0,"vrf_public_key_x":"01","vrf_public_key_y":"02"}`,
+		// unknown field
+		`{"root_version":1,"seqno":2,"bare_root_hash":"00ff","period":0,"vrf_public_key_x":"01","vrf_public_key_y":"02","add_ons_hash":null,"x":1}`,
+		// uppercase hex
+		`{"root_version":1,"seqno":2,"bare_root_hash":"00FF","period":0,"vrf_public_key_x":"01","vrf_public_key_y":"02","add_ons_hash":null}`,
+		// odd length hex
+		`{"root_version":1,"seqno":2,"bare_root_hash":"0ff","period":0,"vrf_public_key_x":"01","vrf_public_key_y":"02","add_ons_hash":null}`,
+		// base64 instead of hex
+		`{"root_version":1,"seqno":2,"bare_root_hash":"AP8=","period":0,"vrf_public_key_x":"01","vrf_public_key_y":"02","add_ons_hash":null}`,
+		// wrong type
+		`{"root_version":1,"seqno":"2","bare_root_hash":"00ff","period":0,"vrf_public_key_x":"01","vrf_public_key_y":"02","add_ons_hash":null}`,
+		`null`,
+		`[]`,
+	} {
+		var r RootMetadata
+		err := json.Unmarshal([]byte(in), &r)
+		require.Error(t, err, in)
+		require.IsType(t, InvalidJSONError{}, err, in)
+	}
+
+	var p MerkleInclusionProof
+	err := json.Unmarshal([]byte(`{"other_pairs_in_leaf":null,"added_at_seqno":0,"sibling_hashes_on_path":null,`+
+		`"root_metadata":{"root_version":1},"ht_siblings":null,"entropy":null,"vrf_proof":null}`), &p)
+	require.Error(t, err)
+
+	var td TransparencyDigest
+	require.Error(t, json.Unmarshal([]byte(`"zz"`), &td))
+	require.NoError(t, json.Unmarshal([]byte(`"abcd"`), &td))
+	require.Equal(t, TransparencyDigest{0xab, 0xcd}, td)
+}
@@ -0,0 +1,94 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/cloudflare/bn256"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeyDerivationVersion identifies how the long-term secret s_U is derived
+// from the OPRF output pw_U* = k_U*H(pw_U) and the password pw_U. The version
+// must be stored alongside anything derived from s_U, so that keys made with
+// an older version can still be recomputed and migrated.
+type KeyDerivationVersion uint8
+
+const (
+	// KeyDerivationLegacy takes the first 32 bytes of pw_U*.Marshal() || pw_U.
+	// That is the x coordinate of pw_U* only: the password never reaches the
+	// key, and the result is not always below bn256.Order. It is kept only to
+	// recompute keys made before KeyDerivationV2.
+	KeyDerivationLegacy KeyDerivationVersion = 1
+	// KeyDerivationV2 stretches the password with Argon2id, salted by pw_U*,
+	// then extracts s_U with HKDF-SHA256 from pw_U* and the stretched password,
+	// and reduces it into Z_q. The salts and the HKDF info are domain tags of
+	// the deployment's ProtocolParams.
+	KeyDerivationV2 KeyDerivationVersion = 2
+
+	CurrentKeyDerivationVersion = KeyDerivationV2
+)
+
+// Argon2id parameters of KeyDerivationV2. Changing them requires a new
+// KeyDerivationVersion.
+const (
+	kdfV2Argon2Time    = 2
+	kdfV2Argon2Memory  = 19 * 1024 // KiB
+	kdfV2Argon2Threads = 1
+	kdfV2Argon2KeyLen  = 32
+)
+
+// DeriveLongTermKey derives the user's long-term BLS key from the unblinded
+// OPRF output pwStar and the password pw, with the given derivation version.
+// KeyDerivationV2 fails on the zero ProtocolParams.
+func DeriveLongTermKey(params ProtocolParams, version KeyDerivationVersion, pwStar *bn256.G1, pw []byte) (*PrivateKey, error) {
+	var x *big.Int
+	switch version {
+	case KeyDerivationLegacy:
+		combined := append(pwStar.Marshal(), pw...)
+		if len(combined) > 32 {
+			combined = combined[:32]
+		}
+		x = new(big.Int).SetBytes(combined)
+	case KeyDerivationV2:
+		var err error
+		x, err = deriveScalarV2(params, pwStar, pw)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown key derivation version %d", version)
+	}
+	if x.Sign() == 0 {
+		return nil, fmt.Errorf("derived BLS private key is zero")
+	}
+
+	var privKey PrivateKey
+	if err := privKey.FromBytes(x.Bytes()); err != nil {
+		return nil, err
+	}
+	return &privKey, nil
+}
+
+func deriveScalarV2(params ProtocolParams, pwStar *bn256.G1, pw []byte) (*big.Int, error) {
+	if err := params.check("DeriveLongTermKey"); err != nil {
+		return nil, err
+	}
+	pwStarBytes := pwStar.Marshal()
+
+	saltHash := sha256.New()
+	saltHash.Write(params.kdfArgon2SaltTag)
+	saltHash.Write(pwStarBytes)
+	stretched := argon2.IDKey(pw, saltHash.Sum(nil), kdfV2Argon2Time, kdfV2Argon2Memory, kdfV2Argon2Threads, kdfV2Argon2KeyLen)
+
+	ikm := append(pwStarBytes, stretched...)
+	// 48 bytes reduced modulo the 254-bit group order leave a bias below 2^-128.
+	okm := make([]byte, 48)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, params.kdfHKDFSalt, params.kdfHKDFInfo), okm); err != nil {
+		return nil, fmt.Errorf("HKDF failed: %v", err)
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(okm), bn256.Order), nil
+}
@@ -0,0 +1,101 @@
+package merkle
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/cloudflare/bn256"
+	"github.com/stretchr/testify/require"
+)
+
+func oprfOutputForTest(pw []byte, k int64) *bn256.G1 {
+	return new(bn256.G1).ScalarMult(testParams.HashPassword(pw), big.NewInt(k))
+}
+
+func TestDeriveLongTermKeyMigration(t *testing.T) {
+	pw := []byte("password123")
+	pwStar := oprfOutputForTest(pw, 12345)
+
+	legacy, err := DeriveLongTermKey(testParams, KeyDerivationLegacy, pwStar, pw)
+	require.NoError(t, err)
+	require.Equal(t, new(big.Int).SetBytes(pwStar.Marshal()[:32]), legacy.x)
+
+	v2, err := DeriveLongTermKey(testParams, KeyDerivationV2, pwStar, pw)
+	require.NoError(t, err)
+	require.NotEqual(t, legacy.ToBytes(), v2.ToBytes())
+	require.NotEqual(t, legacy.GetPublicKey().ToBytes(), v2.GetPublicKey().ToBytes())
+	require.Equal(t, -1, v2.x.Cmp(bn256.Order))
+	require.Equal(t, 1, v2.x.Sign())
+
+	again, err := DeriveLongTermKey(testParams, KeyDerivationV2, pwStar, pw)
+	require.NoError(t, err)
+	require.Equal(t, v2.ToBytes(), again.ToBytes())
+
+	current, err := DeriveLongTermKey(testParams, CurrentKeyDerivationVersion, pwStar, pw)
+	require.NoError(t, err)
+	require.Equal(t, v2.ToBytes(), current.ToBytes())
+
+	_, err = DeriveLongTermKey(testParams, KeyDerivationVersion(0), pwStar, pw)
+	require.Error(t, err)
+	_, err = DeriveLongTermKey(testParams, KeyDerivationVersion(3), pwStar, pw)
+	require.Error(t, err)
+}
+
+func TestDeriveLongTermKeyUsesPassword(t *testing.T) {
+	pw := []byte("password123")
+	wrong := []byte("password124")
+	pwStar := oprfOutputForTest(pw, 12345)
+
+	// The legacy derivation ignores the password entirely.
+	legacy, err := DeriveLongTermKey(testParams, KeyDerivationLegacy, pwStar, pw)
+	require.NoError(t, err)
+	legacyWrong, err := DeriveLongTermKey(testParams, KeyDerivationLegacy, pwStar, wrong)
+	require.NoError(t, err)
+	require.Equal(t, legacy.ToBytes(), legacyWrong.ToBytes())
+
+	// With V2 a wrong password, or a wrong OPRF output, gives an unrelated key.
+	v2, err := DeriveLongTermKey(testParams, KeyDerivationV2, pwStar, pw)
+	require.NoError(t, err)
+	v2Wrong, err := DeriveLongTermKey(testParams, KeyDerivationV2, pwStar, wrong)
+	require.NoError(t, err)
+	require.NotEqual(t, v2.ToBytes(), v2Wrong.ToBytes())
+	v2WrongStar, err := DeriveLongTermKey(testParams, KeyDerivationV2, oprfOutputForTest(wrong, 12345), wrong)
+	require.NoError(t, err)
+	require.NotEqual(t, v2.ToBytes(), v2WrongStar.ToBytes())
+	v2OtherServerKey, err := DeriveLongTermKey(testParams, KeyDerivationV2, oprfOutputForTest(pw, 12346), pw)
+	require.NoError(t, err)
+	require.NotEqual(t, v2.ToBytes(), v2OtherServerKey.ToBytes())
+
+	// Unrelated keys: no simple relation such as a small difference.
+	diff := new(big.Int).Sub(v2.x, v2Wrong.x)
+	require.Greater(t, diff.Abs(diff).BitLen(), 128)
+}
+
+func TestDeviceKeyGenWithVersion(t *testing.T) {
+	ID_DU := []byte("device1")
+	pw_U := []byte("password1")
+	k_U := big.NewInt(12345)
+
+	sLegacy, pkLegacy, _, Q1, err := DeviceKeyGenWithVersion(testParams, KeyDerivationLegacy, ID_DU, pw_U, k_U)
+	require.NoError(t, err)
+	sV2, pkV2, S_DU, Q2, err := DeviceKeyGen(testParams, ID_DU, pw_U, k_U)
+	require.NoError(t, err)
+
+	require.NotEqual(t, sLegacy, sV2)
+	require.NotEqual(t, pkLegacy.Marshal(), pkV2.Marshal())
+	require.Equal(t, Q1.Marshal(), Q2.Marshal())
+	require.Equal(t, new(bn256.G1).ScalarMult(Q2, sV2).Marshal(), S_DU.Marshal())
+
+	_, _, _, _, err = DeviceKeyGenWithVersion(testParams, KeyDerivationVersion(9), ID_DU, pw_U, k_U)
+	require.Error(t, err)
+}
+
+func TestKeyDerivationRequiresProtocolParams(t *testing.T) {
+	pw := []byte("password123")
+	_, err := DeriveLongTermKey(ProtocolParams{}, KeyDerivationV2, oprfOutputForTest(pw, 12345), pw)
+	require.IsType(t, MissingProtocolParamsError{}, err)
+	_, _, _, _, err = DeviceKeyGen(ProtocolParams{}, []byte("device1"), pw, big.NewInt(12345))
+	require.IsType(t, MissingProtocolParamsError{}, err)
+	_, _, _, _, err = DeviceKeyGenWithVersion(ProtocolParams{}, KeyDerivationLegacy, []byte("device1"), pw, big.NewInt(12345))
+	require.IsType(t, MissingProtocolParamsError{}, err)
+}
@@ -0,0 +1,270 @@
+package merkle
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"FIRMER/logger"
+	"FIRMER/vrf"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// InMemoryKeyStore is a KeyStore keeping the keys in memory, used by default
+// and for tests.
+type InMemoryKeyStore struct {
+	sync.Mutex
+	vrfKeys    map[Period]*vrf.PrivateKey
+	signingKey *PrivateKey
+}
+
+var _ KeyStore = &InMemoryKeyStore{}
+
+// NewInMemoryKeyStore returns an empty key store.
+func NewInMemoryKeyStore() *InMemoryKeyStore {
+	return &InMemoryKeyStore{vrfKeys: make(map[Period]*vrf.PrivateKey)}
+}
+
+func (s *InMemoryKeyStore) StoreVRFPrivateKey(ctx logger.ContextInterface, t Transaction, p Period, sk *vrf.PrivateKey) error {
+	s.Lock()
+	defer s.Unlock()
+	s.vrfKeys[p] = sk
+	return nil
+}
+
+func (s *InMemoryKeyStore) LookupVRFPrivateKey(ctx logger.ContextInterface, t Transaction, p Period) (*vrf.PrivateKey, error) {
+	s.Lock()
+	defer s.Unlock()
+	sk, ok := s.vrfKeys[p]
+	if !ok {
+		return nil, fmt.Errorf("no private key for period %d", p)
+	}
+	return sk, nil
+}
+
+func (s *InMemoryKeyStore) StoreSigningKey(ctx logger.ContextInterface, t Transaction, sk *PrivateKey) error {
+	s.Lock()
+	defer s.Unlock()
+	s.signingKey = sk
+	return nil
+}
+
+func (s *InMemoryKeyStore) LookupSigningKey(ctx logger.ContextInterface, t Transaction) (*PrivateKey, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.signingKey, nil
+}
+
+// FileKeyStore is a KeyStore keeping each key encrypted in its own file of a
+// directory. The files are sealed with AES-256-GCM under a key derived from a
+// passphrase with Argon2id, whose salt is kept in the header file of the
+// directory along with a sealed check value, so that a wrong passphrase is
+// detected when the store is opened. Each file is bound to its name, so keys
+// cannot be swapped between files.
+type FileKeyStore struct {
+	sync.Mutex
+	dir   string
+	suite *vrf.ECVRFParams
+	aead  cipher.AEAD
+}
+
+var _ KeyStore = &FileKeyStore{}
+
+const (
+	fileKeyStoreVersion    = 1
+	fileKeyStoreHeader     = "keystore"
+	fileKeyStoreSigningKey = "signing.key"
+	fileKeyStoreSaltLength = 16
+
+	fileKeyStoreArgon2Time    = 3
+	fileKeyStoreArgon2Memory  = 64 * 1024
+	fileKeyStoreArgon2Threads = 4
+)
+
+var fileKeyStoreCheck = []byte("FIRMER key store")
+
+// OpenFileKeyStore opens the key store in directory dir with passphrase,
+// creating it if dir holds none. The VRF keys are for the suite of cfg.
+func OpenFileKeyStore(cfg Config, dir string, passphrase []byte) (*FileKeyStore, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty key store passphrase")
+	}
+	s := &FileKeyStore{dir: dir, suite: cfg.ECVRF.Params()}
+
+	header, err := os.ReadFile(filepath.Join(dir, fileKeyStoreHeader))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, s.create(passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) < 1+fileKeyStoreSaltLength || header[0] != fileKeyStoreVersion {
+		return nil, fmt.Errorf("invalid key store header in %s", dir)
+	}
+	if s.aead, err = fileKeyStoreAEAD(passphrase, header[1:1+fileKeyStoreSaltLength]); err != nil {
+		return nil, err
+	}
+	check, err := s.open(fileKeyStoreHeader, header[1+fileKeyStoreSaltLength:])
+	if err != nil {
+		return nil, err
+	}
+	if string(check) != string(fileKeyStoreCheck) {
+		return nil, NewKeyStoreAuthenticationError(fileKeyStoreHeader)
+	}
+	return s, nil
+}
+
+func (s *FileKeyStore) create(passphrase []byte) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	salt := make([]byte, fileKeyStoreSaltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	var err error
+	if s.aead, err = fileKeyStoreAEAD(passphrase, salt); err != nil {
+		return err
+	}
+	sealed, err := s.seal(fileKeyStoreHeader, fileKeyStoreCheck)
+	if err != nil {
+		return err
+	}
+	header := append([]byte{fileKeyStoreVersion}, salt...)
+	return s.writeFile(fileKeyStoreHeader, append(header, sealed...))
+}
+
+func fileKeyStoreAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(passphrase, salt, fileKeyStoreArgon2Time, fileKeyStoreArgon2Memory, fileKeyStoreArgon2Threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the nonce followed by the encryption of plaintext, bound to
+// the file name.
+func (s *FileKeyStore) seal(name string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, fileKeyStoreAD(name)), nil
+}
+
+func (s *FileKeyStore) open(name string, sealed []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, NewKeyStoreAuthenticationError(name)
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, fileKeyStoreAD(name))
+	if err != nil {
+		return nil, NewKeyStoreAuthenticationError(name)
+	}
+	return plaintext, nil
+}
+
+func fileKeyStoreAD(name string) []byte {
+	return append([]byte{fileKeyStoreVersion}, name...)
+}
+
+// writeFile replaces the file name atomically, so that a crash never leaves
+// a truncated key behind.
+func (s *FileKeyStore) writeFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(s.dir, name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+func (s *FileKeyStore) storeKey(name string, plaintext []byte) error {
+	sealed, err := s.seal(name, plaintext)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(name, sealed)
+}
+
+// lookupKey returns the decrypted content of the file name, or nil if it
+// does not exist.
+func (s *FileKeyStore) lookupKey(name string) ([]byte, error) {
+	sealed, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.open(name, sealed)
+}
+
+func fileKeyStoreVRFKey(p Period) string {
+	return fmt.Sprintf("vrf-%d.key", p)
+}
+
+func (s *FileKeyStore) StoreVRFPrivateKey(ctx logger.ContextInterface, t Transaction, p Period, sk *vrf.PrivateKey) error {
+	s.Lock()
+	defer s.Unlock()
+	b, err := s.suite.MarshalPrivateKey(sk)
+	if err != nil {
+		return err
+	}
+	return s.storeKey(fileKeyStoreVRFKey(p), b)
+}
+
+func (s *FileKeyStore) LookupVRFPrivateKey(ctx logger.ContextInterface, t Transaction, p Period) (*vrf.PrivateKey, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, err := s.lookupKey(fileKeyStoreVRFKey(p))
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, fmt.Errorf("no private key for period %d", p)
+	}
+	return s.suite.UnmarshalPrivateKey(b)
+}
+
+func (s *FileKeyStore) StoreSigningKey(ctx logger.ContextInterface, t Transaction, sk *PrivateKey) error {
+	s.Lock()
+	defer s.Unlock()
+	if sk == nil {
+		err := os.Remove(filepath.Join(s.dir, fileKeyStoreSigningKey))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return s.storeKey(fileKeyStoreSigningKey, sk.Encode())
+}
+
+func (s *FileKeyStore) LookupSigningKey(ctx logger.ContextInterface, t Transaction) (*PrivateKey, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, err := s.lookupKey(fileKeyStoreSigningKey)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return DecodePrivateKey(b)
+}
@@ -0,0 +1,109 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"FIRMER/vrf"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileKeyStore(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	pp := GenPP()
+	dir := filepath.Join(t.TempDir(), "keys")
+	passphrase := []byte("correct horse battery staple")
+
+	ks, err := OpenFileKeyStore(pp, dir, passphrase)
+	require.NoError(t, err)
+	_, err = ks.LookupVRFPrivateKey(ctx, nil, 1)
+	require.Error(t, err)
+	sk, err := ks.LookupSigningKey(ctx, nil)
+	require.NoError(t, err)
+	require.Nil(t, sk)
+
+	vrfKey := vrf.NewKey(pp.ECVRF.Params().EC(), generateRandomBytes(32))
+	require.NoError(t, ks.StoreVRFPrivateKey(ctx, nil, 1, vrfKey))
+	signingKey, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, ks.StoreSigningKey(ctx, nil, signingKey))
+
+	// The keys survive reopening, and are not stored in the clear.
+	ks, err = OpenFileKeyStore(pp, dir, passphrase)
+	require.NoError(t, err)
+	got, err := ks.LookupVRFPrivateKey(ctx, nil, 1)
+	require.NoError(t, err)
+	require.Equal(t, vrfKey.Bytes(), got.Bytes())
+	require.Equal(t, vrfKey.X, got.X)
+	sk, err = ks.LookupSigningKey(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, signingKey.Encode(), sk.Encode())
+	vrfFile, err := os.ReadFile(filepath.Join(dir, "vrf-1.key"))
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(vrfFile, vrfKey.Bytes()))
+
+	_, err = OpenFileKeyStore(pp, dir, []byte("wrong passphrase"))
+	require.IsType(t, KeyStoreAuthenticationError{}, err)
+
+	// A key file moved to another name or modified does not decrypt.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vrf-2.key"), vrfFile, 0600))
+	_, err = ks.LookupVRFPrivateKey(ctx, nil, 2)
+	require.IsType(t, KeyStoreAuthenticationError{}, err)
+	vrfFile[len(vrfFile)-1] ^= 1
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vrf-1.key"), vrfFile, 0600))
+	_, err = ks.LookupVRFPrivateKey(ctx, nil, 1)
+	require.IsType(t, KeyStoreAuthenticationError{}, err)
+
+	require.NoError(t, ks.StoreSigningKey(ctx, nil, nil))
+	sk, err = ks.LookupSigningKey(ctx, nil)
+	require.NoError(t, err)
+	require.Nil(t, sk)
+}
+
+func TestTreeWithFileKeyStore(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	pp := GenPP()
+	dir := t.TempDir()
+	passphrase := []byte("tree keys")
+	eng := NewInMemoryStorageEngine(pp)
+
+	ks, err := OpenFileKeyStore(pp, dir, passphrase)
+	require.NoError(t, err)
+	tree, err := NewTreeWithKeyStore(pp, 2, eng, ks, RootVersionV1)
+	require.NoError(t, err)
+	signingKey, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, tree.SetSigningKey(ctx, signingKey))
+
+	S1 := GenerateInitS(1, 20)
+	_, _, err = tree.Build(ctx, nil, S1, nil, false)
+	require.NoError(t, err)
+	_, _, err = tree.Rotate(ctx, nil, nil)
+	require.NoError(t, err)
+	_, err = ks.LookupVRFPrivateKey(ctx, nil, 2)
+	require.NoError(t, err)
+
+	// A tree over the same storage picks up its keys from the reopened store.
+	ks, err = OpenFileKeyStore(pp, dir, passphrase)
+	require.NoError(t, err)
+	tree, err = NewTreeWithKeyStore(pp, 2, eng, ks, RootVersionV1)
+	require.NoError(t, err)
+	S2 := GenerateInitS(30, 40)
+	s, td, err := tree.Build(ctx, nil, S2, nil, false)
+	require.NoError(t, err)
+	sth, err := tree.SignedTreeHead(ctx, nil, s)
+	require.NoError(t, err)
+	require.NoError(t, sth.Verify(pp.Params, signingKey.GetPublicKey()))
+
+	// Proofs made with the reloaded rotated VRF key verify.
+	found, value, proof, err := tree.QueryKey(ctx, nil, s, S1[0].Key)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, S1[0].Value, value)
+	verifier := NewMerkleProofVerifier(pp)
+	require.NoError(t, verifier.VerifyInclusionProof(ctx, S1[0], &proof, td))
+}
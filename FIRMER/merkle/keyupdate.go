@@ -0,0 +1,58 @@
+package merkle
+
+import (
+	"fmt"
+	"math/big"
+
+	"FIRMER/logger"
+
+	"github.com/cloudflare/bn256"
+)
+
+// KeyUpdate changes the password of a user to pw_U. It derives the new
+// long-term key pair (s_U, PK_U) from k_U*H(pw_U), re-derives S_DU for each
+// device in devices, and publishes one directory epoch in which every device
+// gets its new record as the next version while its current version is
+// revoked. devices must list every registered device of the user, so that no
+// device keeps a current record with the old PK_U.
+//
+// The returned DeviceKeys are in the order of devices. Clients trusting the
+// returned commitment get the new records from PubKeyReq.
+func KeyUpdate(ctx logger.ContextInterface, params ProtocolParams, d *KeyDirectory, pw_U []byte, k_U *big.Int, devices [][]byte) ([]DeviceKeys, DirectoryCommitment, error) {
+	pw_UStar := new(bn256.G1).ScalarMult(params.HashPassword(pw_U), k_U)
+	privKey, err := DeriveLongTermKey(params, CurrentKeyDerivationVersion, pw_UStar, pw_U)
+	if err != nil {
+		return nil, DirectoryCommitment{}, err
+	}
+	return ReissueDeviceKeys(ctx, params, d, privKey, devices)
+}
+
+// ReissueDeviceKeys re-derives S_DU for each device in devices from the new
+// long-term key privKey, and publishes one directory epoch in which every
+// device gets its new record while its current one is revoked. It is the
+// second half of KeyUpdate, for clients which already obtained privKey, e.g.
+// by running the OPRF against a rotated k_U.
+func ReissueDeviceKeys(ctx logger.ContextInterface, params ProtocolParams, d *KeyDirectory, privKey *PrivateKey, devices [][]byte) ([]DeviceKeys, DirectoryCommitment, error) {
+	if len(devices) == 0 {
+		return nil, DirectoryCommitment{}, fmt.Errorf("no devices to update")
+	}
+	keys := make([]DeviceKeys, len(devices))
+	records := make([]DirectoryRecord, len(devices))
+	for i, ID := range devices {
+		Q_DU := params.HashDeviceID(ID)
+		keys[i] = DeviceKeys{
+			LongTermKey: privKey,
+			S_DU:        new(bn256.G1).ScalarMult(Q_DU, privKey.x),
+			Q_DU:        Q_DU,
+		}
+		records[i] = DirectoryRecord{ID: ID, PK_U: privKey.GetPublicKey(), Q_DU: Q_DU}
+	}
+
+	// Apply revokes the versions current before the epoch, so the old records
+	// are revoked and the new ones published in the same epoch.
+	com, err := d.Apply(ctx, records, devices)
+	if err != nil {
+		return nil, DirectoryCommitment{}, err
+	}
+	return keys, com, nil
+}
@@ -0,0 +1,67 @@
+package merkle
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/cloudflare/bn256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyUpdateDirectory(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	pp := GenPP()
+	dir := NewKeyDirectory(Init(pp), Init(pp))
+	k_U := big.NewInt(4242)
+	phone := newAKETestDevice(t, []byte("phone"), []byte("old password"), k_U.Int64())
+	laptop := newAKETestDevice(t, []byte("laptop"), []byte("old password"), k_U.Int64())
+	_, err := dir.Apply(ctx, []DirectoryRecord{phone.record, laptop.record}, nil)
+	require.NoError(t, err)
+	oldProof, err := dir.PubKeyReq(ctx, phone.self.ID)
+	require.NoError(t, err)
+
+	devices := [][]byte{phone.self.ID, laptop.self.ID}
+	keys, com, err := KeyUpdate(ctx, testParams, dir, []byte("new password"), k_U, devices)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, dir.Commitment(), com)
+
+	newPK := keys[0].LongTermKey.GetPublicKey()
+	require.NotEqual(t, phone.record.PK_U.ToBytes(), newPK.ToBytes())
+	_, PK, _, _, err := DeviceKeyGen(testParams, phone.self.ID, []byte("new password"), k_U)
+	require.NoError(t, err)
+	require.Equal(t, PK.Marshal(), newPK.ToBytes())
+
+	verifier := NewDirectoryVerifier(pp, com.Digest())
+	for i, ID := range devices {
+		require.Equal(t, new(bn256.G1).ScalarMult(keys[i].Q_DU, keys[i].LongTermKey.x).Marshal(), keys[i].S_DU.Marshal())
+
+		proof, err := dir.PubKeyReq(ctx, ID)
+		require.NoError(t, err)
+		require.Equal(t, uint32(2), proof.Version)
+		peer, err := verifier.Verify(ctx, ID, proof)
+		require.NoError(t, err)
+		require.Equal(t, newPK.ToBytes(), peer.PK_U.ToBytes())
+		require.Equal(t, keys[i].Q_DU.Marshal(), peer.Q_DU.Marshal())
+	}
+
+	// The old record is not accepted at the new commitment.
+	_, err = verifier.Verify(ctx, phone.self.ID, oldProof)
+	require.IsType(t, KeyOutdatedError{}, err)
+
+	// The re-issued device keys work in SesKeyGen.
+	self1 := AKEIdentity{ID: devices[0], S_DU: keys[0].S_DU}
+	self2 := AKEIdentity{ID: devices[1], S_DU: keys[1].S_DU}
+	self1.KeyProof, err = dir.PubKeyReq(ctx, self1.ID)
+	require.NoError(t, err)
+	self2.KeyProof, err = dir.PubKeyReq(ctx, self2.ID)
+	require.NoError(t, err)
+	ri, rr := runTestAKE(t, ctx, self1, self2.ID, self2, verifier)
+	require.NoError(t, ri.err)
+	require.NoError(t, rr.err)
+	require.Equal(t, ri.key, rr.key)
+
+	// Devices which were never registered cannot be updated.
+	_, _, err = KeyUpdate(ctx, testParams, dir, []byte("newer password"), k_U, [][]byte{[]byte("tablet")})
+	require.Error(t, err)
+}
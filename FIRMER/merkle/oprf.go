@@ -0,0 +1,233 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/cloudflare/bn256"
+)
+
+// This file splits DeviceKeyGen into the two parties of the protocol: the new
+// device D_U (OPRFClient), which knows the password pw_U, and an already
+// paired device PD_U (OPRFServer), which holds the OPRF key k_U. The server
+// only ever sees the blinded value pw_U* = r*H(pw_U). The server publishes
+// K = k_U*g and proves with every evaluation that sigma was made with that
+// key, so it cannot hand out a different k_U per device to link or partition
+// users.
+//
+//	D_U                                      PD_U
+//	Request{pw_U* = r*H(pw_U)}        ->
+//	                                  <-     Evaluation{sigma = k_U*pw_U*, DLEQ}
+//	check DLEQ against K, s_U from r^-1*sigma, S_DU = s_U*Q_DU
+//
+// The two parties are independent of the channel. RunOPRFClient and
+// RunOPRFServer send the request through a Pairing, so that the user can
+// check on both screens that it came from the new device.
+
+// OPRFRequestMessage carries the client's blinded password pw_U*.
+type OPRFRequestMessage struct {
+	_struct struct{} `codec:",toarray"` //nolint
+	PwUStar []byte   `codec:"p"`
+}
+
+// OPRFEvaluationMessage carries the server's evaluation sigma = k_U*pw_U*
+// and a proof that it was made with the key behind the published K.
+type OPRFEvaluationMessage struct {
+	_struct struct{}  `codec:",toarray"` //nolint
+	Sigma   []byte    `codec:"s"`
+	Proof   DLEQProof `codec:"p"`
+}
+
+// DeviceKeys are the keys DeviceKeyGen produces for a device.
+type DeviceKeys struct {
+	// LongTermKey holds s_U and PK_U.
+	LongTermKey *PrivateKey
+	S_DU        *bn256.G1
+	Q_DU        *bn256.G1
+}
+
+type oprfState int
+
+const (
+	oprfStateStart oprfState = iota
+	oprfStateBlinded
+	oprfStateDone
+	oprfStateFailed
+)
+
+func (s oprfState) String() string {
+	switch s {
+	case oprfStateStart:
+		return "start"
+	case oprfStateBlinded:
+		return "blinded"
+	case oprfStateDone:
+		return "done"
+	default:
+		return "failed"
+	}
+}
+
+// OPRFClient is the new device's side of the protocol.
+type OPRFClient struct {
+	state oprfState
+
+	params  ProtocolParams
+	serverK *bn256.G1
+	pw      []byte
+	r       *big.Int
+	pwUStar *bn256.G1
+}
+
+// NewOPRFClient returns a client for the password pw, accepting only
+// evaluations made with the key behind the published server value serverK
+// (see OPRFPublicValue). serverK must not be the identity, which no key
+// k_U in Z_q* gives.
+func NewOPRFClient(params ProtocolParams, pw []byte, serverK *bn256.G1) (*OPRFClient, error) {
+	if err := params.check("NewOPRFClient"); err != nil {
+		return nil, err
+	}
+	if serverK == nil || isG1Identity(serverK) {
+		return nil, fmt.Errorf("invalid OPRF server value: missing or point at infinity")
+	}
+	return &OPRFClient{params: params, pw: pw, serverK: serverK}, nil
+}
+
+// Blind blinds the password into the request for the server (steps 1-2).
+func (c *OPRFClient) Blind() (OPRFRequestMessage, error) {
+	if c.state != oprfStateStart {
+		return OPRFRequestMessage{}, NewProtocolStateError("Blind", c.state.String())
+	}
+	r, err := GenerateRandomInZp()
+	if err != nil {
+		c.state = oprfStateFailed
+		return OPRFRequestMessage{}, err
+	}
+	c.r = r
+	c.pwUStar = new(bn256.G1).ScalarMult(c.params.HashPassword(c.pw), r)
+	c.state = oprfStateBlinded
+	return OPRFRequestMessage{PwUStar: c.pwUStar.Marshal()}, nil
+}
+
+// Finish checks the DLEQ proof of the server evaluation, unblinds it and
+// derives the device keys for the device identity ID_DU (steps 6-7).
+func (c *OPRFClient) Finish(m OPRFEvaluationMessage, ID_DU []byte) (DeviceKeys, error) {
+	if c.state != oprfStateBlinded {
+		return DeviceKeys{}, NewProtocolStateError("Finish", c.state.String())
+	}
+	c.state = oprfStateFailed
+	sigma, err := unmarshalG1(m.Sigma)
+	if err != nil {
+		return DeviceKeys{}, err
+	}
+	if err := VerifyDLEQ(c.params, c.serverK, c.pwUStar, sigma, m.Proof); err != nil {
+		return DeviceKeys{}, err
+	}
+	rInv := new(big.Int).ModInverse(c.r, bn256.Order)
+	pwStar := new(bn256.G1).ScalarMult(sigma, rInv)
+	privKey, err := DeriveLongTermKey(c.params, CurrentKeyDerivationVersion, pwStar, c.pw)
+	if err != nil {
+		return DeviceKeys{}, err
+	}
+	Q_DU := c.params.HashDeviceID(ID_DU)
+	S_DU := new(bn256.G1).ScalarMult(Q_DU, privKey.x)
+	c.state = oprfStateDone
+	return DeviceKeys{LongTermKey: privKey, S_DU: S_DU, Q_DU: Q_DU}, nil
+}
+
+// OPRFServer is the paired device's side of the protocol, holding k_U.
+type OPRFServer struct {
+	state oprfState
+
+	params ProtocolParams
+	k_U    *big.Int
+	K      *bn256.G1
+}
+
+// NewOPRFServer returns a server evaluating the OPRF with key k_U.
+func NewOPRFServer(params ProtocolParams, k_U *big.Int) (*OPRFServer, error) {
+	if err := params.check("NewOPRFServer"); err != nil {
+		return nil, err
+	}
+	return &OPRFServer{params: params, k_U: k_U, K: OPRFPublicValue(k_U)}, nil
+}
+
+// PublicValue returns K = k_U*g, which clients use to check evaluations.
+func (s *OPRFServer) PublicValue() *bn256.G1 {
+	return s.K
+}
+
+// Evaluate returns sigma = k_U*pw_U* with a DLEQ proof against K, for the
+// pw_U* of the request m (steps 3-5).
+func (s *OPRFServer) Evaluate(m OPRFRequestMessage) (OPRFEvaluationMessage, error) {
+	if s.state != oprfStateStart {
+		return OPRFEvaluationMessage{}, NewProtocolStateError("Evaluate", s.state.String())
+	}
+	s.state = oprfStateFailed
+	pwUStar, err := unmarshalG1(m.PwUStar)
+	if err != nil {
+		return OPRFEvaluationMessage{}, err
+	}
+	sigma := new(bn256.G1).ScalarMult(pwUStar, s.k_U)
+	proof, err := ProveDLEQ(s.params, s.k_U, s.K, pwUStar, sigma)
+	if err != nil {
+		return OPRFEvaluationMessage{}, err
+	}
+	s.state = oprfStateDone
+	return OPRFEvaluationMessage{Sigma: sigma.Marshal(), Proof: proof}, nil
+}
+
+// unmarshalG1 decodes a G1 point, rejecting trailing bytes and the identity.
+func unmarshalG1(b []byte) (*bn256.G1, error) {
+	p := new(bn256.G1)
+	rest, err := p.Unmarshal(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("invalid G1 point: %d trailing bytes", len(rest))
+	}
+	if !bytes.Equal(p.Marshal(), b) {
+		return nil, fmt.Errorf("invalid G1 point: non-canonical encoding")
+	}
+	if isG1Identity(p) {
+		return nil, fmt.Errorf("invalid G1 point: point at infinity")
+	}
+	return p, nil
+}
+
+// RunOPRFClient runs the client side of the protocol over rw, sending the
+// request through a Pairing. confirm is shown the SAS in format and reports
+// whether the user saw the same one on the server device.
+func RunOPRFClient(rw io.ReadWriter, c *OPRFClient, ID_DU []byte, format SASFormat, confirm func(sas string) bool) (DeviceKeys, error) {
+	if _, err := c.Blind(); err != nil {
+		return DeviceKeys{}, err
+	}
+	p := NewPairingInitiator(c.params, c.pwUStar)
+	if err := RunPairingInitiator(rw, p, format, confirm); err != nil {
+		return DeviceKeys{}, err
+	}
+	var eval OPRFEvaluationMessage
+	if err := ReadMessage(rw, MessageTypeOPRFEvaluation, &eval); err != nil {
+		return DeviceKeys{}, err
+	}
+	return c.Finish(eval, ID_DU)
+}
+
+// RunOPRFServer runs the server side of the protocol over rw, receiving the
+// request through a Pairing. confirm is shown the SAS in format and reports
+// whether the user saw the same one on the new device; if not, the server
+// stops without evaluating the OPRF.
+func RunOPRFServer(rw io.ReadWriter, s *OPRFServer, format SASFormat, confirm func(sas string) bool) error {
+	pwUStar, err := RunPairingResponder(rw, NewPairingResponder(s.params), format, confirm)
+	if err != nil {
+		return err
+	}
+	eval, err := s.Evaluate(OPRFRequestMessage{PwUStar: pwUStar.Marshal()})
+	if err != nil {
+		return err
+	}
+	return WriteMessage(rw, MessageTypeOPRFEvaluation, eval)
+}
@@ -0,0 +1,266 @@
+package merkle
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"FIRMER/logger"
+
+	"github.com/cloudflare/bn256"
+)
+
+// The OPRF server holds one key k_U per user. Rotating k_U changes pw_U*, and
+// with it s_U and PK_U, so a rotation happens in two steps: Rotate adds the
+// next key next to the current one, each client runs the OPRF against the
+// next key (see OPRFKeyManager.Server) and republishes its device keys with
+// ReissueDeviceKeys, and CompleteRotation then drops the old key.
+//
+// Since the server cannot tell a right password from a wrong one, the number
+// of evaluations per user and time window is the only limit on online
+// password guessing: OPRFKeyManager refuses to evaluate once it is reached.
+
+// OPRFKeyRecord is the OPRF key material of one user.
+type OPRFKeyRecord struct {
+	// Version counts rotations, starting from 1.
+	Version uint32
+	K_U     *big.Int
+	// Next is the key of version Version+1 while a rotation is in progress,
+	// or nil.
+	Next *big.Int
+}
+
+// OPRFSecretStore stores the OPRF keys of users. Implementations should keep
+// them in secure storage, e.g. an HSM or a secrets manager.
+type OPRFSecretStore interface {
+	// StoreOPRFKey creates or replaces the record of user.
+	StoreOPRFKey(ctx logger.ContextInterface, user []byte, r OPRFKeyRecord) error
+
+	// LookupOPRFKey returns the record of user, or an OPRFKeyNotFoundError.
+	LookupOPRFKey(ctx logger.ContextInterface, user []byte) (OPRFKeyRecord, error)
+
+	// DeleteOPRFKey deletes the record of user, or returns an
+	// OPRFKeyNotFoundError.
+	DeleteOPRFKey(ctx logger.ContextInterface, user []byte) error
+}
+
+// InMemoryOPRFSecretStore is an OPRFSecretStore for tests.
+type InMemoryOPRFSecretStore struct {
+	sync.Mutex
+	records map[string]OPRFKeyRecord
+}
+
+var _ OPRFSecretStore = &InMemoryOPRFSecretStore{}
+
+// NewInMemoryOPRFSecretStore returns an empty store.
+func NewInMemoryOPRFSecretStore() *InMemoryOPRFSecretStore {
+	return &InMemoryOPRFSecretStore{records: make(map[string]OPRFKeyRecord)}
+}
+
+func (s *InMemoryOPRFSecretStore) StoreOPRFKey(ctx logger.ContextInterface, user []byte, r OPRFKeyRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	s.records[string(user)] = r
+	return nil
+}
+
+func (s *InMemoryOPRFSecretStore) LookupOPRFKey(ctx logger.ContextInterface, user []byte) (OPRFKeyRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+	r, ok := s.records[string(user)]
+	if !ok {
+		return OPRFKeyRecord{}, NewOPRFKeyNotFoundError(user)
+	}
+	return r, nil
+}
+
+func (s *InMemoryOPRFSecretStore) DeleteOPRFKey(ctx logger.ContextInterface, user []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.records[string(user)]; !ok {
+		return NewOPRFKeyNotFoundError(user)
+	}
+	delete(s.records, string(user))
+	return nil
+}
+
+// OPRFThrottle limits the evaluations per user to MaxAttempts in any Window.
+type OPRFThrottle struct {
+	MaxAttempts int
+	Window      time.Duration
+}
+
+// DefaultOPRFThrottle allows 10 evaluations per user and hour.
+var DefaultOPRFThrottle = OPRFThrottle{MaxAttempts: 10, Window: time.Hour}
+
+// OPRFKeyManager is the server component managing the OPRF keys of users.
+type OPRFKeyManager struct {
+	sync.Mutex
+
+	params   ProtocolParams
+	store    OPRFSecretStore
+	throttle OPRFThrottle
+	now      func() time.Time
+
+	// attempts holds the times of the evaluations of each user within the
+	// throttle window, oldest first. Users without an attempt in the window
+	// are removed at most one window after it ended, see sweep.
+	attempts map[string][]time.Time
+	swept    time.Time
+}
+
+// NewOPRFKeyManager returns a manager keeping its keys in store and limiting
+// evaluations with throttle.
+func NewOPRFKeyManager(params ProtocolParams, store OPRFSecretStore, throttle OPRFThrottle) (*OPRFKeyManager, error) {
+	if err := params.check("NewOPRFKeyManager"); err != nil {
+		return nil, err
+	}
+	if throttle.MaxAttempts <= 0 || throttle.Window <= 0 {
+		return nil, fmt.Errorf("invalid OPRF throttle %+v", throttle)
+	}
+	return &OPRFKeyManager{
+		params:   params,
+		store:    store,
+		throttle: throttle,
+		now:      time.Now,
+		attempts: make(map[string][]time.Time),
+	}, nil
+}
+
+// Create picks the first key of user and returns its public value K.
+func (m *OPRFKeyManager) Create(ctx logger.ContextInterface, user []byte) (*bn256.G1, error) {
+	m.Lock()
+	defer m.Unlock()
+	if _, err := m.store.LookupOPRFKey(ctx, user); err == nil {
+		return nil, fmt.Errorf("OPRF key of %q already exists", user)
+	} else if _, ok := err.(OPRFKeyNotFoundError); !ok {
+		return nil, err
+	}
+	k_U, err := GenerateRandomInZp()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.StoreOPRFKey(ctx, user, OPRFKeyRecord{Version: 1, K_U: k_U}); err != nil {
+		return nil, err
+	}
+	return OPRFPublicValue(k_U), nil
+}
+
+// PublicValue returns the public value K of version of the key of user.
+func (m *OPRFKeyManager) PublicValue(ctx logger.ContextInterface, user []byte, version uint32) (*bn256.G1, error) {
+	r, err := m.store.LookupOPRFKey(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	k_U, err := r.key(user, version)
+	if err != nil {
+		return nil, err
+	}
+	return OPRFPublicValue(k_U), nil
+}
+
+// key returns the key of the given version, which must be the current or
+// the next one.
+func (r OPRFKeyRecord) key(user []byte, version uint32) (*big.Int, error) {
+	switch {
+	case version == r.Version:
+		return r.K_U, nil
+	case version == r.Version+1 && r.Next != nil:
+		return r.Next, nil
+	default:
+		return nil, fmt.Errorf("no OPRF key version %d for %q (current: %d)", version, user, r.Version)
+	}
+}
+
+// Rotate starts a rotation of the key of user and returns the version and
+// public value of the next key. Clients re-derive their keys against it
+// before the rotation is completed.
+func (m *OPRFKeyManager) Rotate(ctx logger.ContextInterface, user []byte) (uint32, *bn256.G1, error) {
+	m.Lock()
+	defer m.Unlock()
+	r, err := m.store.LookupOPRFKey(ctx, user)
+	if err != nil {
+		return 0, nil, err
+	}
+	if r.Next != nil {
+		return 0, nil, fmt.Errorf("rotation of the OPRF key of %q is already in progress", user)
+	}
+	if r.Next, err = GenerateRandomInZp(); err != nil {
+		return 0, nil, err
+	}
+	if err := m.store.StoreOPRFKey(ctx, user, r); err != nil {
+		return 0, nil, err
+	}
+	return r.Version + 1, OPRFPublicValue(r.Next), nil
+}
+
+// CompleteRotation makes the next key of user the current one and forgets
+// the old key. It must only be called once the client republished its
+// device keys derived with the next key.
+func (m *OPRFKeyManager) CompleteRotation(ctx logger.ContextInterface, user []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	r, err := m.store.LookupOPRFKey(ctx, user)
+	if err != nil {
+		return err
+	}
+	if r.Next == nil {
+		return fmt.Errorf("no rotation of the OPRF key of %q in progress", user)
+	}
+	return m.store.StoreOPRFKey(ctx, user, OPRFKeyRecord{Version: r.Version + 1, K_U: r.Next})
+}
+
+// Delete deletes the key of user. Its devices cannot derive their keys
+// anymore.
+func (m *OPRFKeyManager) Delete(ctx logger.ContextInterface, user []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.attempts, string(user))
+	return m.store.DeleteOPRFKey(ctx, user)
+}
+
+// Server returns an OPRFServer evaluating with version of the key of user,
+// for a single run of the protocol. Each call counts as an evaluation
+// attempt, and fails with an OPRFThrottledError once user reached the
+// throttle limit.
+func (m *OPRFKeyManager) Server(ctx logger.ContextInterface, user []byte, version uint32) (*OPRFServer, error) {
+	m.Lock()
+	defer m.Unlock()
+	r, err := m.store.LookupOPRFKey(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	k_U, err := r.key(user, version)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	m.sweep(now)
+	attempts := m.attempts[string(user)]
+	for len(attempts) > 0 && now.Sub(attempts[0]) >= m.throttle.Window {
+		attempts = attempts[1:]
+	}
+	if len(attempts) >= m.throttle.MaxAttempts {
+		m.attempts[string(user)] = attempts
+		return nil, NewOPRFThrottledError(user, attempts[0].Add(m.throttle.Window).Sub(now))
+	}
+	m.attempts[string(user)] = append(attempts, now)
+	return NewOPRFServer(m.params, k_U)
+}
+
+// sweep removes the users whose attempts all left the throttle window, so
+// that attempts does not grow with every user ever evaluated. It walks the
+// map at most once per window.
+func (m *OPRFKeyManager) sweep(now time.Time) {
+	if now.Sub(m.swept) < m.throttle.Window {
+		return
+	}
+	for user, attempts := range m.attempts {
+		if now.Sub(attempts[len(attempts)-1]) >= m.throttle.Window {
+			delete(m.attempts, user)
+		}
+	}
+	m.swept = now
+}
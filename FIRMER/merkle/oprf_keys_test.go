@@ -0,0 +1,169 @@
+package merkle
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// runManagedOPRF runs the OPRF between a client of pw and the server of
+// version of the key of user.
+func runManagedOPRF(t *testing.T, m *OPRFKeyManager, user []byte, version uint32, pw, ID_DU []byte) (DeviceKeys, error) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	K, err := m.PublicValue(ctx, user, version)
+	require.NoError(t, err)
+	s, err := m.Server(ctx, user, version)
+	if err != nil {
+		return DeviceKeys{}, err
+	}
+	clientConn, serverConn := net.Pipe()
+	serverErr := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		serverErr <- RunOPRFServer(serverConn, s, SASDigits, func(string) bool { return true })
+	}()
+	keys, err := RunOPRFClient(clientConn, newTestOPRFClient(t, pw, K), ID_DU, SASDigits, func(string) bool { return true })
+	require.NoError(t, <-serverErr)
+	return keys, err
+}
+
+func TestOPRFKeyManager(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	m, err := NewOPRFKeyManager(testParams, NewInMemoryOPRFSecretStore(), DefaultOPRFThrottle)
+	require.NoError(t, err)
+	user, pw := []byte("alice"), []byte("password")
+
+	_, err = m.PublicValue(ctx, user, 1)
+	require.IsType(t, OPRFKeyNotFoundError{}, err)
+	K, err := m.Create(ctx, user)
+	require.NoError(t, err)
+	_, err = m.Create(ctx, user)
+	require.Error(t, err)
+	K1, err := m.PublicValue(ctx, user, 1)
+	require.NoError(t, err)
+	require.Equal(t, K.Marshal(), K1.Marshal())
+
+	keys, err := runManagedOPRF(t, m, user, 1, pw, []byte("phone"))
+	require.NoError(t, err)
+	again, err := runManagedOPRF(t, m, user, 1, pw, []byte("phone"))
+	require.NoError(t, err)
+	require.Equal(t, keys.LongTermKey.ToBytes(), again.LongTermKey.ToBytes())
+
+	pp := GenPP()
+	dir := NewKeyDirectory(Init(pp), Init(pp))
+	_, err = dir.Apply(ctx, []DirectoryRecord{{ID: []byte("phone"), PK_U: keys.LongTermKey.GetPublicKey(), Q_DU: keys.Q_DU}}, nil)
+	require.NoError(t, err)
+
+	// Rotation: both keys are served until the client re-derived its keys.
+	version, K2, err := m.Rotate(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), version)
+	require.NotEqual(t, K.Marshal(), K2.Marshal())
+	_, _, err = m.Rotate(ctx, user)
+	require.Error(t, err)
+	_, err = runManagedOPRF(t, m, user, 1, pw, []byte("phone"))
+	require.NoError(t, err)
+	rotated, err := runManagedOPRF(t, m, user, 2, pw, []byte("phone"))
+	require.NoError(t, err)
+	require.NotEqual(t, keys.LongTermKey.ToBytes(), rotated.LongTermKey.ToBytes())
+
+	reissued, com, err := ReissueDeviceKeys(ctx, testParams, dir, rotated.LongTermKey, [][]byte{[]byte("phone")})
+	require.NoError(t, err)
+	require.Equal(t, rotated.S_DU.Marshal(), reissued[0].S_DU.Marshal())
+	proof, err := dir.PubKeyReq(ctx, []byte("phone"))
+	require.NoError(t, err)
+	peer, err := NewDirectoryVerifier(pp, com.Digest()).Verify(ctx, []byte("phone"), proof)
+	require.NoError(t, err)
+	require.Equal(t, rotated.LongTermKey.GetPublicKey().ToBytes(), peer.PK_U.ToBytes())
+
+	require.NoError(t, m.CompleteRotation(ctx, user))
+	require.Error(t, m.CompleteRotation(ctx, user))
+	_, err = m.Server(ctx, user, 1)
+	require.Error(t, err)
+	K2Current, err := m.PublicValue(ctx, user, 2)
+	require.NoError(t, err)
+	require.Equal(t, K2.Marshal(), K2Current.Marshal())
+
+	require.NoError(t, m.Delete(ctx, user))
+	_, err = m.Server(ctx, user, 2)
+	require.IsType(t, OPRFKeyNotFoundError{}, err)
+	require.IsType(t, OPRFKeyNotFoundError{}, m.Delete(ctx, user))
+}
+
+func TestOPRFKeyManagerThrottle(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	_, err := NewOPRFKeyManager(testParams, NewInMemoryOPRFSecretStore(), OPRFThrottle{})
+	require.Error(t, err)
+
+	m, err := NewOPRFKeyManager(testParams, NewInMemoryOPRFSecretStore(), OPRFThrottle{MaxAttempts: 3, Window: time.Minute})
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+	alice, bob := []byte("alice"), []byte("bob")
+	_, err = m.Create(ctx, alice)
+	require.NoError(t, err)
+	_, err = m.Create(ctx, bob)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = m.Server(ctx, alice, 1)
+		require.NoError(t, err)
+		now = now.Add(10 * time.Second)
+	}
+	_, err = m.Server(ctx, alice, 1)
+	require.IsType(t, OPRFThrottledError{}, err)
+	require.Equal(t, 30*time.Second, err.(OPRFThrottledError).RetryAfter())
+
+	// Other users are not affected.
+	_, err = m.Server(ctx, bob, 1)
+	require.NoError(t, err)
+
+	// The oldest attempt leaves the window.
+	now = now.Add(30 * time.Second)
+	_, err = m.Server(ctx, alice, 1)
+	require.NoError(t, err)
+	_, err = m.Server(ctx, alice, 1)
+	require.IsType(t, OPRFThrottledError{}, err)
+
+	// Once allowed again, the server uses the key of the user.
+	now = now.Add(time.Minute)
+	s, err := m.Server(ctx, alice, 1)
+	require.NoError(t, err)
+	K, err := m.PublicValue(ctx, alice, 1)
+	require.NoError(t, err)
+	require.Equal(t, K.Marshal(), s.PublicValue().Marshal())
+}
+
+func TestOPRFKeyManagerThrottleSweep(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	m, err := NewOPRFKeyManager(testParams, NewInMemoryOPRFSecretStore(), OPRFThrottle{MaxAttempts: 3, Window: time.Minute})
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+
+	var users [][]byte
+	for i := 0; i < 5; i++ {
+		user := []byte(fmt.Sprintf("user%d", i))
+		_, err = m.Create(ctx, user)
+		require.NoError(t, err)
+		_, err = m.Server(ctx, user, 1)
+		require.NoError(t, err)
+		users = append(users, user)
+	}
+	require.Len(t, m.attempts, 5)
+
+	// Users that did not call again within the window are forgotten on the
+	// next evaluation of anyone.
+	now = now.Add(45 * time.Second)
+	_, err = m.Server(ctx, users[0], 1)
+	require.NoError(t, err)
+	now = now.Add(20 * time.Second)
+	_, err = m.Server(ctx, users[1], 1)
+	require.NoError(t, err)
+	require.Len(t, m.attempts, 2)
+	require.Len(t, m.attempts[string(users[0])], 2)
+	require.Len(t, m.attempts[string(users[1])], 1)
+}
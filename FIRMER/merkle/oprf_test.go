@@ -0,0 +1,163 @@
+package merkle
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/bn256"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingConn records everything written to it.
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.written.Write(b)
+	return c.Conn.Write(b)
+}
+
+func newTestOPRFClient(t *testing.T, pw []byte, serverK *bn256.G1) *OPRFClient {
+	c, err := NewOPRFClient(testParams, pw, serverK)
+	require.NoError(t, err)
+	return c
+}
+
+func newTestOPRFServer(t *testing.T, k_U *big.Int) *OPRFServer {
+	s, err := NewOPRFServer(testParams, k_U)
+	require.NoError(t, err)
+	return s
+}
+
+func TestOPRFOverPipe(t *testing.T) {
+	pw := []byte("password123")
+	ID_DU := []byte("Device123")
+	k_U, err := GenerateRandomInZp()
+	require.NoError(t, err)
+
+	clientConn, serverConn := net.Pipe()
+	clientRec := &recordingConn{Conn: clientConn}
+
+	var clientSAS, serverSAS string
+	serverErr := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		serverErr <- RunOPRFServer(serverConn, newTestOPRFServer(t, k_U), SASWords, func(sas string) bool {
+			serverSAS = sas
+			return true
+		})
+	}()
+
+	keys, err := RunOPRFClient(clientRec, newTestOPRFClient(t, pw, OPRFPublicValue(k_U)), ID_DU, SASWords, func(sas string) bool {
+		clientSAS = sas
+		return true
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-serverErr)
+	require.Len(t, strings.Fields(clientSAS), sasLength)
+	require.Equal(t, clientSAS, serverSAS)
+
+	// Same keys as if k_U*H(pw_U) had been computed directly.
+	pwStar := new(bn256.G1).ScalarMult(testParams.HashPassword(pw), k_U)
+	expected, err := DeriveLongTermKey(testParams, CurrentKeyDerivationVersion, pwStar, pw)
+	require.NoError(t, err)
+	require.Equal(t, expected.ToBytes(), keys.LongTermKey.ToBytes())
+	Q_DU := testParams.HashDeviceID(ID_DU)
+	require.Equal(t, Q_DU.Marshal(), keys.Q_DU.Marshal())
+	require.Equal(t, new(bn256.G1).ScalarMult(Q_DU, expected.x).Marshal(), keys.S_DU.Marshal())
+
+	// The server never sees the password nor the unblinded pw_U*.
+	require.False(t, bytes.Contains(clientRec.written.Bytes(), pw))
+	require.False(t, bytes.Contains(clientRec.written.Bytes(), testParams.HashPassword(pw).Marshal()))
+}
+
+func TestOPRFSASRejected(t *testing.T) {
+	k_U := big.NewInt(12345)
+	clientConn, serverConn := net.Pipe()
+	serverErr := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		serverErr <- RunOPRFServer(serverConn, newTestOPRFServer(t, k_U), SASDigits, func(string) bool { return false })
+	}()
+	_, err := RunOPRFClient(clientConn, newTestOPRFClient(t, []byte("pw"), OPRFPublicValue(k_U)), []byte("dev"), SASDigits, func(string) bool { return true })
+	require.ErrorIs(t, err, io.EOF)
+	require.IsType(t, SASMismatchError{}, <-serverErr)
+}
+
+func TestOPRFStateMachines(t *testing.T) {
+	s := newTestOPRFServer(t, big.NewInt(12345))
+	c := newTestOPRFClient(t, []byte("pw"), s.PublicValue())
+
+	_, err := c.Finish(OPRFEvaluationMessage{}, []byte("dev"))
+	require.IsType(t, ProtocolStateError{}, err)
+
+	req, err := c.Blind()
+	require.NoError(t, err)
+	_, err = c.Blind()
+	require.IsType(t, ProtocolStateError{}, err)
+	eval, err := s.Evaluate(req)
+	require.NoError(t, err)
+	_, err = s.Evaluate(req)
+	require.IsType(t, ProtocolStateError{}, err)
+	_, err = c.Finish(eval, []byte("dev"))
+	require.NoError(t, err)
+	_, err = c.Finish(eval, []byte("dev"))
+	require.IsType(t, ProtocolStateError{}, err)
+
+	// A request which is not a valid point aborts the server.
+	s = newTestOPRFServer(t, big.NewInt(12345))
+	_, err = s.Evaluate(OPRFRequestMessage{PwUStar: make([]byte, 64)})
+	require.Error(t, err)
+	_, err = s.Evaluate(req)
+	require.IsType(t, ProtocolStateError{}, err)
+}
+
+func TestOPRFRejectsOtherServerKey(t *testing.T) {
+	published := OPRFPublicValue(big.NewInt(12345))
+	c := newTestOPRFClient(t, []byte("pw"), published)
+	s := newTestOPRFServer(t, big.NewInt(54321))
+
+	req, err := c.Blind()
+	require.NoError(t, err)
+	eval, err := s.Evaluate(req)
+	require.NoError(t, err)
+
+	// The evaluation is well-formed, but not made with the published key.
+	_, err = c.Finish(eval, []byte("dev"))
+	require.IsType(t, InvalidDLEQProofError{}, err)
+	_, err = c.Finish(eval, []byte("dev"))
+	require.IsType(t, ProtocolStateError{}, err)
+
+	// A missing or identity server value is refused up front.
+	_, err = NewOPRFClient(testParams, []byte("pw"), nil)
+	require.Error(t, err)
+	_, err = NewOPRFClient(testParams, []byte("pw"), OPRFPublicValue(big.NewInt(0)))
+	require.Error(t, err)
+}
+
+func TestWireUnexpectedMessage(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteMessage(&buf, MessageTypePairingReveal, PairingRevealMessage{}))
+	var m PairingCommitMessage
+	err := ReadMessage(&buf, MessageTypePairingCommit, &m)
+	require.IsType(t, UnexpectedMessageError{}, err)
+
+	buf.Reset()
+	buf.Write([]byte{0xff, 0xff, 0xff, 0xff, 1})
+	require.Error(t, ReadMessage(&buf, MessageTypePairingCommit, &m))
+}
+
+func TestOPRFRequiresProtocolParams(t *testing.T) {
+	_, err := NewOPRFClient(ProtocolParams{}, []byte("pw"), OPRFPublicValue(big.NewInt(12345)))
+	require.IsType(t, MissingProtocolParamsError{}, err)
+	_, err = NewOPRFServer(ProtocolParams{}, big.NewInt(12345))
+	require.IsType(t, MissingProtocolParamsError{}, err)
+	_, err = NewOPRFKeyManager(ProtocolParams{}, NewInMemoryOPRFSecretStore(), DefaultOPRFThrottle)
+	require.IsType(t, MissingProtocolParamsError{}, err)
+}
@@ -0,0 +1,307 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cloudflare/bn256"
+)
+
+// Pairing authenticates a value sent from a new device (the initiator) to an
+// already paired device (the responder) over an unauthenticated channel, with
+// a short authentication string (SAS) the user compares on both screens. It
+// is steps 1-5 of DeviceKeyGen:
+//
+//	initiator                              responder
+//	Commit{h(payload || R_DU || d)}   ->
+//	                                  <-   Nonce{R_PDU}
+//	Reveal{payload, R_DU, d}          ->
+//	both show SAS(R_DU XOR R_PDU), the user confirms they match
+//
+// The initiator is bound to R_DU before it learns R_PDU, and the responder
+// picks R_PDU before it learns R_DU, so a man in the middle cannot choose
+// either side's SAS: it succeeds with probability 2^-24 per attempt.
+
+const (
+	// sasLength is tau, the length of R_DU, R_PDU and the SAS in bytes.
+	sasLength = 3
+	// pairingCommitNonceLength is lambda, the length of the commitment nonce d.
+	pairingCommitNonceLength = 16
+)
+
+// SASFormat is how a short authentication string is shown to the user.
+type SASFormat int
+
+const (
+	// SASDigits shows six decimal digits, e.g. "042 917".
+	SASDigits SASFormat = iota
+	// SASWords shows one word per byte, e.g. "copper lagoon walrus".
+	SASWords
+)
+
+// formatSAS renders the sasLength bytes of checksum in format.
+func formatSAS(checksum []byte, format SASFormat) (string, error) {
+	if len(checksum) != sasLength {
+		return "", fmt.Errorf("invalid SAS length %d", len(checksum))
+	}
+	switch format {
+	case SASDigits:
+		// 2^24 is not a multiple of 10^6: some strings are 17/16 as likely as
+		// others, which barely changes the odds of an attacker.
+		v := uint32(checksum[0])<<16 | uint32(checksum[1])<<8 | uint32(checksum[2])
+		v %= 1000000
+		return fmt.Sprintf("%03d %03d", v/1000, v%1000), nil
+	case SASWords:
+		words := make([]string, len(checksum))
+		for i, b := range checksum {
+			words[i] = sasWords[b]
+		}
+		return strings.Join(words, " "), nil
+	default:
+		return "", fmt.Errorf("unknown SAS format %d", format)
+	}
+}
+
+// PairingCommitMessage is sent by the initiator to start pairing.
+type PairingCommitMessage struct {
+	_struct    struct{} `codec:",toarray"` //nolint
+	Commitment []byte   `codec:"c"`
+}
+
+// PairingNonceMessage is the responder's random contribution to the SAS.
+type PairingNonceMessage struct {
+	_struct struct{} `codec:",toarray"` //nolint
+	R_PDU   []byte   `codec:"r"`
+}
+
+// PairingRevealMessage opens the initiator commitment.
+type PairingRevealMessage struct {
+	_struct struct{} `codec:",toarray"` //nolint
+	Payload []byte   `codec:"p"`
+	R_DU    []byte   `codec:"r"`
+	D       []byte   `codec:"d"`
+}
+
+type pairingState int
+
+const (
+	pairingStateStart pairingState = iota
+	pairingStateCommitted
+	pairingStateRevealed
+	pairingStateConfirmed
+	pairingStateFailed
+)
+
+func (s pairingState) String() string {
+	switch s {
+	case pairingStateStart:
+		return "start"
+	case pairingStateCommitted:
+		return "committed"
+	case pairingStateRevealed:
+		return "revealed"
+	case pairingStateConfirmed:
+		return "confirmed"
+	default:
+		return "failed"
+	}
+}
+
+// sasConfirmation is the SAS step shared by both sides of a pairing.
+type sasConfirmation struct {
+	state    pairingState
+	checksum []byte
+}
+
+// SAS returns the short authentication string to show the user, once the
+// commitment has been opened.
+func (c *sasConfirmation) SAS(format SASFormat) (string, error) {
+	if c.state != pairingStateRevealed && c.state != pairingStateConfirmed {
+		return "", NewProtocolStateError("SAS", c.state.String())
+	}
+	return formatSAS(c.checksum, format)
+}
+
+// Confirm records whether the user saw the same SAS on both devices. If not,
+// the pairing fails for good.
+func (c *sasConfirmation) Confirm(match bool) error {
+	if c.state != pairingStateRevealed {
+		return NewProtocolStateError("Confirm", c.state.String())
+	}
+	if !match {
+		c.state = pairingStateFailed
+		return NewSASMismatchError()
+	}
+	c.state = pairingStateConfirmed
+	return nil
+}
+
+// Confirmed reports whether the user confirmed the SAS.
+func (c *sasConfirmation) Confirmed() bool {
+	return c.state == pairingStateConfirmed
+}
+
+// PairingInitiator is the new device's side of a pairing.
+type PairingInitiator struct {
+	sasConfirmation
+
+	params  ProtocolParams
+	payload *bn256.G1
+	R_DU    []byte
+	d       []byte
+}
+
+// NewPairingInitiator returns an initiator authenticating payload.
+func NewPairingInitiator(params ProtocolParams, payload *bn256.G1) *PairingInitiator {
+	return &PairingInitiator{params: params, payload: payload}
+}
+
+// Commit commits to the payload and R_DU (steps 1-2).
+func (p *PairingInitiator) Commit() (PairingCommitMessage, error) {
+	if p.state != pairingStateStart {
+		return PairingCommitMessage{}, NewProtocolStateError("Commit", p.state.String())
+	}
+	p.R_DU = generateRandomBytes(sasLength)
+	p.d = generateRandomBytes(pairingCommitNonceLength)
+	p.state = pairingStateCommitted
+	return PairingCommitMessage{Commitment: p.params.ComputeCommitment(p.payload, p.R_DU, p.d)}, nil
+}
+
+// HandleNonce computes the SAS and opens the commitment (steps 3-4).
+func (p *PairingInitiator) HandleNonce(m PairingNonceMessage) (PairingRevealMessage, error) {
+	if p.state != pairingStateCommitted {
+		return PairingRevealMessage{}, NewProtocolStateError("HandleNonce", p.state.String())
+	}
+	checksum, err := XORBytes(p.R_DU, m.R_PDU)
+	if err != nil {
+		p.state = pairingStateFailed
+		return PairingRevealMessage{}, err
+	}
+	p.checksum = checksum
+	p.state = pairingStateRevealed
+	return PairingRevealMessage{Payload: p.payload.Marshal(), R_DU: p.R_DU, D: p.d}, nil
+}
+
+// PairingResponder is the paired device's side of a pairing.
+type PairingResponder struct {
+	sasConfirmation
+
+	params     ProtocolParams
+	commitment []byte
+	R_PDU      []byte
+	payload    *bn256.G1
+}
+
+// NewPairingResponder returns a responder waiting for a commitment.
+func NewPairingResponder(params ProtocolParams) *PairingResponder {
+	return &PairingResponder{params: params}
+}
+
+// HandleCommit records the initiator commitment and picks R_PDU (step 3).
+func (p *PairingResponder) HandleCommit(m PairingCommitMessage) (PairingNonceMessage, error) {
+	if p.state != pairingStateStart {
+		return PairingNonceMessage{}, NewProtocolStateError("HandleCommit", p.state.String())
+	}
+	p.commitment = m.Commitment
+	p.R_PDU = generateRandomBytes(sasLength)
+	p.state = pairingStateCommitted
+	return PairingNonceMessage{R_PDU: p.R_PDU}, nil
+}
+
+// HandleReveal checks the opening of the commitment and computes the SAS
+// (step 5).
+func (p *PairingResponder) HandleReveal(m PairingRevealMessage) error {
+	if p.state != pairingStateCommitted {
+		return NewProtocolStateError("HandleReveal", p.state.String())
+	}
+	p.state = pairingStateFailed
+	payload, err := unmarshalG1(m.Payload)
+	if err != nil {
+		return err
+	}
+	if len(m.R_DU) != sasLength || len(m.D) != pairingCommitNonceLength {
+		return fmt.Errorf("invalid reveal lengths")
+	}
+	if !bytes.Equal(p.commitment, p.params.ComputeCommitment(payload, m.R_DU, m.D)) {
+		return NewCommitmentMismatchError()
+	}
+	checksum, err := XORBytes(m.R_DU, p.R_PDU)
+	if err != nil {
+		return err
+	}
+	p.payload = payload
+	p.checksum = checksum
+	p.state = pairingStateRevealed
+	return nil
+}
+
+// Payload returns the initiator's payload once the user confirmed the SAS.
+func (p *PairingResponder) Payload() (*bn256.G1, error) {
+	if p.state != pairingStateConfirmed {
+		return nil, NewProtocolStateError("Payload", p.state.String())
+	}
+	return p.payload, nil
+}
+
+// RunPairingInitiator runs the initiator side of a pairing over rw. confirm
+// is shown the SAS and reports whether the user saw the same one on the
+// responder.
+func RunPairingInitiator(rw io.ReadWriter, p *PairingInitiator, format SASFormat, confirm func(sas string) bool) error {
+	commit, err := p.Commit()
+	if err != nil {
+		return err
+	}
+	if err := WriteMessage(rw, MessageTypePairingCommit, commit); err != nil {
+		return err
+	}
+	var nonce PairingNonceMessage
+	if err := ReadMessage(rw, MessageTypePairingNonce, &nonce); err != nil {
+		return err
+	}
+	reveal, err := p.HandleNonce(nonce)
+	if err != nil {
+		return err
+	}
+	if err := WriteMessage(rw, MessageTypePairingReveal, reveal); err != nil {
+		return err
+	}
+	return confirmSAS(&p.sasConfirmation, format, confirm)
+}
+
+// RunPairingResponder runs the responder side of a pairing over rw and
+// returns the authenticated payload. confirm is shown the SAS and reports
+// whether the user saw the same one on the initiator.
+func RunPairingResponder(rw io.ReadWriter, p *PairingResponder, format SASFormat, confirm func(sas string) bool) (*bn256.G1, error) {
+	var commit PairingCommitMessage
+	if err := ReadMessage(rw, MessageTypePairingCommit, &commit); err != nil {
+		return nil, err
+	}
+	nonce, err := p.HandleCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteMessage(rw, MessageTypePairingNonce, nonce); err != nil {
+		return nil, err
+	}
+	var reveal PairingRevealMessage
+	if err := ReadMessage(rw, MessageTypePairingReveal, &reveal); err != nil {
+		return nil, err
+	}
+	if err := p.HandleReveal(reveal); err != nil {
+		return nil, err
+	}
+	if err := confirmSAS(&p.sasConfirmation, format, confirm); err != nil {
+		return nil, err
+	}
+	return p.Payload()
+}
+
+func confirmSAS(c *sasConfirmation, format SASFormat, confirm func(sas string) bool) error {
+	sas, err := c.SAS(format)
+	if err != nil {
+		return err
+	}
+	return c.Confirm(confirm(sas))
+}
@@ -0,0 +1,131 @@
+package merkle
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"testing"
+
+	"github.com/cloudflare/bn256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSAS(t *testing.T) {
+	for _, tc := range []struct {
+		checksum []byte
+		format   SASFormat
+		want     string
+	}{
+		{[]byte{0, 0, 0}, SASDigits, "000 000"},
+		{[]byte{0x0f, 0x42, 0x3f}, SASDigits, "999 999"},
+		{[]byte{0x0f, 0x42, 0x40}, SASDigits, "000 000"},
+		{[]byte{0xff, 0xff, 0xff}, SASDigits, "777 215"},
+		{[]byte{0, 1, 255}, SASWords, "acid acorn zipper"},
+	} {
+		got, err := formatSAS(tc.checksum, tc.format)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, got)
+	}
+	_, err := formatSAS([]byte{1, 2}, SASDigits)
+	require.Error(t, err)
+	_, err = formatSAS([]byte{1, 2, 3}, SASFormat(7))
+	require.Error(t, err)
+
+	seen := make(map[string]bool)
+	for _, w := range sasWords {
+		require.NotEmpty(t, w)
+		require.False(t, seen[w], w)
+		seen[w] = true
+	}
+}
+
+func TestPairingOverPipe(t *testing.T) {
+	payload := new(bn256.G1).ScalarBaseMult(big.NewInt(42))
+	initiatorConn, responderConn := net.Pipe()
+
+	var responderSAS string
+	type result struct {
+		payload *bn256.G1
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer responderConn.Close()
+		p, err := RunPairingResponder(responderConn, NewPairingResponder(testParams), SASDigits, func(sas string) bool {
+			responderSAS = sas
+			return true
+		})
+		done <- result{p, err}
+	}()
+
+	var initiatorSAS string
+	err := RunPairingInitiator(initiatorConn, NewPairingInitiator(testParams, payload), SASDigits, func(sas string) bool {
+		initiatorSAS = sas
+		return true
+	})
+	require.NoError(t, err)
+	res := <-done
+	require.NoError(t, res.err)
+	require.Equal(t, payload.Marshal(), res.payload.Marshal())
+	require.Len(t, initiatorSAS, 7)
+	require.Equal(t, initiatorSAS, responderSAS)
+}
+
+func TestPairingOrdering(t *testing.T) {
+	payload := new(bn256.G1).ScalarBaseMult(big.NewInt(7))
+	i := NewPairingInitiator(testParams, payload)
+	r := NewPairingResponder(testParams)
+
+	// Nothing can be revealed, shown or confirmed before the commitment.
+	_, err := i.HandleNonce(PairingNonceMessage{R_PDU: []byte{1, 2, 3}})
+	require.IsType(t, ProtocolStateError{}, err)
+	_, err = i.SAS(SASDigits)
+	require.IsType(t, ProtocolStateError{}, err)
+	require.IsType(t, ProtocolStateError{}, i.Confirm(true))
+	require.IsType(t, ProtocolStateError{}, r.HandleReveal(PairingRevealMessage{}))
+	_, err = r.Payload()
+	require.IsType(t, ProtocolStateError{}, err)
+
+	commit, err := i.Commit()
+	require.NoError(t, err)
+	_, err = i.Commit()
+	require.IsType(t, ProtocolStateError{}, err)
+	nonce, err := r.HandleCommit(commit)
+	require.NoError(t, err)
+	_, err = r.HandleCommit(commit)
+	require.IsType(t, ProtocolStateError{}, err)
+	reveal, err := i.HandleNonce(nonce)
+	require.NoError(t, err)
+
+	// A reveal which does not open the commitment aborts the responder.
+	tampered := reveal
+	tampered.R_DU = []byte{0, 0, 0}
+	if bytes.Equal(tampered.R_DU, reveal.R_DU) {
+		tampered.R_DU = []byte{1, 1, 1}
+	}
+	require.IsType(t, CommitmentMismatchError{}, r.HandleReveal(tampered))
+	require.IsType(t, ProtocolStateError{}, r.HandleReveal(reveal))
+
+	r = NewPairingResponder(testParams)
+	_, err = r.HandleCommit(commit)
+	require.NoError(t, err)
+	require.Error(t, r.HandleReveal(PairingRevealMessage{Payload: make([]byte, 64), R_DU: reveal.R_DU, D: reveal.D}))
+
+	// A mismatching SAS fails the pairing for good.
+	_, err = i.SAS(SASWords)
+	require.NoError(t, err)
+	require.IsType(t, SASMismatchError{}, i.Confirm(false))
+	require.False(t, i.Confirmed())
+	require.IsType(t, ProtocolStateError{}, i.Confirm(true))
+}
+
+func TestPairingUnexpectedMessage(t *testing.T) {
+	initiatorConn, responderConn := net.Pipe()
+	go func() {
+		defer initiatorConn.Close()
+		// Reveal before commit.
+		_ = WriteMessage(initiatorConn, MessageTypePairingReveal, PairingRevealMessage{})
+	}()
+	_, err := RunPairingResponder(responderConn, NewPairingResponder(testParams), SASDigits, func(string) bool { return true })
+	require.IsType(t, UnexpectedMessageError{}, err)
+}
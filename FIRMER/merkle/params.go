@@ -0,0 +1,109 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cloudflare/bn256"
+)
+
+// ProtocolParams are the parameters a FIRMER deployment shares between all
+// its clients and servers. Every hash of the protocol is domain separated by
+// the deployment identifier and by the protocol step it belongs to, so that
+// two deployments, or two steps of one deployment, never hash to the same
+// value.
+type ProtocolParams struct {
+	deploymentID []byte
+
+	passwordTag    []byte
+	deviceIDTag    []byte
+	commitmentTag  []byte
+	keyExchangeTag []byte
+	sessionTag     []byte
+
+	kdfArgon2SaltTag []byte
+	kdfHKDFSalt      []byte
+	kdfHKDFInfo      []byte
+	blsSignatureTag  []byte
+	blsPossessionTag []byte
+	dleqTag          []byte
+}
+
+// protocolParamsVersion starts every domain tag. Changing how tags are built
+// requires a new version.
+const protocolParamsVersion = "FIRMER-params-v1"
+
+// NewProtocolParams returns the parameters of the deployment deploymentID.
+func NewProtocolParams(deploymentID string) (ProtocolParams, error) {
+	if len(deploymentID) == 0 || len(deploymentID) > 0xffff {
+		return ProtocolParams{}, fmt.Errorf("invalid deployment identifier length %d", len(deploymentID))
+	}
+	params := ProtocolParams{deploymentID: []byte(deploymentID)}
+	params.passwordTag = params.tag("password")
+	params.deviceIDTag = params.tag("device-id")
+	params.commitmentTag = params.tag("commitment")
+	params.keyExchangeTag = params.tag("SesKeyGen")
+	params.sessionTag = params.tag("session")
+	params.kdfArgon2SaltTag = params.tag("KDF-v2-argon2id-salt")
+	params.kdfHKDFSalt = params.tag("KDF-v2-hkdf-sha256")
+	params.kdfHKDFInfo = params.tag("KDF-v2-long-term-secret")
+	params.blsSignatureTag = params.tag("BLS-SIG-BN256G1")
+	params.blsPossessionTag = params.tag("BLS-POP-BN256G1")
+	params.dleqTag = params.tag("DLEQ-BN256G1")
+	return params, nil
+}
+
+// IsZero reports whether params is the zero value, which belongs to no
+// deployment.
+func (params ProtocolParams) IsZero() bool {
+	return len(params.deploymentID) == 0
+}
+
+// check returns a MissingProtocolParamsError for step if params is the zero
+// value.
+func (params ProtocolParams) check(step string) error {
+	if params.IsZero() {
+		return NewMissingProtocolParamsError(step)
+	}
+	return nil
+}
+
+// DeploymentID returns the deployment identifier.
+func (params ProtocolParams) DeploymentID() string {
+	return string(params.deploymentID)
+}
+
+// tag returns the domain tag of step: the version, the deployment identifier
+// and step, each length-prefixed.
+func (params ProtocolParams) tag(step string) []byte {
+	var t []byte
+	for _, part := range [][]byte{[]byte(protocolParamsVersion), params.deploymentID, []byte(step)} {
+		var l [2]byte
+		binary.BigEndian.PutUint16(l[:], uint16(len(part)))
+		t = append(t, l[:]...)
+		t = append(t, part...)
+	}
+	return t
+}
+
+// HashPassword returns H(pw_U) in G1.
+func (params ProtocolParams) HashPassword(pw []byte) *bn256.G1 {
+	return bn256.HashG1(pw, params.passwordTag)
+}
+
+// HashDeviceID returns Q_DU = H(ID_DU) in G1.
+func (params ProtocolParams) HashDeviceID(ID []byte) *bn256.G1 {
+	return bn256.HashG1(ID, params.deviceIDTag)
+}
+
+// ComputeCommitment computes the commitment h(pwUStar || R_DU || d) of a
+// pairing.
+func (params ProtocolParams) ComputeCommitment(pwUStar *bn256.G1, R_DU, d []byte) []byte {
+	h := sha256.New()
+	h.Write(params.commitmentTag)
+	h.Write(pwUStar.Marshal())
+	h.Write(R_DU)
+	h.Write(d)
+	return h.Sum(nil)
+}
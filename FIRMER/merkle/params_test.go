@@ -0,0 +1,85 @@
+package merkle
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/bn256"
+	"github.com/stretchr/testify/require"
+)
+
+var testParams = mustProtocolParams("test deployment")
+
+func mustProtocolParams(deploymentID string) ProtocolParams {
+	params, err := NewProtocolParams(deploymentID)
+	if err != nil {
+		panic(err)
+	}
+	return params
+}
+
+func TestProtocolParamsSeparation(t *testing.T) {
+	_, err := NewProtocolParams("")
+	require.Error(t, err)
+
+	other := mustProtocolParams("other deployment")
+	require.Equal(t, "test deployment", testParams.DeploymentID())
+
+	x := []byte("same input")
+	d := make([]byte, pairingCommitNonceLength)
+	hashes := map[string]string{}
+	for name, h := range map[string][]byte{
+		"password":         testParams.HashPassword(x).Marshal(),
+		"device id":        testParams.HashDeviceID(x).Marshal(),
+		"other password":   other.HashPassword(x).Marshal(),
+		"other device id":  other.HashDeviceID(x).Marshal(),
+		"commitment":       testParams.ComputeCommitment(testParams.HashPassword(x), x, d),
+		"other commitment": other.ComputeCommitment(testParams.HashPassword(x), x, d),
+	} {
+		prev, ok := hashes[string(h)]
+		require.False(t, ok, "%s collides with %s", name, prev)
+		hashes[string(h)] = name
+	}
+
+	// A deployment identifier cannot be shifted into the step name.
+	require.NotEqual(t, mustProtocolParams("a").tag("bc"), mustProtocolParams("ab").tag("c"))
+
+	// Keys derived under one deployment do not match another.
+	k_U := big.NewInt(31)
+	_, PK1, _, Q1, err := DeviceKeyGen(testParams, x, []byte("pw"), k_U)
+	require.NoError(t, err)
+	_, PK2, _, Q2, err := DeviceKeyGen(other, x, []byte("pw"), k_U)
+	require.NoError(t, err)
+	require.NotEqual(t, PK1.Marshal(), PK2.Marshal())
+	require.NotEqual(t, Q1.Marshal(), Q2.Marshal())
+}
+
+func TestProtocolParamsSeparateKDFAndSignatures(t *testing.T) {
+	other := mustProtocolParams("other deployment")
+	pwStar := testParams.HashPassword([]byte("pw"))
+
+	// The same OPRF output and password derive different keys.
+	k1, err := DeriveLongTermKey(testParams, KeyDerivationV2, pwStar, []byte("pw"))
+	require.NoError(t, err)
+	k2, err := DeriveLongTermKey(other, KeyDerivationV2, pwStar, []byte("pw"))
+	require.NoError(t, err)
+	require.NotEqual(t, k1.ToBytes(), k2.ToBytes())
+
+	// Signatures and proofs of possession only verify in their deployment.
+	msg := []byte("tree head")
+	require.True(t, k1.GetPublicKey().Verify(testParams, msg, k1.Sign(testParams, msg)))
+	require.False(t, k1.GetPublicKey().Verify(other, msg, k1.Sign(testParams, msg)))
+	require.False(t, k1.GetPublicKey().VerifyPossession(other, k1.ProvePossession(testParams)))
+	sth := NewSignedTreeHead(testParams, k1, 1, TransparencyDigest{1}, time.Now())
+	require.Error(t, sth.Verify(other, k1.GetPublicKey()))
+
+	// So do DLEQ proofs.
+	k_U := big.NewInt(31)
+	K := OPRFPublicValue(k_U)
+	Q := new(bn256.G1).ScalarMult(pwStar, k_U)
+	proof, err := ProveDLEQ(testParams, k_U, K, pwStar, Q)
+	require.NoError(t, err)
+	require.NoError(t, VerifyDLEQ(testParams, K, pwStar, Q, proof))
+	require.IsType(t, InvalidDLEQProofError{}, VerifyDLEQ(other, K, pwStar, Q, proof))
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"fmt"
-	"math/big"
 
 	"FIRMER/logger"
 	"FIRMER/vrf"
@@ -12,12 +11,37 @@ import (
 
 type MerkleProofVerifier struct {
 	cfg Config
+
+	// pinnedVRFKey, if not nil, is the only VRF public key accepted in proofs.
+	pinnedVRFKey *vrf.PublicKey
 }
 
 func NewMerkleProofVerifier(c Config) MerkleProofVerifier {
 	return MerkleProofVerifier{cfg: c}
 }
 
+// PinVRFPublicKey makes m reject the proofs of roots with another VRF public
+// key than pk, as encoded by RootMetadata.VRFPublicKey. A client pins the new
+// key after verifying a rotation.
+func (m *MerkleProofVerifier) PinVRFPublicKey(pk []byte) error {
+	pub, err := m.cfg.ECVRF.Params().UnmarshalPublicKey(pk)
+	if err != nil {
+		return err
+	}
+	m.pinnedVRFKey = pub
+	return nil
+}
+
+// vrfPublicKey returns the VRF public key of the root of proof, checking it
+// against the pinned key.
+func (m *MerkleProofVerifier) vrfPublicKey(proof *MerkleInclusionProof) (*vrf.PublicKey, error) {
+	pub := proof.RootMetadataNoHash.vrfPublicKey()
+	if m.pinnedVRFKey != nil && (pub.X.Cmp(m.pinnedVRFKey.X) != 0 || pub.Y.Cmp(m.pinnedVRFKey.Y) != 0) {
+		return nil, NewProofVerificationFailedError(fmt.Errorf("the VRF public key is not the pinned one"))
+	}
+	return pub, nil
+}
+
 func (m *MerkleProofVerifier) VerifyInclusionProof(ctx logger.ContextInterface, kvp KeyValuePair, proof *MerkleInclusionProof, expRootHash TransparencyDigest) (err error) {
 
 	if kvp.Value == nil {
@@ -31,6 +55,92 @@ func (m *MerkleProofVerifier) VerifyExclusionProof(ctx logger.ContextInterface,
 	return m.verifyInclusionOrExclusionProof(ctx, KeyValuePair{Key: k}, proof, expRootHash)
 }
 
+// ProofToVerify is an inclusion proof of KVP, or an exclusion proof of its
+// key if KVP.Value is nil, against ExpRootHash.
+type ProofToVerify struct {
+	KVP         KeyValuePair
+	Proof       *MerkleInclusionProof
+	ExpRootHash TransparencyDigest
+}
+
+// VerifyProofs verifies all the proofs, checking their VRF proofs together
+// with ECVRF.BatchVerify. Only the proofs with VRFBatchPoints can be checked
+// together, the others are checked one by one. It returns the error of each
+// proof, nil if it is valid.
+func (m *MerkleProofVerifier) VerifyProofs(ctx logger.ContextInterface, proofs []ProofToVerify) []error {
+	errs := make([]error, len(proofs))
+	hiddenKeys := make([][]byte, len(proofs))
+	var batch []int
+	var pubs []*vrf.PublicKey
+	var pis, points, alphas [][]byte
+	for i, p := range proofs {
+		if p.Proof == nil {
+			errs[i] = NewProofVerificationFailedError(fmt.Errorf("nil proof"))
+			continue
+		}
+		pub, err := m.vrfPublicKey(p.Proof)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if isFakeVRFProof(p.Proof.VRFProof) {
+			continue
+		}
+		batch = append(batch, i)
+		pubs = append(pubs, pub)
+		pis = append(pis, p.Proof.VRFProof)
+		points = append(points, p.Proof.VRFBatchPoints)
+		alphas = append(alphas, p.KVP.Key)
+	}
+
+	if betas, err := m.cfg.ECVRF.BatchVerify(pubs, pis, points, alphas); err == nil {
+		for j, i := range batch {
+			hiddenKeys[i] = betas[j]
+		}
+	} else {
+		// Verify the VRF proofs one by one to report each invalid one.
+		for _, i := range batch {
+			hiddenKeys[i], errs[i] = m.verifyHiddenKey(proofs[i].KVP.Key, proofs[i].Proof)
+		}
+	}
+
+	for i, p := range proofs {
+		if errs[i] == nil {
+			errs[i] = m.verifyWithHiddenKey(ctx, p.KVP, p.Proof, p.ExpRootHash, hiddenKeys[i])
+		}
+	}
+	return errs
+}
+
+func isFakeVRFProof(pi []byte) bool {
+	return bytes.Equal(pi, []byte("fake"))
+}
+
+// verifyHiddenKey verifies the VRF proof of k and returns its hidden key.
+func (m *MerkleProofVerifier) verifyHiddenKey(k Key, proof *MerkleInclusionProof) (HiddenKey, error) {
+	pub, err := m.vrfPublicKey(proof)
+	if err != nil {
+		return nil, err
+	}
+	if isFakeVRFProof(proof.VRFProof) {
+		return nil, nil
+	}
+	if proof.VRFBatchPoints != nil {
+		// Points that are not those of the proof are rejected, as they are
+		// by a batch.
+		betas, err := m.cfg.ECVRF.BatchVerify([]*vrf.PublicKey{pub}, [][]byte{proof.VRFProof}, [][]byte{proof.VRFBatchPoints}, [][]byte{k})
+		if err != nil {
+			return nil, NewProofVerificationFailedError(err)
+		}
+		return betas[0], nil
+	}
+	hiddenKey, err := m.cfg.ECVRF.Verify(pub, proof.VRFProof, k)
+	if err != nil {
+		return nil, NewProofVerificationFailedError(err)
+	}
+	return hiddenKey, nil
+}
+
 // if kvp.Value == nil, this functions checks that kvp.Key is not included in the tree. Otherwise, it checks that kvp is included in the tree.
 func (m *MerkleProofVerifier) verifyInclusionOrExclusionProof(ctx logger.ContextInterface, kvp KeyValuePair,
 	proof *MerkleInclusionProof, expRootHash TransparencyDigest) (err error) {
@@ -39,22 +149,17 @@ func (m *MerkleProofVerifier) verifyInclusionOrExclusionProof(ctx logger.Context
 	}
 
 	// First, verify the HiddenKeyValue pair.
-	x := new(big.Int)
-	x.SetBytes(proof.RootMetadataNoHash.VRFPublicKeyX)
-	y := new(big.Int)
-	y.SetBytes(proof.RootMetadataNoHash.VRFPublicKeyY)
-	vrfPublic := vrf.PublicKey{
-		X: x,
-		Y: y,
-	}
-	var hiddenKey []byte
-	if !bytes.Equal(proof.VRFProof, []byte("fake")) {
-		hiddenKey, err = m.cfg.ECVRF.Verify(&vrfPublic, proof.VRFProof, kvp.Key)
-		if err != nil {
-			return NewProofVerificationFailedError(err)
-		}
+	hiddenKey, err := m.verifyHiddenKey(kvp.Key, proof)
+	if err != nil {
+		return err
 	}
+	return m.verifyWithHiddenKey(ctx, kvp, proof, expRootHash, hiddenKey)
+}
 
+// verifyWithHiddenKey checks the rest of the proof, given the hidden key of
+// kvp.Key.
+func (m *MerkleProofVerifier) verifyWithHiddenKey(ctx logger.ContextInterface, kvp KeyValuePair,
+	proof *MerkleInclusionProof, expRootHash TransparencyDigest, hiddenKey []byte) (err error) {
 	// First verify kvp hashes to root metadata (need to do first to compute exp ztt root hash)
 	var kvpHash []byte
 	// Hash the key value pair if necessary for inclusion proof
@@ -210,6 +315,9 @@ func verifyExtensionProof(proof *MerkleExtensionProof, initialSeqno Seqno, final
 		}
 		return nil
 	}
+	if initialSeqno < 1 || initialSeqno > finalSeqno {
+		return fmt.Errorf("invalid seqno range [%d, %d]", initialSeqno, finalSeqno)
+	}
 
 	hashes := proof.HistoryTreeNodeHashes
 
@@ -222,6 +330,9 @@ func verifyExtensionProof(proof *MerkleExtensionProof, initialSeqno Seqno, final
 		hashes = append([][]byte{initialRootHash}, hashes...)
 		idxs = append([]int{int(initialSeqno)*2 - 2}, idxs...)
 	}
+	if len(hashes) != len(idxs) {
+		return fmt.Errorf("extension proof has %d hashes, want %d", len(hashes), len(idxs))
+	}
 
 	// First, ensure that the nodes hash to finalRootHash. We may need to use initialRootHash.
 	calc := hashHistoryTreeUpward(idxs, hashes, finalSeqno, nil)
@@ -64,3 +64,99 @@ func genproof(ctx logger.ContextInterface, tr Transaction, t *testing.T, l *LBBM
 	}
 	return &MerkleExtensionProof{HistoryTreeNodeHashes: prf}
 }
+
+func TestVerifyProofs(t *testing.T) {
+	for _, batchable := range []bool{false, true} {
+		ctx := NewLoggerContextTodoForTesting(t)
+		cfg, err := newConfigForTestWithVRF(SHA512_256Encoder{}, 1, 1)
+		require.NoError(t, err)
+		cfg.BatchableVRFProofs = batchable
+		tree, err := NewTree(cfg, 2, NewInMemoryStorageEngine(cfg), RootVersionV1)
+		require.NoError(t, err)
+		verifier := NewMerkleProofVerifier(cfg)
+
+		kvps := GenerateInitS(1, 10)
+		s, root, err := tree.Build(ctx, nil, kvps, nil, false)
+		require.NoError(t, err)
+		var proofs []ProofToVerify
+		for _, kvp := range kvps {
+			ok, _, proof, err := tree.QueryKey(ctx, nil, s, kvp.Key)
+			require.NoError(t, err)
+			require.True(t, ok)
+			// The VRF proof is the one of Prove either way.
+			require.Len(t, proof.VRFProof, 81)
+			require.Equal(t, batchable, proof.VRFBatchPoints != nil)
+			proofs = append(proofs, ProofToVerify{KVP: kvp, Proof: &proof, ExpRootHash: root})
+		}
+		missing := GenerateInitS(20, 21)[0].Key
+		ok, _, proof, err := tree.QueryKey(ctx, nil, s, missing)
+		require.NoError(t, err)
+		require.False(t, ok)
+		proofs = append(proofs, ProofToVerify{KVP: KeyValuePair{Key: missing}, Proof: &proof, ExpRootHash: root})
+
+		for _, err := range verifier.VerifyProofs(ctx, proofs) {
+			require.NoError(t, err)
+		}
+
+		// An invalid VRF proof and a wrong value are reported for their proof only.
+		badVRF := *proofs[2].Proof
+		badVRF.VRFProof = append([]byte{}, badVRF.VRFProof...)
+		badVRF.VRFProof[len(badVRF.VRFProof)-1] ^= 1
+		proofs[2].Proof = &badVRF
+		proofs[5].KVP.Value = "other"
+		bad := map[int]bool{2: true, 5: true}
+		if batchable {
+			// So are points which are not those of the proof.
+			badPoints := *proofs[7].Proof
+			badPoints.VRFBatchPoints = append([]byte{}, badPoints.VRFBatchPoints...)
+			badPoints.VRFBatchPoints[len(badPoints.VRFBatchPoints)-1] ^= 1
+			proofs[7].Proof = &badPoints
+			bad[7] = true
+			require.IsType(t, ProofVerificationFailedError{}, verifier.VerifyInclusionProof(ctx, proofs[7].KVP, proofs[7].Proof, root))
+		}
+		for i, err := range verifier.VerifyProofs(ctx, proofs) {
+			if bad[i] {
+				require.IsType(t, ProofVerificationFailedError{}, err)
+			} else {
+				require.NoError(t, err)
+			}
+		}
+	}
+}
+
+func TestPinVRFPublicKey(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	cfg, err := newConfigForTestWithVRF(SHA512_256Encoder{}, 1, 1)
+	require.NoError(t, err)
+	tree, err := NewTree(cfg, 2, NewInMemoryStorageEngine(cfg), RootVersionV1)
+	require.NoError(t, err)
+
+	kvps := GenerateInitS(1, 10)
+	s, root, err := tree.Build(ctx, nil, kvps, nil, false)
+	require.NoError(t, err)
+	ok, _, proof, err := tree.QueryKey(ctx, nil, s, kvps[0].Key)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, rootMd, _, err := tree.GetLatestRoot(ctx, nil)
+	require.NoError(t, err)
+	pk, err := rootMd.VRFPublicKey(cfg.ECVRF)
+	require.NoError(t, err)
+	verifier := NewMerkleProofVerifier(cfg)
+	require.NoError(t, verifier.PinVRFPublicKey(pk))
+	require.NoError(t, verifier.VerifyInclusionProof(ctx, kvps[0], &proof, root))
+
+	// After a rotation, the proofs verify again once the new key is pinned.
+	s, root, err = tree.Rotate(ctx, nil, nil)
+	require.NoError(t, err)
+	ok, _, proof, err = tree.QueryKey(ctx, nil, s, kvps[0].Key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.IsType(t, ProofVerificationFailedError{}, verifier.VerifyInclusionProof(ctx, kvps[0], &proof, root))
+	pk, err = proof.RootMetadataNoHash.VRFPublicKey(cfg.ECVRF)
+	require.NoError(t, err)
+	require.NoError(t, verifier.PinVRFPublicKey(pk))
+	require.NoError(t, verifier.VerifyInclusionProof(ctx, kvps[0], &proof, root))
+
+	require.Error(t, verifier.PinVRFPublicKey(pk[:len(pk)-1]))
+}
@@ -0,0 +1,38 @@
+package merkle
+
+// sasWords maps each byte of a short authentication string to a word. The
+// words are distinct, common and easy to read out loud.
+var sasWords = [256]string{
+	"acid", "acorn", "actor", "adobe", "agent", "album", "alley", "amber",
+	"anchor", "angle", "ankle", "apple", "apron", "arch", "arena", "arrow",
+	"atlas", "attic", "autumn", "bacon", "badge", "bagel", "baker", "bamboo",
+	"banjo", "barrel", "basil", "basket", "beach", "beacon", "bean", "bear",
+	"beetle", "bell", "bench", "berry", "bison", "blade", "blanket", "bloom",
+	"board", "bonsai", "boot", "bottle", "bridge", "broom", "bubble", "bucket",
+	"bugle", "butter", "button", "cabin", "cactus", "camel", "camera", "candle",
+	"canoe", "canyon", "carbon", "carpet", "carrot", "castle", "cedar", "cello",
+	"chalk", "cherry", "chess", "cider", "circus", "clover", "cobra", "cocoa",
+	"comet", "compass", "copper", "coral", "cotton", "cougar", "crayon", "cricket",
+	"cushion", "dagger", "daisy", "dolphin", "domino", "donkey", "dragon", "drum",
+	"eagle", "echo", "eclipse", "elbow", "ember", "engine", "falcon", "feather",
+	"fiddle", "flame", "flute", "forest", "fossil", "fox", "galaxy", "garden",
+	"garlic", "geyser", "ginger", "giraffe", "glacier", "globe", "goblet", "gorilla",
+	"granite", "grape", "guitar", "hammer", "harbor", "harp", "hazel", "helmet",
+	"heron", "hippo", "honey", "husky", "igloo", "iguana", "island", "ivory",
+	"jacket", "jaguar", "jasmine", "jelly", "jigsaw", "jungle", "kayak", "kettle",
+	"kiwi", "koala", "ladder", "lagoon", "lantern", "lava", "lemon", "leopard",
+	"lettuce", "lily", "lizard", "lobster", "locket", "lotus", "magnet", "mango",
+	"maple", "marble", "meadow", "melon", "meteor", "mirror", "mitten", "monkey",
+	"mosaic", "muffin", "needle", "nest", "nickel", "noodle", "nutmeg", "oasis",
+	"ocean", "olive", "onion", "orange", "orbit", "orchid", "otter", "owl",
+	"oyster", "paddle", "panda", "papaya", "parrot", "peach", "peanut", "pebble",
+	"pelican", "pepper", "piano", "pickle", "pillow", "pine", "planet", "plum",
+	"pocket", "pony", "poppy", "potato", "pumpkin", "puzzle", "quartz", "quill",
+	"rabbit", "radio", "raven", "rhino", "ribbon", "river", "robot", "rocket",
+	"saddle", "salmon", "sandal", "satin", "saturn", "scarf", "seal", "shovel",
+	"silver", "skate", "sled", "snail", "spider", "spoon", "squid", "stable",
+	"statue", "sugar", "summit", "sunset", "swan", "tablet", "tango", "teapot",
+	"tiger", "tomato", "torch", "tulip", "tunnel", "turtle", "valley", "velvet",
+	"violin", "volcano", "wagon", "walnut", "walrus", "wasp", "whale", "whistle",
+	"willow", "window", "wizard", "wolf", "yacht", "yogurt", "zebra", "zipper",
+}
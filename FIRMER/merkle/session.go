@@ -0,0 +1,202 @@
+package merkle
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// A Session encrypts messages between two devices with the session key of
+// SesKeyGen. Each direction has its own chain key, which is ratcheted forward
+// with every message: the message key of message n is derived from chain key
+// n, which is then replaced by chain key n+1 and forgotten. Compromising a
+// session later does not reveal the keys of the messages already exchanged.
+//
+// A sealed message is its 8 byte big endian sequence number followed by the
+// AES-256-GCM encryption of the plaintext under its message key. Messages may
+// arrive out of order, as long as they are no more than sessionWindow
+// messages apart; the keys of skipped messages are kept until they arrive or
+// fall out of the window. Each message is accepted at most once.
+
+const (
+	// sessionWindow is how far apart received messages can arrive.
+	sessionWindow      = 64
+	sessionSeqnoLength = 8
+)
+
+var (
+	sessionInitiatorLabel = []byte("FIRMER session initiator to responder")
+	sessionResponderLabel = []byte("FIRMER session responder to initiator")
+)
+
+// Session is one side of an encrypted conversation.
+type Session struct {
+	sync.Mutex
+
+	// salt is the session tag of the deployment, used to derive the chain
+	// keys.
+	salt                 []byte
+	sendLabel, recvLabel []byte
+
+	sendChain []byte
+	sendNext  uint64
+
+	recvChain []byte
+	recvNext  uint64
+	// skipped holds the message keys of messages below recvNext which have
+	// not arrived yet.
+	skipped map[uint64][]byte
+}
+
+// NewSession returns a session from the session key of SesKeyGen. initiator
+// tells which side of SesKeyGen this device was, so that both sides agree on
+// which chain each one sends on.
+func NewSession(params ProtocolParams, sessionKey []byte, initiator bool) (*Session, error) {
+	if len(sessionKey) != sha256.Size {
+		return nil, fmt.Errorf("invalid session key length %d", len(sessionKey))
+	}
+	s := &Session{
+		salt:      params.sessionTag,
+		sendLabel: sessionInitiatorLabel,
+		recvLabel: sessionResponderLabel,
+		skipped:   make(map[uint64][]byte),
+	}
+	if !initiator {
+		s.sendLabel, s.recvLabel = s.recvLabel, s.sendLabel
+	}
+	var err error
+	if s.sendChain, err = deriveChainKey(sessionKey, s.salt, s.sendLabel); err != nil {
+		return nil, err
+	}
+	if s.recvChain, err = deriveChainKey(sessionKey, s.salt, s.recvLabel); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func deriveChainKey(sessionKey, salt, label []byte) ([]byte, error) {
+	ck := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sessionKey, salt, label), ck); err != nil {
+		return nil, fmt.Errorf("HKDF failed: %v", err)
+	}
+	return ck, nil
+}
+
+// ratchetChainKey returns the message key for chain key ck, and the next
+// chain key.
+func ratchetChainKey(ck []byte) (messageKey, next []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write([]byte{1})
+	messageKey = mac.Sum(nil)
+	mac.Reset()
+	mac.Write([]byte{2})
+	return messageKey, mac.Sum(nil)
+}
+
+// sessionAEAD returns the AEAD for one message key. Each key seals a single
+// message, so the nonce can be fixed.
+func sessionAEAD(messageKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(messageKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sessionAD binds a message to its direction, sequence number and the
+// caller's associated data.
+func sessionAD(label, header, ad []byte) []byte {
+	out := append(append([]byte{}, label...), header...)
+	return append(out, ad...)
+}
+
+// Seal encrypts plaintext, authenticating ad along with it, and returns the
+// message to send to the peer.
+func (s *Session) Seal(plaintext, ad []byte) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	messageKey, next := ratchetChainKey(s.sendChain)
+	aead, err := sessionAEAD(messageKey)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, sessionSeqnoLength)
+	binary.BigEndian.PutUint64(header, s.sendNext)
+	nonce := make([]byte, aead.NonceSize())
+	msg := aead.Seal(header, nonce, plaintext, sessionAD(s.sendLabel, header, ad))
+
+	s.sendChain = next
+	s.sendNext++
+	return msg, nil
+}
+
+// Open decrypts a message from the peer sealed with the same ad. A message
+// which does not authenticate leaves the session unchanged.
+func (s *Session) Open(msg, ad []byte) ([]byte, error) {
+	if len(msg) < sessionSeqnoLength {
+		return nil, NewMessageAuthenticationError()
+	}
+	header, ciphertext := msg[:sessionSeqnoLength], msg[sessionSeqnoLength:]
+	seqno := binary.BigEndian.Uint64(header)
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Find the message key, without changing the session yet.
+	var messageKey []byte
+	chain := s.recvChain
+	var skipped [][]byte
+	switch {
+	case seqno < s.recvNext:
+		var ok bool
+		if messageKey, ok = s.skipped[seqno]; !ok {
+			if s.recvNext-seqno > sessionWindow {
+				return nil, NewMessageOutOfWindowError(seqno, s.recvNext)
+			}
+			return nil, NewReplayedMessageError(seqno)
+		}
+	case seqno-s.recvNext >= sessionWindow:
+		return nil, NewMessageOutOfWindowError(seqno, s.recvNext)
+	default:
+		for i := s.recvNext; i < seqno; i++ {
+			var mk []byte
+			mk, chain = ratchetChainKey(chain)
+			skipped = append(skipped, mk)
+		}
+		messageKey, chain = ratchetChainKey(chain)
+	}
+
+	aead, err := sessionAEAD(messageKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	plaintext, err := aead.Open(nil, nonce, ciphertext, sessionAD(s.recvLabel, header, ad))
+	if err != nil {
+		return nil, NewMessageAuthenticationError()
+	}
+
+	if seqno < s.recvNext {
+		delete(s.skipped, seqno)
+		return plaintext, nil
+	}
+	for i, mk := range skipped {
+		s.skipped[s.recvNext+uint64(i)] = mk
+	}
+	s.recvChain = chain
+	s.recvNext = seqno + 1
+	for i := range s.skipped {
+		if s.recvNext-i > sessionWindow {
+			delete(s.skipped, i)
+		}
+	}
+	return plaintext, nil
+}
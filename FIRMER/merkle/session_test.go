@@ -0,0 +1,126 @@
+package merkle
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSessions(t *testing.T) (*Session, *Session) {
+	key := generateRandomBytes(32)
+	a, err := NewSession(testParams, key, true)
+	require.NoError(t, err)
+	b, err := NewSession(testParams, key, false)
+	require.NoError(t, err)
+	return a, b
+}
+
+func TestSessionAfterAKE(t *testing.T) {
+	ctx, _, dir, self1, self2 := akeTestSetup(t)
+	ri, rr := runTestAKE(t, ctx, self1, self2.ID, self2, dir)
+	require.NoError(t, ri.err)
+	require.NoError(t, rr.err)
+
+	alice, err := NewSession(testParams, ri.key, true)
+	require.NoError(t, err)
+	bob, err := NewSession(testParams, rr.key, false)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		msg, err := alice.Seal([]byte(fmt.Sprintf("hello %d", i)), nil)
+		require.NoError(t, err)
+		pt, err := bob.Open(msg, nil)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("hello %d", i), string(pt))
+
+		reply, err := bob.Seal([]byte("ack"), []byte("ad"))
+		require.NoError(t, err)
+		pt, err = alice.Open(reply, []byte("ad"))
+		require.NoError(t, err)
+		require.Equal(t, "ack", string(pt))
+	}
+}
+
+func TestSessionForwardSecrecy(t *testing.T) {
+	a, b := newTestSessions(t)
+	m1, err := a.Seal([]byte("one"), nil)
+	require.NoError(t, err)
+	m2, err := a.Seal([]byte("two"), nil)
+	require.NoError(t, err)
+	// Same plaintext, different keys.
+	require.NotEqual(t, m1[sessionSeqnoLength:], m2[sessionSeqnoLength:])
+
+	_, err = b.Open(m1, nil)
+	require.NoError(t, err)
+	// The receiving chain has moved past message 0, and its key is gone.
+	_, err = b.Open(m1, nil)
+	require.IsType(t, ReplayedMessageError{}, err)
+	require.Empty(t, b.skipped)
+	_, err = b.Open(m2, nil)
+	require.NoError(t, err)
+}
+
+func TestSessionOutOfOrder(t *testing.T) {
+	a, b := newTestSessions(t)
+	var msgs [][]byte
+	for i := 0; i < sessionWindow+2; i++ {
+		m, err := a.Seal([]byte{byte(i)}, nil)
+		require.NoError(t, err)
+		msgs = append(msgs, m)
+	}
+
+	// Message 3 first, then 1, then 3 again.
+	pt, err := b.Open(msgs[3], nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte{3}, pt)
+	pt, err = b.Open(msgs[1], nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, pt)
+	_, err = b.Open(msgs[3], nil)
+	require.IsType(t, ReplayedMessageError{}, err)
+	_, err = b.Open(msgs[1], nil)
+	require.IsType(t, ReplayedMessageError{}, err)
+
+	// Jumping ahead within the window is fine, but then the keys of messages
+	// left behind by more than the window are gone.
+	_, err = b.Open(msgs[sessionWindow+1], nil)
+	require.NoError(t, err)
+	_, err = b.Open(msgs[0], nil)
+	require.IsType(t, MessageOutOfWindowError{}, err)
+	pt, err = b.Open(msgs[2], nil)
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, pt)
+
+	// Jumping ahead past the window is refused.
+	far, err := NewSession(testParams, generateRandomBytes(32), false)
+	require.NoError(t, err)
+	_, err = far.Open(msgs[sessionWindow], nil)
+	require.IsType(t, MessageOutOfWindowError{}, err)
+}
+
+func TestSessionRejectsTampering(t *testing.T) {
+	a, b := newTestSessions(t)
+	m, err := a.Seal([]byte("secret"), []byte("ad"))
+	require.NoError(t, err)
+
+	_, err = b.Open(m, []byte("other ad"))
+	require.IsType(t, MessageAuthenticationError{}, err)
+	tampered := append([]byte{}, m...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = b.Open(tampered, []byte("ad"))
+	require.IsType(t, MessageAuthenticationError{}, err)
+	// A message on the wrong chain, e.g. reflected back to its sender.
+	_, err = a.Open(m, []byte("ad"))
+	require.IsType(t, MessageAuthenticationError{}, err)
+	_, err = b.Open(m[:4], []byte("ad"))
+	require.IsType(t, MessageAuthenticationError{}, err)
+
+	// Failures leave the session as it was.
+	pt, err := b.Open(m, []byte("ad"))
+	require.NoError(t, err)
+	require.Equal(t, "secret", string(pt))
+
+	_, err = NewSession(testParams, []byte("short"), true)
+	require.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"FIRMER/logger"
+)
+
+// signedTreeHeadPrefix is prepended to every signed tree head message, so that
+// tree head signatures cannot be confused with any other BLS signature made
+// with the same key.
+var signedTreeHeadPrefix = []byte("FIRMER signed tree head v1\n")
+
+// SignedTreeHead binds a TransparencyDigest to its Seqno and to the time at
+// which the server published it. Clients check the signature with the
+// server's BLS public key before trusting any proof against the digest.
+type SignedTreeHead struct {
+	_struct   struct{}           `codec:",toarray"` //nolint
+	Seqno     Seqno              `codec:"s"`
+	Digest    TransparencyDigest `codec:"d"`
+	Timestamp int64              `codec:"t"` // milliseconds since the Unix epoch
+	Signature []byte             `codec:"g"`
+}
+
+// signedTreeHeadMessage returns prefix || seqno || timestamp || len(digest) || digest,
+// with integers in big endian.
+func signedTreeHeadMessage(s Seqno, td TransparencyDigest, timestamp int64) []byte {
+	n := len(signedTreeHeadPrefix)
+	msg := make([]byte, n+20+len(td))
+	copy(msg, signedTreeHeadPrefix)
+	binary.BigEndian.PutUint64(msg[n:], uint64(s))
+	binary.BigEndian.PutUint64(msg[n+8:], uint64(timestamp))
+	binary.BigEndian.PutUint32(msg[n+16:], uint32(len(td)))
+	copy(msg[n+20:], td)
+	return msg
+}
+
+// NewSignedTreeHead signs the digest td of the tree at Seqno s.
+func NewSignedTreeHead(params ProtocolParams, sk *PrivateKey, s Seqno, td TransparencyDigest, ts time.Time) SignedTreeHead {
+	timestamp := ts.UnixMilli()
+	sig := sk.Sign(params, signedTreeHeadMessage(s, td, timestamp))
+	return SignedTreeHead{
+		Seqno:     s,
+		Digest:    append(TransparencyDigest{}, td...),
+		Timestamp: timestamp,
+		Signature: sig.ToBytes(),
+	}
+}
+
+// Time returns the publication time of the tree head.
+func (sth SignedTreeHead) Time() time.Time {
+	return time.UnixMilli(sth.Timestamp)
+}
+
+// Verify checks the signature on the tree head with the server public key pk.
+func (sth SignedTreeHead) Verify(params ProtocolParams, pk *PublicKey) error {
+	if len(sth.Digest) == 0 {
+		return NewInvalidSignedTreeHeadError(sth.Seqno, fmt.Errorf("empty digest"))
+	}
+	sig, err := SignatureFromBytes(sth.Signature)
+	if err != nil {
+		return NewInvalidSignedTreeHeadError(sth.Seqno, err)
+	}
+	if !pk.Verify(params, signedTreeHeadMessage(sth.Seqno, sth.Digest, sth.Timestamp), sig) {
+		return NewInvalidSignedTreeHeadError(sth.Seqno, fmt.Errorf("bad signature"))
+	}
+	return nil
+}
+
+// SetSigningKey stores sk in the key store of the tree, which then signs a
+// SignedTreeHead for every new version it builds, with the protocol
+// parameters of its Config. Passing nil disables signing.
+func (t *Tree) SetSigningKey(ctx logger.ContextInterface, sk *PrivateKey) error {
+	if sk != nil {
+		if err := t.cfg.Params.check("SetSigningKey"); err != nil {
+			return err
+		}
+	}
+	t.Lock()
+	defer t.Unlock()
+	return t.keys.StoreSigningKey(ctx, nil, sk)
+}
+
+// SignedTreeHead returns the tree head signed for Seqno s. It fails if the
+// tree had no signing key when s was built.
+func (t *Tree) SignedTreeHead(ctx logger.ContextInterface, tr Transaction, s Seqno) (SignedTreeHead, error) {
+	t.RLock()
+	defer t.RUnlock()
+	return t.eng.LookupSignedTreeHead(ctx, tr, s)
+}
+
+// LatestSignedTreeHead returns the tree head signed for the latest signed
+// version of the tree.
+func (t *Tree) LatestSignedTreeHead(ctx logger.ContextInterface, tr Transaction) (SignedTreeHead, error) {
+	t.RLock()
+	defer t.RUnlock()
+	return t.eng.LookupLatestSignedTreeHead(ctx, tr)
+}
+
+func (t *Tree) signTreeHead(ctx logger.ContextInterface, tr Transaction, s Seqno, td TransparencyDigest) error {
+	sk, err := t.keys.LookupSigningKey(ctx, tr)
+	if err != nil || sk == nil {
+		return err
+	}
+	return t.eng.StoreSignedTreeHead(ctx, tr, NewSignedTreeHead(t.cfg.Params, sk, s, td, time.Now()))
+}
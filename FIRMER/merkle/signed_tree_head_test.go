@@ -0,0 +1,119 @@
+package merkle
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedTreeHead(t *testing.T) {
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pk := sk.GetPublicKey()
+
+	ts := time.UnixMilli(1700000000123)
+	sth := NewSignedTreeHead(testParams, sk, 7, TransparencyDigest{1, 2, 3}, ts)
+	require.NoError(t, sth.Verify(testParams, pk))
+	require.Equal(t, ts, sth.Time())
+
+	tampered := sth
+	tampered.Seqno = 8
+	require.IsType(t, InvalidSignedTreeHeadError{}, tampered.Verify(testParams, pk))
+	tampered = sth
+	tampered.Timestamp++
+	require.Error(t, tampered.Verify(testParams, pk))
+	tampered = sth
+	tampered.Digest = TransparencyDigest{1, 2, 4}
+	require.Error(t, tampered.Verify(testParams, pk))
+	tampered = sth
+	tampered.Signature = nil
+	require.Error(t, tampered.Verify(testParams, pk))
+
+	other, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.Error(t, sth.Verify(testParams, other.GetPublicKey()))
+}
+
+func TestSignedUpdate(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	pp := GenPP()
+	st := Init(pp)
+	require.NotNil(t, st)
+
+	_, err := st.LatestSignedTreeHead(ctx, nil)
+	require.Error(t, err)
+
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pk := sk.GetPublicKey()
+	require.NoError(t, st.SetSigningKey(ctx, sk))
+
+	S1 := GenerateInitS(1, 20)
+	sth1, st, s1 := SignedUpdate(st, S1, ctx)
+	require.NotNil(t, st)
+	require.Equal(t, s1, sth1.Seqno)
+	require.NoError(t, sth1.Verify(pp.Params, pk))
+
+	S2 := GenerateAddS(3)
+	sth2, st, s2 := SignedUpdate(st, S2, ctx)
+	require.NoError(t, sth2.Verify(pp.Params, pk))
+	latest, err := st.LatestSignedTreeHead(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, sth2, latest)
+
+	_, _, td, err := st.GetLatestRoot(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, td, sth2.Digest)
+
+	π, value, tSeq := Query(st, s2, S2[1].Key, ctx)
+	require.Equal(t, 1, VerifySigned(sth2, pk, S2[1].Key, value, tSeq, π, ctx, pp))
+
+	forged := sth2
+	forged.Digest = append(TransparencyDigest{}, sth1.Digest...)
+	require.Equal(t, -1, VerifySigned(forged, pk, S2[1].Key, value, tSeq, π, ctx, pp))
+
+	other, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.Equal(t, -1, VerifySigned(sth2, other.GetPublicKey(), S2[1].Key, value, tSeq, π, ctx, pp))
+
+	sth3, _, s3 := SignedPCSUpdate(st, GenerateAddS2(2), ctx)
+	require.Equal(t, s3, sth3.Seqno)
+	require.NoError(t, sth3.Verify(pp.Params, pk))
+
+	// Versions built before the key was set, or after it was removed, are unsigned.
+	require.NoError(t, st.SetSigningKey(ctx, nil))
+	_, _, s4 := Update(st, GenerateInitS(100, 101), ctx)
+	_, err = st.SignedTreeHead(ctx, nil, s4)
+	require.Error(t, err)
+	_, err = st.SignedTreeHead(ctx, nil, s1)
+	require.NoError(t, err)
+}
+
+func TestSignedTreeHeadsStored(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	pp := GenPP()
+	st := Init(pp)
+	require.NotNil(t, st)
+
+	sk, err := GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, st.SetSigningKey(ctx, sk))
+
+	sth1, st, s1 := SignedUpdate(st, GenerateInitS(1, 10), ctx)
+	require.NotNil(t, st)
+	sth2, st, _ := SignedUpdate(st, GenerateAddS(2), ctx)
+	require.NotNil(t, st)
+
+	// A tree reopened over the same storage serves the heads signed before.
+	reopened, err := NewTree(pp, 2, st.Eng(), RootVersionV1)
+	require.NoError(t, err)
+	got, err := reopened.SignedTreeHead(ctx, nil, s1)
+	require.NoError(t, err)
+	require.Equal(t, sth1, got)
+	require.NoError(t, got.Verify(pp.Params, sk.GetPublicKey()))
+	latest, err := reopened.LatestSignedTreeHead(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, sth2, latest)
+}
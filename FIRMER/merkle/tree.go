@@ -2,13 +2,13 @@ package merkle
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"math/big"
-	"runtime"
 	"sort"
 	"sync"
 	"time"
@@ -17,6 +17,7 @@ import (
 	"FIRMER/vrf"
 	"github.com/keybase/go-codec/codec"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
 )
 
 // Tree is the MerkleTree class; it needs an engine and a configuration
@@ -41,12 +42,14 @@ type Tree struct {
 	// approximately balanced and have short-ish paths).
 	step int
 
-	rotateNewProofs   map[string][]byte
 	fastpathN         int
 	FastpathFallbacks int
 
 	fastpathMiss bool
 
+	// keys holds the VRF private keys and the tree head signing key.
+	keys KeyStore
+
 	// these fields are used as buffers during tree building to avoid making
 	// many short lived memory allocations
 	bufLeaf           Node
@@ -64,24 +67,37 @@ type Tree struct {
 
 	LastRotateVRFEl   time.Duration
 	LastRotateBuildEl time.Duration
+
+	// rotateBatchSize is the number of pairs Rotate holds in memory at once.
+	rotateBatchSize int
+	rotateProgress  func(RotateProgress)
 }
 
-// NewTree makes a new tree
+// NewTree makes a new tree, keeping its keys in memory.
 func NewTree(c Config, step int, e StorageEngine, v RootVersion) (*Tree, error) {
+	return NewTreeWithKeyStore(c, step, e, NewInMemoryKeyStore(), v)
+}
+
+// NewTreeWithKeyStore makes a new tree keeping its keys in ks.
+func NewTreeWithKeyStore(c Config, step int, e StorageEngine, ks KeyStore, v RootVersion) (*Tree, error) {
 	if step < 1 {
 		return nil, fmt.Errorf("step must be a positive integer")
 	}
 
 	historyTree := NewLBBMT(e)
-	return &Tree{cfg: c, eng: e, step: step,
+	return &Tree{cfg: c, eng: e, keys: ks, step: step,
 		newRootVersion: v, historyTree: historyTree,
-		rotateNewProofs: make(map[string][]byte), fastpathN: 10}, nil
+		fastpathN: 10, rotateBatchSize: defaultRotateBatchSize}, nil
 }
 
 func (t *Tree) Eng() StorageEngine {
 	return t.eng
 }
 
+func (t *Tree) KeyStore() KeyStore {
+	return t.keys
+}
+
 type TransparencyDigest []byte
 
 // Equal compares two keys byte by byte
@@ -285,6 +301,19 @@ type RootMetadata struct {
 	AddOnsHash []byte
 }
 
+func (r RootMetadata) vrfPublicKey() *vrf.PublicKey {
+	return &vrf.PublicKey{
+		X: new(big.Int).SetBytes(r.VRFPublicKeyX),
+		Y: new(big.Int).SetBytes(r.VRFPublicKeyY),
+	}
+}
+
+// VRFPublicKey returns the encoding of the VRF public key of r, a key of the
+// suite v, for clients to pin it.
+func (r RootMetadata) VRFPublicKey(v vrf.ECVRF) ([]byte, error) {
+	return v.Params().MarshalPublicKey(r.vrfPublicKey())
+}
+
 func RandomBytes(n int) ([]byte, error) {
 	ret := make([]byte, n)
 	_, err := rand.Read(ret)
@@ -314,13 +343,13 @@ func (t *Tree) hideKVPairs(ctx logger.ContextInterface, tr Transaction, per Peri
 	var vrfProofs [][]byte
 	var err error
 	st := time.Now()
-	if !fake && len(kvps) >= 100 {
-		kevps, vrfProofs, err = t.hideKVPairsPar(sk, kvps, seqnos, fake)
+	if !fake && len(kvps) >= t.cfg.parallelHideThreshold() {
+		kevps, vrfProofs, err = t.hideKVPairsPar(ctx, sk, kvps, seqnos, fake)
 		if err != nil {
 			return nil, nil, err
 		}
 	} else {
-		kevps, vrfProofs, err = t.hideKVPairsSeq(sk, kvps, seqnos, fake)
+		kevps, vrfProofs, err = t.hideKVPairsSeq(ctx, sk, kvps, seqnos, fake)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -350,83 +379,80 @@ func (t *Tree) hideKVPairs(ctx logger.ContextInterface, tr Transaction, per Peri
 	return kevps, vrfProofs, nil
 }
 
-func (t *Tree) hideKVPairsPar(sk *vrf.PrivateKey, kvps []KeyValuePair, seqnos []Seqno, fake bool) ([]HiddenKeyValuePair, [][]byte, error) {
+// hideKVPairsPar hides the keys of kvps with t.cfg.hideWorkers() workers. It
+// returns the first error of a worker, after which the others stop.
+func (t *Tree) hideKVPairsPar(ctx logger.ContextInterface, sk *vrf.PrivateKey, kvps []KeyValuePair, seqnos []Seqno, fake bool) ([]HiddenKeyValuePair, [][]byte, error) {
 	hkvps := make([]HiddenKeyValuePair, len(kvps))
 	prfs := make([][]byte, len(kvps))
-	var wg sync.WaitGroup
-	for idx, kvp := range kvps {
-		wg.Add(1)
-		idx := idx
-		kvp := kvp
-		encodedValue, err := t.cfg.Encoder.Encode(kvp.Value)
-		if err != nil {
-			return nil, nil, err
-		}
-		entropy, err := RandomBytes(32)
-		if err != nil {
-			return nil, nil, err
+	g, gctx := errgroup.WithContext(goContext(ctx))
+	g.SetLimit(t.cfg.hideWorkers())
+	for idx := range kvps {
+		// Once a worker failed, the remaining pairs are not scheduled.
+		if gctx.Err() != nil {
+			break
 		}
-		go func() {
-			defer wg.Done()
-			hk, prf, err := t.hideKey(sk, kvp.Key, fake)
-			if err != nil {
-				panic(err)
+		idx := idx
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
 			}
-			hkvp := HiddenKeyValuePair{
-				Key:          kvp.Key,
-				HiddenKey:    hk,
-				EncodedValue: encodedValue,
-				Entropy:      entropy,
-				AddedAtSeqno: seqnos[idx],
+			hkvp, prf, err := t.hideKVPair(sk, kvps[idx], seqnos[idx], fake)
+			if err != nil {
+				return err
 			}
 			hkvps[idx] = hkvp
 			prfs[idx] = prf
-		}()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
 	}
-	wg.Wait()
 	return hkvps, prfs, nil
 }
 
-func (t *Tree) hideKVPairsSeq(sk *vrf.PrivateKey, kvps []KeyValuePair, seqnos []Seqno, fake bool) ([]HiddenKeyValuePair, [][]byte, error) {
+func (t *Tree) hideKVPairsSeq(ctx logger.ContextInterface, sk *vrf.PrivateKey, kvps []KeyValuePair, seqnos []Seqno, fake bool) ([]HiddenKeyValuePair, [][]byte, error) {
 	hkvps := make([]HiddenKeyValuePair, len(kvps))
 	prfs := make([][]byte, len(kvps))
 	for idx, kvp := range kvps {
-		encodedValue, err := t.cfg.Encoder.Encode(kvp.Value)
-		if err != nil {
+		if err := ctxErr(ctx); err != nil {
 			return nil, nil, err
 		}
-		entropy, err := RandomBytes(32)
+		hkvp, prf, err := t.hideKVPair(sk, kvp, seqnos[idx], fake)
 		if err != nil {
 			return nil, nil, err
 		}
-		hk, prf, err := t.hideKey(sk, kvp.Key, fake)
-		if err != nil {
-			return nil, nil, err
-		}
-		hkvp := HiddenKeyValuePair{
-			Key:          kvp.Key,
-			HiddenKey:    hk,
-			EncodedValue: encodedValue,
-			Entropy:      entropy,
-			AddedAtSeqno: seqnos[idx],
-		}
 		hkvps[idx] = hkvp
 		prfs[idx] = prf
 	}
 	return hkvps, prfs, nil
 }
 
-func (t *Tree) hideKey(sk *vrf.PrivateKey, k Key, fake bool) (hk HiddenKey, proof []byte, err error) {
-	if len(t.rotateNewProofs) > 0 {
-		proof := t.rotateNewProofs[k.String()]
-		hiddenKey, err := t.cfg.ECVRF.ProofToHash(proof)
-		if err != nil {
-			return nil, nil, errors.Wrap(err, "hide key rotate fastpath")
-
-		}
-		return hiddenKey, proof, nil
+// hideKVPair returns kvp with its key hidden and its value encoded, and the
+// VRF proof of the hidden key.
+func (t *Tree) hideKVPair(sk *vrf.PrivateKey, kvp KeyValuePair, seqno Seqno, fake bool) (HiddenKeyValuePair, []byte, error) {
+	encodedValue, err := t.cfg.Encoder.Encode(kvp.Value)
+	if err != nil {
+		return HiddenKeyValuePair{}, nil, err
+	}
+	entropy, err := RandomBytes(32)
+	if err != nil {
+		return HiddenKeyValuePair{}, nil, err
 	}
+	hk, prf, err := t.hideKey(sk, kvp.Key, fake)
+	if err != nil {
+		return HiddenKeyValuePair{}, nil, err
+	}
+	return HiddenKeyValuePair{
+		Key:          kvp.Key,
+		HiddenKey:    hk,
+		EncodedValue: encodedValue,
+		Entropy:      entropy,
+		AddedAtSeqno: seqno,
+	}, prf, nil
+}
 
+func (t *Tree) hideKey(sk *vrf.PrivateKey, k Key, fake bool) (hk HiddenKey, proof []byte, err error) {
 	var hiddenKey HiddenKey
 	var vrfProof []byte
 	if fake {
@@ -452,12 +478,16 @@ func sortHiddenKeyValuePairsInPlace(hkvps []HiddenKeyValuePair) {
 	})
 }
 
-func (t *Tree) newVRFPrivateKey() (*vrf.PrivateKey, error) {
-	skBytes, err := RandomBytes(32)
+// newVRFPrivateKey picks the VRF private key of period and stores it in the
+// key store.
+func (t *Tree) newVRFPrivateKey(ctx logger.ContextInterface, tr Transaction, period Period) (*vrf.PrivateKey, error) {
+	k, err := vrf.GenerateKey(t.cfg.ECVRF.Params().EC(), rand.Reader)
 	if err != nil {
 		return nil, err
 	}
-	k := vrf.NewKey(t.cfg.ECVRF.Params().EC(), skBytes)
+	if err := t.keys.StoreVRFPrivateKey(ctx, tr, period, k); err != nil {
+		return nil, err
+	}
 	return k, nil
 }
 
@@ -466,7 +496,7 @@ func (t *Tree) lookupCurrentEpoch(ctx logger.ContextInterface, tr Transaction) (
 	switch err.(type) {
 	case nil:
 		period := rootMd.Period
-		sk, err := t.eng.LookupVRFPrivateKey(ctx, tr, period)
+		sk, err := t.keys.LookupVRFPrivateKey(ctx, tr, period)
 		if err != nil {
 			return 0, 0, nil, err
 		}
@@ -478,15 +508,73 @@ func (t *Tree) lookupCurrentEpoch(ctx logger.ContextInterface, tr Transaction) (
 	}
 }
 
+// goContext returns the context of ctx, or the background context if it has
+// none.
+func goContext(ctx logger.ContextInterface) context.Context {
+	if ctx == nil || ctx.Ctx() == nil {
+		return context.Background()
+	}
+	return ctx.Ctx()
+}
+
+// ctxErr returns the error of the context of ctx once it is cancelled or
+// past its deadline.
+func ctxErr(ctx logger.ContextInterface) error {
+	return goContext(ctx).Err()
+}
+
+// beginUpdate starts a Build or Rotate, and returns the function to call with
+// its error when it ends. If the update failed, that function undoes its
+// writes if the engine is a RollbackEngine.
+func (t *Tree) beginUpdate(ctx logger.ContextInterface, tr Transaction) (end func(error) error, err error) {
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+	re, canRollback := t.eng.(RollbackEngine)
+	if canRollback {
+		if err := re.Savepoint(ctx, tr); err != nil {
+			return nil, err
+		}
+	}
+	return func(err error) error {
+		if err == nil {
+			if canRollback {
+				return re.ReleaseSavepoint(ctx, tr)
+			}
+			return nil
+		}
+		if canRollback {
+			if rerr := re.RollbackToSavepoint(ctx, tr); rerr != nil {
+				return errors.Wrapf(err, "rollback failed: %v", rerr)
+			}
+		}
+		return err
+	}, nil
+}
+
 // Build builds a new tree version, taking a batch input.
 // NOTE: This function is modified from the original code which required each successive
 // sortedKVPairs's keys to be a superset of the previous. There is no such requirement now.
 // Modifying values is supported as well, though might not be used in practice.
+// Like Rotate, it stops once ctx is done, leaving no partial epoch.
 func (t *Tree) Build(ctx logger.ContextInterface, tr Transaction,
 	kvPairs []KeyValuePair, addOnsHash []byte, fake bool) (s Seqno, td TransparencyDigest, err error) {
 	t.Lock()
 	defer t.Unlock()
 
+	end, err := t.beginUpdate(ctx, tr)
+	if err != nil {
+		return 0, nil, err
+	}
+	s, td, err = t.build(ctx, tr, kvPairs, addOnsHash, fake)
+	if err = end(err); err != nil {
+		return 0, nil, err
+	}
+	return s, td, nil
+}
+
+func (t *Tree) build(ctx logger.ContextInterface, tr Transaction,
+	kvPairs []KeyValuePair, addOnsHash []byte, fake bool) (s Seqno, td TransparencyDigest, err error) {
 	oldSeqno, oldPeriod, oldSk, err := t.lookupCurrentEpoch(ctx, tr)
 	if err != nil {
 		return 0, nil, err
@@ -499,11 +587,7 @@ func (t *Tree) Build(ctx logger.ContextInterface, tr Transaction,
 	if oldSeqno == 0 {
 		period = 1
 
-		sk, err = t.newVRFPrivateKey()
-		if err != nil {
-			return 0, nil, err
-		}
-		err = t.eng.StoreVRFPrivateKey(ctx, tr, period, sk)
+		sk, err = t.newVRFPrivateKey(ctx, tr, period)
 		if err != nil {
 			return 0, nil, err
 		}
@@ -522,10 +606,102 @@ func (t *Tree) Build(ctx logger.ContextInterface, tr Transaction,
 	return seqno, td, nil
 }
 
+// defaultRotateBatchSize is the default number of pairs Rotate holds in
+// memory at once.
+const defaultRotateBatchSize = 10000
+
+// RotatePhase is a pass of Rotate over the pairs of the tree.
+type RotatePhase int
+
+const (
+	// RotateRebuild rebuilds the tree under the new VRF key.
+	RotateRebuild RotatePhase = iota
+	// RotateHash hashes the mappings of the rotation proof.
+	RotateHash
+	// RotateCombine combines the mappings into the rotation proof.
+	RotateCombine
+)
+
+// RotateProgress reports the progress of Rotate.
+type RotateProgress struct {
+	Phase RotatePhase
+	// Done is the number of pairs processed in this phase.
+	Done int
+	// Total is the number of pairs, unknown (0) during RotateRebuild.
+	Total int
+}
+
+// SetRotateBatchSize sets the number of pairs Rotate holds in memory at once.
+func (t *Tree) SetRotateBatchSize(n int) error {
+	if n < 1 {
+		return fmt.Errorf("rotate batch size must be a positive integer")
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.rotateBatchSize = n
+	return nil
+}
+
+// SetRotateProgress sets a function which Rotate calls after each batch of
+// pairs. It is called with the tree locked.
+func (t *Tree) SetRotateProgress(f func(RotateProgress)) {
+	t.Lock()
+	defer t.Unlock()
+	t.rotateProgress = f
+}
+
+func (t *Tree) reportRotateProgress(phase RotatePhase, done, total int) {
+	if t.rotateProgress != nil {
+		t.rotateProgress(RotateProgress{Phase: phase, Done: done, Total: total})
+	}
+}
+
+// forEachPairBatch calls f on the pairs at (s, per) ordered by HiddenKey,
+// t.rotateBatchSize at a time.
+func (t *Tree) forEachPairBatch(ctx logger.ContextInterface, tr Transaction, s Seqno, per Period,
+	f func([]HiddenKeyValuePair) error) error {
+	var after HiddenKey
+	for {
+		if err := ctxErr(ctx); err != nil {
+			return err
+		}
+		pairs, err := t.eng.LookupPairsAfter(ctx, tr, s, per, after, t.rotateBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(pairs) == 0 {
+			return nil
+		}
+		if err = f(pairs); err != nil {
+			return err
+		}
+		after = pairs[len(pairs)-1].HiddenKey
+	}
+}
+
+// Rotate moves the tree to a new period and VRF key. It goes over the pairs
+// in batches, so that its memory use does not depend on the size of the tree:
+// once to rebuild the tree under the new key, which stores the new VRF proofs
+// in the cache, then twice to prove the rotation from the cached proofs.
+// The tree is rebuilt over several epochs, of which Rotate returns the last.
+// It stops once ctx is cancelled or past its deadline, undoing its writes as
+// described in RollbackEngine.
 func (t *Tree) Rotate(ctx logger.ContextInterface, tr Transaction, addOnsHash []byte) (s Seqno, td TransparencyDigest, err error) {
 	t.Lock()
 	defer t.Unlock()
 
+	end, err := t.beginUpdate(ctx, tr)
+	if err != nil {
+		return 0, nil, err
+	}
+	s, td, err = t.rotate(ctx, tr, addOnsHash)
+	if err = end(err); err != nil {
+		return 0, nil, err
+	}
+	return s, td, nil
+}
+
+func (t *Tree) rotate(ctx logger.ContextInterface, tr Transaction, addOnsHash []byte) (s Seqno, td TransparencyDigest, err error) {
 	oldSeqno, oldPeriod, oldSk, err := t.lookupCurrentEpoch(ctx, tr)
 	if err != nil {
 		return 0, nil, err
@@ -538,84 +714,157 @@ func (t *Tree) Rotate(ctx logger.ContextInterface, tr Transaction, addOnsHash []
 	seqno := oldSeqno + 1
 	period := oldPeriod + 1
 
-	oldHKVPairs, err := t.eng.LookupAllPairs(ctx, tr, oldSeqno, oldPeriod)
+	rotator, err := t.cfg.ECVRF.NewRotator(oldSk)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "rotate VRF key")
+	}
+	sk := rotator.NewKey()
+	err = t.keys.StoreVRFPrivateKey(ctx, tr, period, sk)
 	if err != nil {
 		return 0, nil, err
 	}
-	var kvPairs []KeyValuePair
-	var keys [][]byte
-	var seqnos []Seqno
-	var oldProofs [][]byte
-	for _, hkvPair := range oldHKVPairs {
-		valContainer := t.cfg.ConstructValueContainer()
-		err = t.cfg.Encoder.Decode(&valContainer, hkvPair.EncodedValue)
-		if err != nil {
-			return 0, nil, err
+
+	st := time.Now()
+	n := 0
+	runningSeqno := seqno
+	err = t.forEachPairBatch(ctx, tr, oldSeqno, oldPeriod, func(pairs []HiddenKeyValuePair) error {
+		kvPairs := make([]KeyValuePair, len(pairs))
+		seqnos := make([]Seqno, len(pairs))
+		for i, hkvPair := range pairs {
+			valContainer := t.cfg.ConstructValueContainer()
+			err := t.cfg.Encoder.Decode(&valContainer, hkvPair.EncodedValue)
+			if err != nil {
+				return err
+			}
+			kvPairs[i] = KeyValuePair{Key: hkvPair.Key, Value: valContainer}
+			seqnos[i] = hkvPair.AddedAtSeqno
 		}
-		kvPairs = append(kvPairs, KeyValuePair{Key: hkvPair.Key, Value: valContainer})
-		keys = append(keys, []byte(hkvPair.Key))
-		seqnos = append(seqnos, hkvPair.AddedAtSeqno)
 
-		// should batch
-		_, oldProof, err := t.eng.LookupVRFCache(ctx, tr, oldPeriod, hkvPair.Key)
-		if err != nil {
-			return 0, nil, err
+		// chunk
+		for i := 0; i < len(kvPairs); i += 100 {
+			end := i + 100
+			if end > len(kvPairs) {
+				end = len(kvPairs)
+			}
+			var err error
+			td, err = t.finalizeEpoch(ctx, tr, runningSeqno, period, sk, kvPairs[i:end], seqnos[i:end], addOnsHash, false)
+			if err != nil {
+				return err
+			}
+			runningSeqno += 1
 		}
-		oldProofs = append(oldProofs, oldProof)
+		n += len(pairs)
+		t.reportRotateProgress(RotateRebuild, n, 0)
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
 	}
+	t.LastRotateBuildEl = time.Since(st)
 
-	st := time.Now()
-	sk, pi, newProofs, err := t.cfg.ECVRF.StatefulRotate(oldSk, keys, oldProofs)
+	st = time.Now()
+	done := 0
+	err = t.forEachPairBatch(ctx, tr, oldSeqno, oldPeriod, func(pairs []HiddenKeyValuePair) error {
+		mappings, err := t.rotationMappings(ctx, tr, oldPeriod, period, oldSk, pairs)
+		if err != nil {
+			return err
+		}
+		if err = rotator.HashMappings(n, mappings); err != nil {
+			return err
+		}
+		done += len(pairs)
+		t.reportRotateProgress(RotateHash, done, n)
+		return nil
+	})
+	if err == nil && done == 0 {
+		err = rotator.HashMappings(0, nil)
+	}
 	if err != nil {
-		return 0, nil, errors.Wrap(err, "stateful rotate")
+		return 0, nil, errors.Wrap(err, "rotation proof")
 	}
-	runtime.GC()
-	t.LastRotateVRFEl = time.Since(st)
-	for i, hkvPair := range oldHKVPairs {
-		t.rotateNewProofs[hkvPair.Key.String()] = newProofs[i]
+	done = 0
+	err = t.forEachPairBatch(ctx, tr, oldSeqno, oldPeriod, func(pairs []HiddenKeyValuePair) error {
+		mappings, err := t.rotationMappings(ctx, tr, oldPeriod, period, oldSk, pairs)
+		if err != nil {
+			return err
+		}
+		if err = rotator.CombineMappings(mappings); err != nil {
+			return err
+		}
+		done += len(pairs)
+		t.reportRotateProgress(RotateCombine, done, n)
+		return nil
+	})
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "rotation proof")
 	}
-
-	err = t.eng.StoreVRFPrivateKey(ctx, tr, period, sk)
+	pi, err := rotator.Proof()
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, errors.Wrap(err, "rotation proof")
 	}
+	t.LastRotateVRFEl = time.Since(st)
 
 	err = t.eng.StoreVRFRotationProof(ctx, tr, period, pi)
 	if err != nil {
 		return 0, nil, err
 	}
 
-	st = time.Now()
-	// chunk
-	i := 0
-	runningSeqno := seqno
-	for {
-		if i > len(kvPairs)-1 {
-			break
-		}
-		end := i + 100
-		if end > len(kvPairs) {
-			end = len(kvPairs)
-		}
-		chunkPairs := kvPairs[i:end]
-		chunkSeqnos := seqnos[i:end]
-
-		td, err = t.finalizeEpoch(ctx, tr, runningSeqno, period, sk, chunkPairs, chunkSeqnos, addOnsHash, false)
-		if err != nil {
-			return 0, nil, err
-		}
-		runningSeqno += 1
-		i += 100
-
-		if i%10000 == 0 {
-			runtime.GC()
-		}
+	if runningSeqno > seqno {
+		seqno = runningSeqno - 1
 	}
+	return seqno, td, nil
+}
 
-	t.LastRotateBuildEl = time.Since(st)
-	t.rotateNewProofs = make(map[string][]byte)
+// rotationMappings returns the mappings of the keys of pairs from their
+// proofs under the old and the new key, read from the VRF cache. An old proof
+// missing from the cache is computed again.
+func (t *Tree) rotationMappings(ctx logger.ContextInterface, tr Transaction, oldPeriod, period Period,
+	oldSk *vrf.PrivateKey, pairs []HiddenKeyValuePair) ([]vrf.RotationMapping, error) {
+	keys := make([]Key, len(pairs))
+	for i, hkvPair := range pairs {
+		keys[i] = hkvPair.Key
+	}
+	_, oldProofs, err := t.eng.LookupVRFCacheBatch(ctx, tr, oldPeriod, keys)
+	if err != nil {
+		return nil, err
+	}
+	_, newProofs, err := t.eng.LookupVRFCacheBatch(ctx, tr, period, keys)
+	if err != nil {
+		return nil, err
+	}
 
-	return seqno, td, nil
+	mappings := make([]vrf.RotationMapping, len(pairs))
+	g := new(errgroup.Group)
+	g.SetLimit(32)
+	for i := range keys {
+		i := i
+		g.Go(func() error {
+			if err := ctxErr(ctx); err != nil {
+				return err
+			}
+			oldProof := oldProofs[i]
+			if oldProof == nil {
+				oldProof = t.cfg.ECVRF.Prove(oldSk, keys[i])
+			}
+			oldX, oldY, err := t.cfg.ECVRF.ProofToCurve(oldProof)
+			if err != nil {
+				return err
+			}
+			if newProofs[i] == nil {
+				return fmt.Errorf("no VRF proof of key %v in period %d", keys[i], period)
+			}
+			newX, newY, err := t.cfg.ECVRF.ProofToCurve(newProofs[i])
+			if err != nil {
+				return err
+			}
+			mappings[i] = vrf.RotationMapping{OldX: oldX, OldY: oldY, NewX: newX, NewY: newY}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return mappings, nil
 }
 
 func (t *Tree) finalizeEpoch(ctx logger.ContextInterface, tr Transaction, seqno Seqno, period Period, sk *vrf.PrivateKey, kvps []KeyValuePair, seqnos []Seqno, addOnsHash []byte, fake bool) (td TransparencyDigest, err error) {
@@ -634,6 +883,10 @@ func (t *Tree) finalizeEpochWithHiddenPairs(ctx logger.ContextInterface, tr Tran
 	if err != nil {
 		return nil, err
 	}
+	// The epoch is published from here on.
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
 
 	newRootMetadata, err := t.makeRootMetadata(ctx, tr, seqno, period, newBareRootHash, pk, addOnsHash)
 	if err != nil {
@@ -657,6 +910,9 @@ func (t *Tree) finalizeEpochWithHiddenPairs(ctx logger.ContextInterface, tr Tran
 	if err != nil {
 		return nil, err
 	}
+	if err = t.signTreeHead(ctx, tr, seqno, td); err != nil {
+		return nil, err
+	}
 
 	return td, nil
 }
@@ -726,6 +982,9 @@ func (t *Tree) upsertBinary(ctx logger.ContextInterface, tr Transaction, s Seqno
 
 	var h []byte
 	for _, pair := range hkvPairs {
+		if err := ctxErr(ctx); err != nil {
+			return nil, err
+		}
 		ret, err := t.upsertPair(ctx, tr, s, per, root, pair)
 		if err != nil {
 			return nil, err
@@ -1184,6 +1443,10 @@ type MerkleInclusionProof struct {
 	HtSiblings          [][]byte     `codec:"h"`
 	Entropy             Entropy      `codec:"e"`
 	VRFProof            []byte       `codec:"v"`
+	// VRFBatchPoints are the points U and V of VRFProof, which let a
+	// verifier check many VRF proofs together with ECVRF.BatchVerify. They
+	// are only set by trees with Config.BatchableVRFProofs.
+	VRFBatchPoints []byte `codec:"b"`
 }
 
 // A MerkleExtensionProof proves, given the RootMetadata hashes of two merkle
@@ -1219,6 +1482,9 @@ func (p PosHashPairsInMerkleProofOrder) Swap(i, j int) {
 var _ sort.Interface = PosHashPairsInMerkleProofOrder{}
 
 func (t *Tree) QueryKeyUnsafe(ctx logger.ContextInterface, tr Transaction, epno Seqno, k Key) (bool, interface{}, error) {
+	if err := ctxErr(ctx); err != nil {
+		return false, nil, err
+	}
 	rootMetadata, err := t.eng.LookupRoot(ctx, tr, epno)
 	if err != nil {
 		return false, nil, err
@@ -1246,6 +1512,9 @@ func (t *Tree) QueryKeyUnsafe(ctx logger.ContextInterface, tr Transaction, epno
 }
 
 func (t *Tree) QueryKey(ctx logger.ContextInterface, tr Transaction, epno Seqno, k Key) (bool, interface{}, MerkleInclusionProof, error) {
+	if err := ctxErr(ctx); err != nil {
+		return false, nil, MerkleInclusionProof{}, err
+	}
 	rootMetadata, err := t.eng.LookupRoot(ctx, tr, epno)
 	if err != nil {
 		return false, nil, MerkleInclusionProof{}, err
@@ -1274,7 +1543,7 @@ func (t *Tree) lookupKeyOrHide(ctx logger.ContextInterface, tr Transaction, per
 		return hiddenKey, vrf_proof, nil
 	}
 
-	vrfSk, err := t.eng.LookupVRFPrivateKey(ctx, tr, per)
+	vrfSk, err := t.keys.LookupVRFPrivateKey(ctx, tr, per)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -1318,6 +1587,13 @@ func (t *Tree) getEncodedValueWithInclusionProofOrExclusionProof(ctx logger.Cont
 	}
 
 	proof.VRFProof = vrf_proof
+	if t.cfg.BatchableVRFProofs && !isFakeVRFProof(vrf_proof) {
+		vrfSk, err := t.keys.LookupVRFPrivateKey(ctx, tr, rootMetadata.Period)
+		if err != nil {
+			return nil, MerkleInclusionProof{}, err
+		}
+		_, proof.VRFBatchPoints = t.cfg.ECVRF.ProveBatchable(vrfSk, k)
+	}
 
 	s := rootMetadata.Seqno
 	proof.RootMetadataNoHash = rootMetadata
@@ -1327,6 +1603,9 @@ func (t *Tree) getEncodedValueWithInclusionProofOrExclusionProof(ctx logger.Cont
 	var siblingPosHashPairs []PositionHashPair
 	needMore := true
 	for curr := 1; needMore && curr <= t.cfg.MaxDepth; curr += t.step + 1 {
+		if err := ctxErr(ctx); err != nil {
+			return nil, MerkleInclusionProof{}, err
+		}
 		// The first element is the position at level curr+step on the path from
 		// the root to k (on a complete tree). The next ones are all the
 		// necessary siblings at levels from curr+step to curr (both included)
@@ -1471,6 +1750,9 @@ func (t *Tree) getEncodedValueWithInclusionProofOrExclusionProof(ctx logger.Cont
 }
 
 func (t *Tree) GetExtensionProof(ctx logger.ContextInterface, tr Transaction, fromSeqno, toSeqno Seqno) (proof MerkleExtensionProof, err error) {
+	if err := ctxErr(ctx); err != nil {
+		return MerkleExtensionProof{}, err
+	}
 	if fromSeqno == toSeqno {
 		return MerkleExtensionProof{}, nil
 	}
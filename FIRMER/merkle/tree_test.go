@@ -1,13 +1,16 @@
 package merkle
 
 import (
+	"bytes"
 	"context"
+	crand "crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"testing"
 
 	"FIRMER/logger"
+	"FIRMER/vrf"
 
 	"github.com/stretchr/testify/require"
 )
@@ -820,3 +823,310 @@ func TestRotateSimple(t *testing.T) {
 		require.Equal(t, Seqno(5), proof.AddedAtSeqno)
 	}
 }
+
+func TestTreeWithRFC9381VRF(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	for _, v := range []vrf.ECVRF{vrf.ECVRFP256SHA256TAIRFC9381(), vrf.ECVRFP256SHA256SSWURFC9381()} {
+		cfg, err := NewConfig(SHA512_256Encoder{}, 1, 1, 32, ConstructStringValueContainer, v)
+		require.NoError(t, err)
+		tree, err := NewTree(cfg, 2, NewInMemoryStorageEngine(cfg), RootVersionV1)
+		require.NoError(t, err)
+		verifier := MerkleProofVerifier{cfg: cfg}
+
+		kvps := GenerateInitS(1, 10)
+		s, root, err := tree.Build(ctx, nil, kvps, nil, false)
+		require.NoError(t, err)
+		for _, kvp := range kvps {
+			ok, _, proof, err := tree.QueryKey(ctx, nil, s, kvp.Key)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.NoError(t, verifier.VerifyInclusionProof(ctx, kvp, &proof, root))
+		}
+		missing := GenerateInitS(20, 21)[0].Key
+		ok, _, proof, err := tree.QueryKey(ctx, nil, s, missing)
+		require.NoError(t, err)
+		require.False(t, ok)
+		require.NoError(t, verifier.VerifyExclusionProof(ctx, missing, &proof, root))
+
+		// The RFC suites cannot rotate their key.
+		_, _, err = tree.Rotate(ctx, nil, nil)
+		require.Error(t, err)
+	}
+}
+
+func TestTreeWithP384P521VRF(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	for _, v := range []vrf.ECVRF{vrf.ECVRFP384SHA384TAI(), vrf.ECVRFP521SHA512TAI()} {
+		_, err := NewConfig(SHA512_256Encoder{}, 1, 1, 32, ConstructStringValueContainer, v)
+		require.IsType(t, InvalidConfigError{}, err)
+		cfg, err := NewConfig(SHA512_256Encoder{}, 1, 1, v.Params().OutputLen(), ConstructStringValueContainer, v)
+		require.NoError(t, err)
+		eng := NewInMemoryStorageEngine(cfg)
+		tree, err := NewTree(cfg, 2, eng, RootVersionV1)
+		require.NoError(t, err)
+		verifier := MerkleProofVerifier{cfg: cfg}
+
+		kvps := GenerateInitS(1, 10)
+		_, _, err = tree.Build(ctx, nil, kvps, nil, false)
+		require.NoError(t, err)
+		s, root, err := tree.Rotate(ctx, nil, nil)
+		require.NoError(t, err)
+
+		for _, kvp := range kvps {
+			ok, _, proof, err := tree.QueryKey(ctx, nil, s, kvp.Key)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.NoError(t, verifier.VerifyInclusionProof(ctx, kvp, &proof, root))
+		}
+		missing := GenerateInitS(20, 21)[0].Key
+		ok, _, proof, err := tree.QueryKey(ctx, nil, s, missing)
+		require.NoError(t, err)
+		require.False(t, ok)
+		require.NoError(t, verifier.VerifyExclusionProof(ctx, missing, &proof, root))
+
+		sk1, err := tree.KeyStore().LookupVRFPrivateKey(ctx, nil, 1)
+		require.NoError(t, err)
+		sk2, err := tree.KeyStore().LookupVRFPrivateKey(ctx, nil, 2)
+		require.NoError(t, err)
+		pi, err := eng.LookupVRFRotationProof(ctx, nil, 2)
+		require.NoError(t, err)
+		xs := make([][]byte, len(kvps))
+		for i, kvp := range kvps {
+			xs[i] = kvp.Key
+		}
+		mappings, err := vrf.GenerateMapping(v, sk1, sk2, xs)
+		require.NoError(t, err)
+		require.NoError(t, v.VerifyRotate(sk1.Public(), sk2.Public(), mappings, pi))
+	}
+}
+
+func TestRotateBatches(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	cfg, err := newConfigForTestWithVRF(SHA512_256Encoder{}, 1, 1)
+	require.NoError(t, err)
+	eng := NewInMemoryStorageEngine(cfg)
+	tree, err := NewTree(cfg, 2, eng, RootVersionV1)
+	require.NoError(t, err)
+	require.Error(t, tree.SetRotateBatchSize(0))
+	require.NoError(t, tree.SetRotateBatchSize(4))
+	var progress []RotateProgress
+	tree.SetRotateProgress(func(p RotateProgress) { progress = append(progress, p) })
+	verifier := MerkleProofVerifier{cfg: cfg}
+
+	kvps := GenerateInitS(1, 10)
+	s1, _, err := tree.Build(ctx, nil, kvps, nil, false)
+	require.NoError(t, err)
+
+	// The batches of LookupPairsAfter add up to LookupAllPairs.
+	all, err := eng.LookupAllPairs(ctx, nil, s1, 1)
+	require.NoError(t, err)
+	var batched []HiddenKeyValuePair
+	var after HiddenKey
+	for {
+		pairs, err := eng.LookupPairsAfter(ctx, nil, s1, 1, after, 4)
+		require.NoError(t, err)
+		if len(pairs) == 0 {
+			break
+		}
+		require.LessOrEqual(t, len(pairs), 4)
+		batched = append(batched, pairs...)
+		after = pairs[len(pairs)-1].HiddenKey
+	}
+	require.Equal(t, all, batched)
+
+	s, root, err := tree.Rotate(ctx, nil, nil)
+	require.NoError(t, err)
+	for _, kvp := range kvps {
+		ok, _, proof, err := tree.QueryKey(ctx, nil, s, kvp.Key)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.NoError(t, verifier.VerifyInclusionProof(ctx, kvp, &proof, root))
+	}
+
+	require.Equal(t, []RotateProgress{
+		{RotateRebuild, 4, 0}, {RotateRebuild, 8, 0}, {RotateRebuild, 10, 0},
+		{RotateHash, 4, 10}, {RotateHash, 8, 10}, {RotateHash, 10, 10},
+		{RotateCombine, 4, 10}, {RotateCombine, 8, 10}, {RotateCombine, 10, 10},
+	}, progress)
+
+	// The mappings are in the order of the hidden keys of the old period.
+	sk1, err := tree.KeyStore().LookupVRFPrivateKey(ctx, nil, 1)
+	require.NoError(t, err)
+	sk2, err := tree.KeyStore().LookupVRFPrivateKey(ctx, nil, 2)
+	require.NoError(t, err)
+	pi, err := eng.LookupVRFRotationProof(ctx, nil, 2)
+	require.NoError(t, err)
+	xs := make([][]byte, len(all))
+	for i, pair := range all {
+		xs[i] = pair.Key
+	}
+	mappings, err := vrf.GenerateMapping(cfg.ECVRF, sk1, sk2, xs)
+	require.NoError(t, err)
+	require.NoError(t, cfg.ECVRF.VerifyRotate(sk1.Public(), sk2.Public(), mappings, pi))
+}
+
+func TestCancelledUpdate(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	cfg, err := newConfigForTestWithVRF(SHA512_256Encoder{}, 1, 1)
+	require.NoError(t, err)
+	eng := NewInMemoryStorageEngine(cfg)
+	tree, err := NewTree(cfg, 2, eng, RootVersionV1)
+	require.NoError(t, err)
+	require.NoError(t, tree.SetRotateBatchSize(4))
+	sk, err := GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	// Tree heads are only signed under a deployment's protocol parameters.
+	require.Error(t, tree.SetSigningKey(ctx, sk))
+	cfg.Params = testParams
+	tree, err = NewTree(cfg, 2, eng, RootVersionV1)
+	require.NoError(t, err)
+	require.NoError(t, tree.SetRotateBatchSize(4))
+	require.NoError(t, tree.SetSigningKey(ctx, sk))
+
+	kvps := GenerateInitS(1, 10)
+	s1, td1, err := tree.Build(ctx, nil, kvps, nil, false)
+	require.NoError(t, err)
+	nodes, arrayLen := len(eng.Nodes[1]), len(eng.ArrayDat)
+
+	requireUnchanged := func() {
+		s, root, td, err := tree.GetLatestRoot(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, s1, s)
+		require.Equal(t, Period(1), root.Period)
+		require.Equal(t, td1, td)
+		require.Len(t, eng.Nodes[1], nodes)
+		require.Len(t, eng.ArrayDat, arrayLen)
+		require.Empty(t, eng.Nodes[2])
+		sth, err := tree.LatestSignedTreeHead(ctx, nil)
+		require.NoError(t, err)
+		require.Equal(t, s1, sth.Seqno)
+		_, err = tree.SignedTreeHead(ctx, nil, s1+1)
+		require.Error(t, err)
+		_, proof, err := eng.LookupVRFCache(ctx, nil, 2, kvps[0].Key)
+		require.NoError(t, err)
+		require.Nil(t, proof)
+	}
+
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelled := logger.NewContext(cctx, logger.NewTestLogger(t))
+	_, _, err = tree.Build(cancelled, nil, GenerateInitS(11, 20), nil, false)
+	require.ErrorIs(t, err, context.Canceled)
+	requireUnchanged()
+	_, _, _, err = tree.QueryKey(cancelled, nil, s1, kvps[0].Key)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// Cancel Rotate once it has published some of its epochs.
+	cctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	tree.SetRotateProgress(func(p RotateProgress) {
+		if p.Phase == RotateRebuild && p.Done == 8 {
+			cancel()
+		}
+	})
+	_, _, err = tree.Rotate(logger.NewContext(cctx, logger.NewTestLogger(t)), nil, nil)
+	require.ErrorIs(t, err, context.Canceled)
+	requireUnchanged()
+
+	dctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, _, err = tree.Rotate(logger.NewContext(dctx, logger.NewTestLogger(t)), nil, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	requireUnchanged()
+
+	// The tree is left as it was, and rotates normally.
+	tree.SetRotateProgress(nil)
+	s, root, err := tree.Rotate(ctx, nil, nil)
+	require.NoError(t, err)
+	verifier := MerkleProofVerifier{cfg: cfg}
+	for _, kvp := range kvps {
+		ok, _, proof, err := tree.QueryKey(ctx, nil, s, kvp.Key)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.NoError(t, verifier.VerifyInclusionProof(ctx, kvp, &proof, root))
+	}
+	sth, err := tree.LatestSignedTreeHead(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, s, sth.Seqno)
+}
+
+func TestHideKVPairsPar(t *testing.T) {
+	ctx := NewLoggerContextTodoForTesting(t)
+	cfg, err := newConfigForTestWithVRF(SHA512_256Encoder{}, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, 100, cfg.parallelHideThreshold())
+	cfg.ParallelHideThreshold, cfg.HideWorkers = 2, 3
+	eng := NewInMemoryStorageEngine(cfg)
+	tree, err := NewTree(cfg, 2, eng, RootVersionV1)
+	require.NoError(t, err)
+	sk, err := vrf.GenerateKey(cfg.ECVRF.Params().EC(), crand.Reader)
+	require.NoError(t, err)
+
+	kvps := GenerateInitS(1, 20)
+	seqnos := make([]Seqno, len(kvps))
+	for i := range seqnos {
+		seqnos[i] = Seqno(i)
+	}
+	par, parProofs, err := tree.hideKVPairsPar(ctx, sk, kvps, seqnos, false)
+	require.NoError(t, err)
+	seq, seqProofs, err := tree.hideKVPairsSeq(ctx, sk, kvps, seqnos, false)
+	require.NoError(t, err)
+	require.Equal(t, seqProofs, parProofs)
+	for i := range seq {
+		seq[i].Entropy = par[i].Entropy
+	}
+	require.Equal(t, seq, par)
+
+	// A key which cannot be hidden fails the Build, which leaves no epoch.
+	cfg.ECVRF = badKeyVRF{ECVRF: cfg.ECVRF, bad: kvps[7].Key}
+	tree, err = NewTree(cfg, 2, eng, RootVersionV1)
+	require.NoError(t, err)
+	_, _, err = tree.Build(ctx, nil, kvps, nil, false)
+	require.Error(t, err)
+	_, err = eng.LookupLatestRoot(ctx, nil)
+	require.IsType(t, NoLatestRootFoundError{}, err)
+}
+
+// badKeyVRF returns an invalid proof for the input bad.
+type badKeyVRF struct {
+	vrf.ECVRF
+	bad []byte
+}
+
+func (v badKeyVRF) Prove(sk *vrf.PrivateKey, alpha []byte) []byte {
+	if bytes.Equal(alpha, v.bad) {
+		return nil
+	}
+	return v.ECVRF.Prove(sk, alpha)
+}
+
+func BenchmarkHideKVPairs(b *testing.B) {
+	ctx := logger.NewContext(context.TODO(), logger.NewTestLogger(b))
+	cfg, err := newConfigForTestWithVRF(SHA512_256Encoder{}, 1, 1)
+	require.NoError(b, err)
+	sk, err := vrf.GenerateKey(cfg.ECVRF.Params().EC(), crand.Reader)
+	require.NoError(b, err)
+	kvps := GenerateInitS(1, 256)
+	seqnos := make([]Seqno, len(kvps))
+
+	b.Run("sequential", func(b *testing.B) {
+		tree, err := NewTree(cfg, 2, NewInMemoryStorageEngine(cfg), RootVersionV1)
+		require.NoError(b, err)
+		for i := 0; i < b.N; i++ {
+			_, _, err := tree.hideKVPairsSeq(ctx, sk, kvps, seqnos, false)
+			require.NoError(b, err)
+		}
+	})
+	for _, workers := range []int{1, 4, 16, 64} {
+		cfg := cfg
+		cfg.HideWorkers = workers
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			tree, err := NewTree(cfg, 2, NewInMemoryStorageEngine(cfg), RootVersionV1)
+			require.NoError(b, err)
+			for i := 0; i < b.N; i++ {
+				_, _, err := tree.hideKVPairsPar(ctx, sk, kvps, seqnos, false)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
@@ -41,6 +41,14 @@ func (i *IdentityVRF) Verify(pub *vrf.PublicKey, pi, alpha []byte) ([]byte, erro
 	return alpha, nil
 }
 
+func (i *IdentityVRF) ProveBatchable(sk *vrf.PrivateKey, alpha []byte) ([]byte, []byte) {
+	return alpha, nil
+}
+
+func (i *IdentityVRF) BatchVerify(pubs []*vrf.PublicKey, pis, points, alphas [][]byte) ([][]byte, error) {
+	return alphas, nil
+}
+
 func (i *IdentityVRF) StatefulRotate(sk *vrf.PrivateKey, xs [][]byte, oldProofs [][]byte) (sk2 *vrf.PrivateKey, pi vrf.RotationProof, newProofs [][]byte, err error) {
 
 	skBytes, err := RandomBytes(32)
@@ -50,6 +58,34 @@ func (i *IdentityVRF) StatefulRotate(sk *vrf.PrivateKey, xs [][]byte, oldProofs
 	return vrf.NewKey(*i.Params().Curve, skBytes), vrf.RotationProof{}, nil, nil
 }
 
+func (i *IdentityVRF) NewRotator(sk *vrf.PrivateKey) (vrf.Rotator, error) {
+	skBytes, err := RandomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	return identityRotator{vrf.NewKey(*i.Params().Curve, skBytes)}, nil
+}
+
+type identityRotator struct {
+	sk2 *vrf.PrivateKey
+}
+
+func (r identityRotator) NewKey() *vrf.PrivateKey {
+	return r.sk2
+}
+
+func (r identityRotator) HashMappings(n int, mappings []vrf.RotationMapping) error {
+	return nil
+}
+
+func (r identityRotator) CombineMappings(mappings []vrf.RotationMapping) error {
+	return nil
+}
+
+func (r identityRotator) Proof() (vrf.RotationProof, error) {
+	return vrf.RotationProof{}, nil
+}
+
 func (i *IdentityVRF) Rotate(sk *vrf.PrivateKey, xs [][]byte) (sk2 *vrf.PrivateKey, pi vrf.RotationProof, err error) {
 
 	skBytes, err := RandomBytes(32)
@@ -0,0 +1,90 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"FIRMER/msgpack"
+)
+
+// Protocol messages are sent over any io.ReadWriter, or a channel of []byte,
+// as frames: a 4 byte big endian length, one byte of MessageType, then the
+// msgpack encoding of the message.
+
+// MessageType tags each frame with the message it carries.
+type MessageType uint8
+
+const (
+	MessageTypePairingCommit MessageType = iota + 1
+	MessageTypePairingNonce
+	MessageTypePairingReveal
+	MessageTypeOPRFEvaluation
+	MessageTypeAKEHello
+	MessageTypeAKEConfirm
+)
+
+// maxFrameLength bounds the size of a frame a peer will read.
+const maxFrameLength = 1 << 16
+
+// EncodeMessage encodes m as one frame of type typ.
+func EncodeMessage(typ MessageType, m interface{}) ([]byte, error) {
+	body, err := msgpack.EncodeCanonical(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(body)+1 > maxFrameLength {
+		return nil, fmt.Errorf("message of %d bytes is too long", len(body))
+	}
+	frame := make([]byte, 5+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)+1))
+	frame[4] = byte(typ)
+	copy(frame[5:], body)
+	return frame, nil
+}
+
+// DecodeMessage checks that frame is exactly one frame of type typ and
+// decodes it into m.
+func DecodeMessage(frame []byte, typ MessageType, m interface{}) error {
+	if len(frame) < 5 {
+		return fmt.Errorf("frame of %d bytes is too short", len(frame))
+	}
+	if n := binary.BigEndian.Uint32(frame[:4]); int64(n) != int64(len(frame)-4) {
+		return fmt.Errorf("invalid frame length %d", n)
+	}
+	return decodeBody(frame[4], frame[5:], typ, m)
+}
+
+func decodeBody(got byte, body []byte, typ MessageType, m interface{}) error {
+	if MessageType(got) != typ {
+		return NewUnexpectedMessageError(typ, MessageType(got))
+	}
+	return msgpack.DecodeAll(body, msgpack.CodecHandle(), m)
+}
+
+// WriteMessage encodes m and writes it as one frame of type typ.
+func WriteMessage(w io.Writer, typ MessageType, m interface{}) error {
+	frame, err := EncodeMessage(typ, m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadMessage reads one frame, checks that it has type typ and decodes it into m.
+func ReadMessage(r io.Reader, typ MessageType, m interface{}) error {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n < 1 || n > maxFrameLength {
+		return fmt.Errorf("invalid frame length %d", n)
+	}
+	body := make([]byte, n-1)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	return decodeBody(hdr[4], body, typ, m)
+}
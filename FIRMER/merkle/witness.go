@@ -0,0 +1,71 @@
+package merkle
+
+import (
+	"fmt"
+	"sync"
+
+	"FIRMER/logger"
+)
+
+// Witness is a minimal local transparency-log witness. It remembers the
+// latest checkpoint it accepted for one log, and only accepts a newer
+// checkpoint along with an extension proof from the previous one. Accepted
+// checkpoints are countersigned with the witness key, so that clients can
+// require both the log and the witness signatures.
+type Witness struct {
+	sync.Mutex
+
+	origin   string
+	log      *NoteVerifier
+	signer   *NoteSigner
+	verifier MerkleProofVerifier
+
+	latest    Checkpoint
+	hasLatest bool
+}
+
+// NewWitness returns a witness for the log with the given origin and
+// verifier key. The first checkpoint it sees is trusted without a proof.
+func NewWitness(cfg Config, origin string, log *NoteVerifier, signer *NoteSigner) *Witness {
+	return &Witness{origin: origin, log: log, signer: signer, verifier: NewMerkleProofVerifier(cfg)}
+}
+
+// Latest returns the latest accepted checkpoint, if any.
+func (w *Witness) Latest() (Checkpoint, bool) {
+	w.Lock()
+	defer w.Unlock()
+	return w.latest, w.hasLatest
+}
+
+// Update verifies note, signed by the log, and proof, an extension proof from
+// the latest accepted checkpoint to the one in note. On success the checkpoint
+// becomes the latest one and the note is returned with the witness signature
+// added.
+func (w *Witness) Update(ctx logger.ContextInterface, note []byte, proof MerkleExtensionProof) ([]byte, error) {
+	c, err := OpenCheckpoint(note, w.origin, w.log)
+	if err != nil {
+		return nil, NewCheckpointRejectedError(err)
+	}
+	if c.Size < 1 {
+		return nil, NewCheckpointRejectedError(fmt.Errorf("empty tree"))
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	if w.hasLatest {
+		if c.Size < w.latest.Size {
+			return nil, NewCheckpointRejectedError(fmt.Errorf("size %d is older than the latest size %d", c.Size, w.latest.Size))
+		}
+		if err := w.verifier.VerifyExtensionProof(ctx, &proof, w.latest.Size, w.latest.Hash, c.Size, c.Hash); err != nil {
+			return nil, NewCheckpointRejectedError(fmt.Errorf("invalid extension proof from size %d to %d: %v", w.latest.Size, c.Size, err))
+		}
+	}
+
+	cosigned, err := CosignNote(note, w.signer)
+	if err != nil {
+		return nil, err
+	}
+	w.latest = c
+	w.hasLatest = true
+	return cosigned, nil
+}
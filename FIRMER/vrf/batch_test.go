@@ -0,0 +1,185 @@
+package vrf
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestMSM(t *testing.T) {
+	for _, ec := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		c := newJacobianCurve(ec)
+		for _, n := range []int{1, 2, 5, 40} {
+			var points []affinePoint
+			var scalars []*big.Int
+			var wantX, wantY *big.Int
+			for i := 0; i < n; i++ {
+				k, _ := rand.Int(rand.Reader, ec.Params().N)
+				s, _ := rand.Int(rand.Reader, ec.Params().N)
+				if i == 1 {
+					// The generator, so that doublings happen in the buckets.
+					k, s = one, big.NewInt(3)
+				}
+				x, y := ec.ScalarBaseMult(k.Bytes())
+				points = append(points, c.affine(x, y))
+				scalars = append(scalars, s)
+				sx, sy := ec.ScalarMult(x, y, s.Bytes())
+				if wantX == nil {
+					wantX, wantY = sx, sy
+				} else {
+					wantX, wantY = ec.Add(wantX, wantY, sx, sy)
+				}
+			}
+			sum := c.msm(points, scalars)
+			if x, y := c.toAffine(&sum); x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+				t.Errorf("%v: msm() of %d points: (%x, %x), want (%x, %x)", ec.Params().Name, n, x, y, wantX, wantY)
+			}
+		}
+	}
+}
+
+// batchForTesting returns n proofs of distinct inputs under two keys, with
+// their points.
+func batchForTesting(t testing.TB, v ECVRF, n int) (sks []*PrivateKey, pubs []*PublicKey, pis, points, alphas [][]byte) {
+	for i := 0; i < 2; i++ {
+		sk, err := GenerateKey(v.Params().EC(), rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey(): %v", err)
+		}
+		sks = append(sks, sk)
+	}
+	for i := 0; i < n; i++ {
+		sk := sks[i%2]
+		alpha := []byte(fmt.Sprintf("label %d", i))
+		pi, pts := v.ProveBatchable(sk, alpha)
+		pubs = append(pubs, sk.Public())
+		pis = append(pis, pi)
+		points = append(points, pts)
+		alphas = append(alphas, alpha)
+	}
+	return sks, pubs, pis, points, alphas
+}
+
+func copyBytesSlice(bs [][]byte) [][]byte {
+	c := make([][]byte, len(bs))
+	for i := range bs {
+		c[i] = append([]byte{}, bs[i]...)
+	}
+	return c
+}
+
+func TestBatchVerify(t *testing.T) {
+	for _, v := range []ECVRF{ECVRFP256SHA256SWU(), ECVRFP256SHA256TAIRFC9381(), ECVRFP384SHA384TAI()} {
+		// Above the threshold the proofs with points are checked together,
+		// below it one by one.
+		for _, n := range []int{v.Params().batchThreshold() + 2, v.Params().batchThreshold() - 1} {
+			_, pubs, pis, points, alphas := batchForTesting(t, v, n)
+			// A proof without points is verified on its own.
+			points[1] = nil
+
+			betas, err := v.BatchVerify(pubs, pis, points, alphas)
+			if err != nil {
+				t.Fatalf("BatchVerify(): %v", err)
+			}
+			for i := range pis {
+				beta, err := v.Verify(pubs[i], pis[i], alphas[i])
+				if err != nil {
+					t.Fatalf("Verify(): %v", err)
+				}
+				if !bytes.Equal(betas[i], beta) {
+					t.Errorf("BatchVerify()[%d]: %x, want %x", i, betas[i], beta)
+				}
+			}
+			if _, err := v.BatchVerify(pubs, pis, nil, alphas); err != nil {
+				t.Errorf("BatchVerify() without points: %v", err)
+			}
+			if _, err := v.BatchVerify(pubs, pis, points, alphas[1:]); err == nil {
+				t.Errorf("BatchVerify() with missing inputs succeeded")
+			}
+
+			ptLen := int(v.Params().ptLen)
+			for _, tc := range []struct {
+				name   string
+				modify func(pis, points, alphas [][]byte)
+			}{
+				// c still matches U and V, so only the combined equation fails.
+				{"s", func(pis, points, alphas [][]byte) { pis[0][v.Params().proofLen()-1] ^= 1 }},
+				{"U", func(pis, points, alphas [][]byte) { points[0][1] ^= 1 }},
+				{"V", func(pis, points, alphas [][]byte) { points[0][ptLen+ptLen/2] ^= 1 }},
+				{"points", func(pis, points, alphas [][]byte) { points[0] = points[2] }},
+				{"short points", func(pis, points, alphas [][]byte) { points[0] = points[0][:ptLen] }},
+				{"alpha", func(pis, points, alphas [][]byte) { alphas[0] = []byte("other") }},
+				{"without points", func(pis, points, alphas [][]byte) { points[0] = nil; alphas[0] = []byte("other") }},
+			} {
+				pis2, points2, alphas2 := copyBytesSlice(pis), copyBytesSlice(points), append([][]byte{}, alphas...)
+				points2[1] = nil
+				tc.modify(pis2, points2, alphas2)
+				_, err := v.BatchVerify(pubs, pis2, points2, alphas2)
+				var bad *BatchVerifyError
+				if !errors.As(err, &bad) || bad.Index != 0 {
+					t.Errorf("BatchVerify() of %d proofs with modified %v: %v, want error for proof 0", n, tc.name, err)
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyRejectsPoints(t *testing.T) {
+	v := ECVRFP256SHA256SWU()
+	sk := NewKey(v.Params().EC(), bytes.Repeat([]byte{0x2a}, 32))
+	alpha := []byte("alice")
+	pi, points := v.ProveBatchable(sk, alpha)
+	if !bytes.Equal(pi, v.Prove(sk, alpha)) {
+		t.Fatalf("ProveBatchable() differs from Prove()")
+	}
+	// Verify only takes the RFC proof, so the points cannot be appended to
+	// it, whatever they are.
+	for i := 0; i < len(points); i++ {
+		tail := append([]byte{}, points...)
+		tail[i] ^= 1
+		for _, extended := range [][]byte{append(append([]byte{}, pi...), points...), append(append([]byte{}, pi...), tail...)} {
+			if _, err := v.Verify(sk.Public(), extended, alpha); err == nil {
+				t.Fatalf("Verify() of a proof followed by points succeeded")
+			}
+			if _, err := v.BatchVerify([]*PublicKey{sk.Public()}, [][]byte{extended}, nil, [][]byte{alpha}); err == nil {
+				t.Fatalf("BatchVerify() of a proof followed by points succeeded")
+			}
+		}
+		// Below the threshold, BatchVerify still checks the points.
+		if _, err := v.BatchVerify([]*PublicKey{sk.Public()}, [][]byte{pi}, [][]byte{tail}, [][]byte{alpha}); err == nil {
+			t.Fatalf("BatchVerify() with byte %d of the points flipped succeeded", i)
+		}
+	}
+}
+
+func benchmarkVerify(b *testing.B, v ECVRF, n int, batch bool) {
+	_, pubs, pis, points, alphas := batchForTesting(b, v, n)
+	b.ResetTimer()
+	for j := 0; j < b.N; j++ {
+		if batch {
+			if _, err := v.BatchVerify(pubs, pis, points, alphas); err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+		for i := range pis {
+			if _, err := v.Verify(pubs[i], pis[i], alphas[i]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	for _, v := range []ECVRF{ECVRFP256SHA256SWU(), ECVRFP384SHA384TAI(), ECVRFP521SHA512TAI()} {
+		for _, n := range []int{4, 16, 128, 256} {
+			name := fmt.Sprintf("%v/%d", v.Params().EC().Params().Name, n)
+			b.Run(name+"/individual", func(b *testing.B) { benchmarkVerify(b, v, n, false) })
+			b.Run(name+"/batch", func(b *testing.B) { benchmarkVerify(b, v, n, true) })
+		}
+	}
+}
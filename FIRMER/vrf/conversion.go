@@ -16,7 +16,9 @@ package vrf
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"math/big"
+	"math/bits"
 )
 
 // i2osp converts a nonnegative integer to an octet string of a specified length.
@@ -84,19 +86,29 @@ func bits2int(b []byte, qlen int) *big.Int {
 // of octets, hence the name int2octets.
 //
 // https://tools.ietf.org/html/rfc6979#section-2.3.3
+//
+// x is the secret scalar, so its octets are selected in constant time. The
+// scalar of a rotated key is not reduced and is truncated to the rlen octets
+// following its leading zero octets, which are all in its top word.
 func int2octets(x *big.Int, qlen int) []byte {
-	rlen := 8 * ((qlen + 7) >> 3) // rlen = 8*ceil(qlen/8)
-	b := x.Bytes()
-	blen := len(b) * 8
-	if blen < rlen {
-		// left pad with rlen - blen bits
-		b = append(make([]byte, (rlen-blen)/8), b...)
+	rlen := (qlen + 7) >> 3 // rlen = 8*ceil(qlen/8), in octets
+	b := secretBytes(x)
+	if len(b) <= rlen {
+		// left pad with zeros
+		return append(make([]byte, rlen-len(b)), b...)
+	}
+	lead, nonzero := 0, 0
+	for i := 0; i < bits.UintSize/8; i++ {
+		nonzero |= subtle.ConstantTimeByteEq(b[i], 0) ^ 1
+		lead += nonzero ^ 1
 	}
-	if blen > rlen {
-		// truncate to blen bits
-		b = b[:rlen/8]
+	extra := len(b) - rlen
+	offset := subtle.ConstantTimeSelect(subtle.ConstantTimeLessOrEq(lead, extra), lead, extra)
+	out := make([]byte, rlen)
+	for i := 0; i < bits.UintSize/8 && i <= extra; i++ {
+		subtle.ConstantTimeCopy(subtle.ConstantTimeEq(int32(i), int32(offset)), out, b[i:i+rlen])
 	}
-	return b
+	return out
 }
 
 //  bits2octets takes as input a sequence of blen bits and outputs a sequence
@@ -0,0 +1,140 @@
+package vrf
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"math/bits"
+	"sync"
+)
+
+// The secret scalars of the P-256 suites, the key x, the nonce k and the
+// rotation factors, only go through the constant-time arithmetic of this
+// file. They are held as big.Int values, but converted with FillBytes and
+// SetBytes, whose running time depends on the length of the integer only.
+//
+// Point multiplication uses crypto/elliptic, which is constant time for P-256
+// scalars of at most 32 bytes, but reduces longer ones with big.Int. The
+// scalars are reduced here first.
+
+// ctCurve implements constant-time arithmetic modulo the group order q.
+type ctCurve struct {
+	q *montField
+}
+
+var (
+	p256CTOnce sync.Once
+	p256CT     *ctCurve
+)
+
+// ctCurveOf returns the constant-time implementation of ec, or nil if ec is
+// not P-256.
+func ctCurveOf(ec elliptic.Curve) *ctCurve {
+	if ec == nil || ec.Params() != elliptic.P256().Params() {
+		return nil
+	}
+	p256CTOnce.Do(func() { p256CT = &ctCurve{q: newMontField(elliptic.P256().Params().N)} })
+	return p256CT
+}
+
+// reduce returns the big endian integer k mod q, in as many bytes as q.
+func (c *ctCurve) reduce(k []byte) []byte {
+	K := c.q.fromWide(k)
+	K = c.q.fromMont(&K)
+	return c.q.bytes(&K)
+}
+
+// secretBytes returns x as a big endian integer of a length that depends on
+// the length of x only.
+func secretBytes(x *big.Int) []byte {
+	return x.FillBytes(make([]byte, len(x.Bits())*bits.UintSize/8))
+}
+
+// secretScalarMult returns k*(x, y) for a secret scalar k.
+func secretScalarMult(ec elliptic.Curve, x, y *big.Int, k []byte) (*big.Int, *big.Int) {
+	if c := ctCurveOf(ec); c != nil {
+		k = c.reduce(k)
+	}
+	return ec.ScalarMult(x, y, k)
+}
+
+// secretScalarBaseMult returns k*B for a secret scalar k.
+func secretScalarBaseMult(ec elliptic.Curve, k []byte) (*big.Int, *big.Int) {
+	if c := ctCurveOf(ec); c != nil {
+		k = c.reduce(k)
+	}
+	return ec.ScalarBaseMult(k)
+}
+
+// secretMulAdd returns (a + b*x) mod q for a secret x or a. b is public.
+func secretMulAdd(ec elliptic.Curve, a, b, x *big.Int) *big.Int {
+	c := ctCurveOf(ec)
+	if c == nil {
+		s := new(big.Int).Mul(b, x)
+		s.Add(s, a)
+		return s.Mod(s, ec.Params().N)
+	}
+	q := c.q
+	A, B, X := q.fromWide(secretBytes(a)), q.fromWide(b.Bytes()), q.fromWide(secretBytes(x))
+	q.mul(&B, &B, &X)
+	q.add(&A, &A, &B)
+	A = q.fromMont(&A)
+	return new(big.Int).SetBytes(q.bytes(&A))
+}
+
+// secretReduce returns x mod q for a secret x.
+func secretReduce(ec elliptic.Curve, x *big.Int) *big.Int {
+	c := ctCurveOf(ec)
+	if c == nil {
+		return new(big.Int).Mod(x, ec.Params().N)
+	}
+	return new(big.Int).SetBytes(c.reduce(secretBytes(x)))
+}
+
+// secretMul returns the product x*y of secret integers, which is not reduced.
+func secretMul(x, y *big.Int) *big.Int {
+	xb, yb := secretBytes(x), secretBytes(y)
+	xl, yl := bytesToWords(xb), bytesToWords(yb)
+	z := make([]uint64, len(xl)+len(yl))
+	for i, yi := range yl {
+		var c uint64
+		for j, xj := range xl {
+			hi, lo := bits.Mul64(xj, yi)
+			var cc uint64
+			lo, cc = bits.Add64(lo, z[i+j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, c, 0)
+			hi += cc
+			z[i+j], c = lo, hi
+		}
+		z[i+len(xl)] = c
+	}
+	b := make([]byte, 8*len(z))
+	for i, w := range z {
+		for j := 0; j < 8; j++ {
+			b[len(b)-1-8*i-j] = byte(w >> (8 * j))
+		}
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+// bytesToWords returns the big endian integer b as little endian words.
+func bytesToWords(b []byte) []uint64 {
+	w := make([]uint64, (len(b)+7)/8)
+	for i := range b {
+		w[i/8] |= uint64(b[len(b)-1-i]) << (8 * (i % 8))
+	}
+	return w
+}
+
+// ctInRange reports whether 1 <= k < q, for a secret k of at most as many
+// words as q.
+func ctInRange(k, q *big.Int) bool {
+	b := make([]byte, len(q.Bits())*bits.UintSize/8)
+	kw, qw := bytesToWords(k.FillBytes(b)), bytesToWords(q.FillBytes(make([]byte, len(b))))
+	var nonzero, borrow uint64
+	for i := range kw {
+		nonzero |= kw[i]
+		_, borrow = bits.Sub64(kw[i], qw[i], borrow)
+	}
+	return borrow&((nonzero|-nonzero)>>63) == 1
+}
@@ -0,0 +1,145 @@
+package vrf
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func testScalars(t *testing.T, N *big.Int) []*big.Int {
+	t.Helper()
+	wide, _ := rand.Int(rand.Reader, new(big.Int).Lsh(one, 1000))
+	ks := []*big.Int{
+		big.NewInt(0), big.NewInt(1), big.NewInt(15), big.NewInt(16),
+		new(big.Int).Sub(N, one), N, new(big.Int).Add(N, one),
+		new(big.Int).Sub(new(big.Int).Lsh(one, 256), one), wide,
+	}
+	for i := 0; i < 8; i++ {
+		k, err := rand.Int(rand.Reader, N)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func TestCTScalarMult(t *testing.T) {
+	ec := elliptic.P256()
+	N := ec.Params().N
+	Px, Py := ec.ScalarBaseMult([]byte{42})
+	for _, k := range testScalars(t, N) {
+		x, y := secretScalarBaseMult(ec, secretBytes(k))
+		wantX, wantY := ec.ScalarBaseMult(k.Bytes())
+		if x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+			t.Errorf("secretScalarBaseMult(%x): (%x, %x), want (%x, %x)", k, x, y, wantX, wantY)
+		}
+		x, y = secretScalarMult(ec, Px, Py, secretBytes(k))
+		wantX, wantY = ec.ScalarMult(Px, Py, k.Bytes())
+		if x.Cmp(wantX) != 0 || y.Cmp(wantY) != 0 {
+			t.Errorf("secretScalarMult(%x): (%x, %x), want (%x, %x)", k, x, y, wantX, wantY)
+		}
+	}
+}
+
+func TestCTScalarArithmetic(t *testing.T) {
+	ec := elliptic.P256()
+	N := ec.Params().N
+	ks := testScalars(t, N)
+	for i, a := range ks {
+		b, x := ks[(i+3)%len(ks)], ks[(i+5)%len(ks)]
+		want := new(big.Int).Mul(b, x)
+		if got := secretMul(b, x); got.Cmp(want) != 0 {
+			t.Errorf("secretMul(%x, %x): %x, want %x", b, x, got, want)
+		}
+		want.Add(want, a).Mod(want, N)
+		if got := secretMulAdd(ec, a, b, x); got.Cmp(want) != 0 {
+			t.Errorf("secretMulAdd(%x, %x, %x): %x, want %x", a, b, x, got, want)
+		}
+		if got, want := secretReduce(ec, a), new(big.Int).Mod(a, N); got.Cmp(want) != 0 {
+			t.Errorf("secretReduce(%x): %x, want %x", a, got, want)
+		}
+		if a.BitLen() <= N.BitLen() {
+			want := a.Sign() > 0 && a.Cmp(N) < 0
+			if got := ctInRange(a, N); got != want {
+				t.Errorf("ctInRange(%x): %v, want %v", a, got, want)
+			}
+		}
+	}
+}
+
+func TestInt2Octets(t *testing.T) {
+	// The reference implementation: x.Bytes() padded or truncated to rlen.
+	int2octetsVarTime := func(x *big.Int, qlen int) []byte {
+		rlen := (qlen + 7) / 8
+		b := x.Bytes()
+		if len(b) < rlen {
+			return append(make([]byte, rlen-len(b)), b...)
+		}
+		return b[:rlen]
+	}
+	for _, x := range testScalars(t, elliptic.P256().Params().N) {
+		for _, shift := range []uint{0, 4, 8, 60, 256} {
+			x := new(big.Int).Lsh(x, shift)
+			for _, qlen := range []int{256, 384, 521} {
+				if got, want := int2octets(x, qlen), int2octetsVarTime(x, qlen); !bytes.Equal(got, want) {
+					t.Errorf("int2octets(%x, %d): %x, want %x", x, qlen, got, want)
+				}
+			}
+		}
+	}
+}
+
+// Proofs computed before the P-256 suites moved to constant-time arithmetic,
+// for a small key and for an unreduced key like those of rotated trees.
+func TestProveConstantTimeVectors(t *testing.T) {
+	N := elliptic.P256().Params().N
+	wide := new(big.Int).Mul(N, big.NewInt(0x1234567))
+	wide.Add(wide, big.NewInt(0x2a2a2a))
+	wide.Mul(wide, new(big.Int).Lsh(N, 3))
+	wide.Add(wide, big.NewInt(7))
+	for _, tc := range []struct {
+		v  ECVRF
+		d  *big.Int
+		pi string
+	}{
+		{ECVRFP256SHA256TAI(), big.NewInt(0x2a), "037b3fecd605b54547a81d7cddc53dbc8ea6eaa0cbf6b0f31246e931a31a29f4ee151e367adfab353a42518cebd0cc6888c3ab1f47502a0cebb399106366f01cf238047851e3dfe5114f29ed8a90643cf3"},
+		{ECVRFP256SHA256TAI(), wide, "03d2b6de9cda793e03aba2176a134db3772abf317ae2bc5ccd8d2cb43daceebea6602468c66d96158b0a84947aebf68e135b3c9f6a7511cffa28e7eda966dbbf8eb6a2fd9af62e7ba7cfd159177cc1cfb8"},
+		{ECVRFP256SHA256SWU(), big.NewInt(0x2a), "025c21da038266462218b8e75bd30488c3fe14f1a2b2ffcedf4eb4ebfc447db7031a0975c884c39bc8def313e7225ebdead6b330af05087bbb948c8c35196d26abea7d28c360f6c2f118456cd1eda60597"},
+		{ECVRFP256SHA256SWU(), wide, "02543d614a5cee1a769ac6cefa19c6e3fd1104b07df506aa6e8100a8f534071c0a2691869473af996f924885313f95cc2d4669fb3bb4fde5b0d8e8bd6b2754f28b370b72d4f48594700a6838cff184b9ab"},
+		{ECVRFP256SHA256SSWURFC9381(), big.NewInt(0x2a), "03c2a7cbea62f3101cf39dab065803acf514e0e39c7d0f52410865e715bd77cc82e613e822970f75b87e6ebcedb832f1a87d6199fe96d67209bb70e7a495efa0a92e6c357a5f3cf144f3914a2676fcb0f9"},
+		{ECVRFP256SHA256SSWURFC9381(), wide, "03218c7894c46cf5f6d5ef255859e7400cf8938a258389cb40b8a284e9fe04ca7d768c9c5bc181d48eb815fbb305d7f209a1f26c57eda641c443f2841e845e1ea47d4e4d31c7597af8854e1c139cfcd293"},
+	} {
+		sk := NewKey(tc.v.Params().EC(), tc.d.Bytes())
+		if got := hex.EncodeToString(tc.v.Prove(sk, []byte("alice"))); got != tc.pi {
+			t.Errorf("Prove() with key %x: %v, want %v", tc.d, got, tc.pi)
+		}
+	}
+}
+
+func BenchmarkProve(b *testing.B) {
+	N := elliptic.P256().Params().N
+	// A rotated key is not reduced mod q.
+	wide := new(big.Int).Add(new(big.Int).Mul(N, big.NewInt(0x1234567)), big.NewInt(0x2a))
+	for _, tc := range []struct {
+		name string
+		v    ECVRF
+	}{
+		{"TAI", ECVRFP256SHA256TAI()},
+		{"SWU", ECVRFP256SHA256SWU()},
+		{"SSWU", ECVRFP256SHA256SSWURFC9381()},
+	} {
+		for _, d := range []*big.Int{big.NewInt(0x2a), wide} {
+			sk := NewKey(tc.v.Params().EC(), d.Bytes())
+			b.Run(fmt.Sprintf("%s/%d-bit-key", tc.name, d.BitLen()), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					tc.v.Prove(sk, []byte("alice"))
+				}
+			})
+		}
+	}
+}
@@ -16,6 +16,7 @@ package vrf
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -34,6 +35,10 @@ var initonce sync.Once
 func initAll() {
 	initP256SHA256TAI()
 	initP256SHA256SWU()
+	initP256SHA256TAIRFC9381()
+	initP256SHA256SSWURFC9381()
+	initP384SHA384TAI()
+	initP521SHA512TAI()
 }
 
 // ECVRFP256SHA256TAI returns a elliptic curve based VRF instantiated with
@@ -71,8 +76,17 @@ func (priv *PrivateKey) Bytes() []byte {
 	return priv.d.Bytes()
 }
 
+// GenerateKey returns a random private key for curve.
+func GenerateKey(curve elliptic.Curve, rand io.Reader) (*PrivateKey, error) {
+	sk, _, _, err := elliptic.GenerateKey(curve, rand)
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(curve, sk), nil
+}
+
 func NewKey(curve elliptic.Curve, sk []byte) *PrivateKey {
-	x, y := curve.ScalarBaseMult(sk)
+	x, y := secretScalarBaseMult(curve, sk)
 	return &PrivateKey{
 		PublicKey: PublicKey{Curve: curve, X: x, Y: y}, // VRF public key Y = x*B
 		d:         new(big.Int).SetBytes(sk),           // Use SK to derive the VRF secret scalar x
@@ -106,7 +120,23 @@ type ECVRF interface {
 	// Verify that beta is the correct VRF hash of alpha using PublicKey pub.
 	Verify(pub *PublicKey, pi, alpha []byte) (beta []byte, err error)
 
+	// ProveBatchable returns the proof of Prove, and the points U and V
+	// computed by Verify, which BatchVerify needs to check proofs together.
+	// The points are not part of the proof: Verify only takes pi.
+	ProveBatchable(sk *PrivateKey, alpha []byte) (pi, points []byte)
+
+	// BatchVerify verifies the proofs pis of the inputs alphas under the
+	// public keys pubs, and returns their outputs. points[i] are the points
+	// of pis[i] from ProveBatchable, or nil; points may be nil. If a proof
+	// is invalid, it returns a *BatchVerifyError with the index of the
+	// first one.
+	BatchVerify(pubs []*PublicKey, pis, points, alphas [][]byte) (betas [][]byte, err error)
+
 	StatefulRotate(sk *PrivateKey, xs [][]byte, oldProofs [][]byte) (sk2 *PrivateKey, pi RotationProof, newProofs [][]byte, err error)
+
+	// NewRotator returns a Rotator of sk, to rotate a key set in batches.
+	NewRotator(sk *PrivateKey) (Rotator, error)
+
 	Rotate(sk *PrivateKey, xs [][]byte) (sk2 *PrivateKey, pi RotationProof, err error)
 	VerifyRotate(pk *PublicKey, pk2 *PublicKey, mapping []RotationMapping, pi RotationProof) (err error)
 }
@@ -123,6 +153,11 @@ type ECVRFParams struct {
 	cofactor *big.Int       // The number of points on EC divided by the prime order of the group.
 	hash     crypto.Hash    // Cryptographic hash function.
 	aux      ECVRFAux       // Suite specific helper functions.
+
+	// rfc9381 selects the RFC 9381 variant of the generic algorithms, which
+	// add the public key to the challenge and a trailing zero octet to the
+	// challenge and output hashes.
+	rfc9381 bool
 }
 
 func (p *ECVRFParams) EC() elliptic.Curve {
@@ -132,6 +167,15 @@ func (p *ECVRFParams) EC() elliptic.Curve {
 	return p.ec
 }
 
+// OutputLen returns the length of the VRF hash output beta, or 0 for
+// parameters without a hash function.
+func (p *ECVRFParams) OutputLen() int {
+	if p.hash == 0 {
+		return 0
+	}
+	return p.hash.Size()
+}
+
 // ECVRFAux contains auxiliary functions necesary for the computation of ECVRF.
 type ECVRFAux interface {
 	// PointToString converts an EC point to an octet string.
@@ -144,7 +188,7 @@ type ECVRFAux interface {
 	// IntToString converts a nonnegative integer a to to octet string of length rLen.
 	IntToString(x *big.Int, rLen uint) []byte
 
-	// ArbitraryStringToPoint converts an arbitrary 32 byte string s to an EC point.
+	// ArbitraryStringToPoint converts an arbitrary string s of at most fieldLen bytes to an EC point.
 	ArbitraryStringToPoint(s []byte) (Px, Py *big.Int, err error)
 
 	// HashToCurve is a collision resistant hash of VRF input alpha to H, an EC point in G.
@@ -159,6 +203,12 @@ type ECVRFAux interface {
 // alpha - input alpha, an octet string
 // Returns pi - VRF proof, octet string of length ptLen+n+qLen
 func (p ECVRFParams) Prove(sk *PrivateKey, alpha []byte) []byte {
+	pi, _, _, _, _ := p.prove(sk, alpha)
+	return pi
+}
+
+// prove returns pi along with the points U and V of the proof.
+func (p ECVRFParams) prove(sk *PrivateKey, alpha []byte) (pi []byte, Ux, Uy, Vx, Vy *big.Int) {
 	// 1.  Use SK to derive the VRF secret scalar x and the VRF public key Y = x*B
 	// 2.  H = ECVRF_hash_to_curve(suite_string, Y, alpha_string)
 	Hx, Hy := p.aux.HashToCurve(sk.Public(), alpha) // suite_string is implicitly used in HashToCurve
@@ -167,28 +217,26 @@ func (p ECVRFParams) Prove(sk *PrivateKey, alpha []byte) []byte {
 	hString := p.aux.PointToString(Hx, Hy)
 
 	// 4.  Gamma = x*H
-	Gx, Gy := p.ec.ScalarMult(Hx, Hy, sk.d.Bytes())
+	Gx, Gy := secretScalarMult(p.ec, Hx, Hy, secretBytes(sk.d))
 
 	// 5.  k = ECVRF_nonce_generation(SK, h_string)
 	k := p.aux.GenerateNonce(sk, hString)
 
 	// 6.  c = ECVRF_hash_points(H, Gamma, k*B, k*H)
-	Ux, Uy := p.ec.ScalarBaseMult(k.Bytes())
-	Vx, Vy := p.ec.ScalarMult(Hx, Hy, k.Bytes())
-	c := p.hashPoints(Hx, Hy, Gx, Gy, Ux, Uy, Vx, Vy)
+	Ux, Uy = secretScalarBaseMult(p.ec, secretBytes(k))
+	Vx, Vy = secretScalarMult(p.ec, Hx, Hy, secretBytes(k))
+	c := p.challenge(sk.Public(), Hx, Hy, Gx, Gy, Ux, Uy, Vx, Vy)
 
 	// 7.  s = (k + c*x) mod q
-	s := new(big.Int).Mul(c, sk.d)
-	s.Add(k, s)
-	s.Mod(s, p.ec.Params().N)
+	s := secretMulAdd(p.ec, k, c, sk.d)
 
 	// 8.  pi_string = point_to_string(Gamma) || int_to_string(c, n) || int_to_string(s, qLen)
-	pi := new(bytes.Buffer)
-	pi.Write(p.aux.PointToString(Gx, Gy))
-	pi.Write(p.aux.IntToString(c, p.fieldLen/2)) // 2n = fieldLen
-	pi.Write(p.aux.IntToString(s, p.qLen))
+	piBuf := new(bytes.Buffer)
+	piBuf.Write(p.aux.PointToString(Gx, Gy))
+	piBuf.Write(p.aux.IntToString(c, p.fieldLen/2)) // 2n = fieldLen
+	piBuf.Write(p.aux.IntToString(s, p.qLen))
 
-	return pi.Bytes()
+	return piBuf.Bytes(), Ux, Uy, Vx, Vy
 }
 
 // ProofToHash returns VRF hash output beta from VRF proof pi.
@@ -214,7 +262,13 @@ func (p ECVRFParams) ProofToHash(pi []byte) (beta []byte, err error) {
 	// 5.  beta_string = Hash(suite_string || three_string || point_to_string(cofactor * Gamma))
 	h := p.hash.New()
 	h.Write([]byte{p.suite, 0x03})
-	h.Write(p.aux.PointToString(p.ec.ScalarMult(Gx, Gy, p.cofactor.Bytes())))
+	if p.cofactor.Cmp(one) != 0 {
+		Gx, Gy = p.ec.ScalarMult(Gx, Gy, p.cofactor.Bytes())
+	}
+	h.Write(p.aux.PointToString(Gx, Gy))
+	if p.rfc9381 {
+		h.Write([]byte{0x00}) // zero_string
+	}
 
 	// 6.  Output beta_string
 	return h.Sum(nil), nil
@@ -244,6 +298,12 @@ func (p ECVRFParams) ProofToCurve(pi []byte) (x, y *big.Int, err error) {
 //
 //	beta, the VRF hash output, octet string of length hLen; or "INVALID"
 func (p ECVRFParams) Verify(pub *PublicKey, pi, alpha []byte) (beta []byte, err error) {
+	return p.verify(pub, pi, nil, alpha)
+}
+
+// verify is Verify, which also checks that points, if not nil, are the
+// points U and V of the proof.
+func (p ECVRFParams) verify(pub *PublicKey, pi, points, alpha []byte) (beta []byte, err error) {
 	// 1.  D = ECVRF_decode_proof(pi_string)
 	Gx, Gy, c, s, err := p.decodeProof(pi)
 	// 2.  If D is "INVALID", output "INVALID" and stop
@@ -252,6 +312,11 @@ func (p ECVRFParams) Verify(pub *PublicKey, pi, alpha []byte) (beta []byte, err
 	}
 	// 3.  (Gamma, c, s) = D
 
+	// RFC 9381 section 5.3 additionally requires a valid public key.
+	if p.rfc9381 && !p.validateKey(pub) {
+		return nil, errors.New("invalid public key")
+	}
+
 	// 4.  H = ECVRF_hash_to_curve(suite_string, Y, alpha_string)
 	Hx, Hy := p.aux.HashToCurve(pub, alpha)
 
@@ -270,12 +335,15 @@ func (p ECVRFParams) Verify(pub *PublicKey, pi, alpha []byte) (beta []byte, err
 	Vx, Vy := p.ec.Add(V1x, V1y, V2x, rev2)
 
 	// 7.  c' = ECVRF_hash_points(H, Gamma, U, V)
-	cPrime := p.hashPoints(Hx, Hy, Gx, Gy, Ux, Uy, Vx, Vy)
+	cPrime := p.challenge(pub, Hx, Hy, Gx, Gy, Ux, Uy, Vx, Vy)
 
 	// 8.  If c and c' are not equal output "INVALID"
 	if c.Cmp(cPrime) != 0 {
 		return nil, errors.New("invalid cprime")
 	}
+	if points != nil && !bytes.Equal(points, append(p.aux.PointToString(Ux, Uy), p.aux.PointToString(Vx, Vy)...)) {
+		return nil, errors.New("invalid batch points")
+	}
 	// else, output (ECVRF_proof_to_hash(pi_string), "VALID")
 	return p.ProofToHash(pi)
 }
@@ -284,6 +352,26 @@ func (p ECVRFParams) Verify(pub *PublicKey, pi, alpha []byte) (beta []byte, err
 // Auxiliary functions
 //
 
+// challenge returns the hash of the points of a proof. RFC 9381 hashes the
+// public key before them.
+//
+// https://www.rfc-editor.org/rfc/rfc9381#section-5.1
+func (p ECVRFParams) challenge(pub *PublicKey, pm ...*big.Int) *big.Int {
+	if p.rfc9381 {
+		pm = append([]*big.Int{pub.X, pub.Y}, pm...)
+	}
+	return p.hashPoints(pm...)
+}
+
+// validateKey reports whether pub is a point of the curve other than the
+// identity. The curves have cofactor 1, so this excludes the low order points.
+//
+// https://www.rfc-editor.org/rfc/rfc9381#section-5.4.5
+func (p ECVRFParams) validateKey(pub *PublicKey) bool {
+	return pub != nil && pub.X != nil && pub.Y != nil &&
+		(pub.X.Sign() != 0 || pub.Y.Sign() != 0) && p.ec.IsOnCurve(pub.X, pub.Y)
+}
+
 // hashPoints accepts X,Y pairs of EC points in G and returns an hash value between 0 and 2^(8n)-1
 //
 // https://tools.ietf.org/html/draft-irtf-cfrg-vrf-06#section-5.4.3
@@ -300,6 +388,9 @@ func (p ECVRFParams) hashPoints(pm ...*big.Int) (c *big.Int) {
 		// str = str || point_to_string(PJ)
 		str = append(str, p.aux.PointToString(pm[i], pm[i+1])...)
 	}
+	if p.rfc9381 {
+		str = append(str, 0x00) // zero_string
+	}
 
 	// 4.  c_string = Hash(str)
 	hc := p.hash.New()
@@ -307,7 +398,7 @@ func (p ECVRFParams) hashPoints(pm ...*big.Int) (c *big.Int) {
 	cString := hc.Sum(nil)
 
 	// 5.  truncated_c_string = c_string[0]...c_string[n-1]
-	n := p.fieldLen / 2 //   2n = fieldLen = 32
+	n := p.fieldLen / 2 //   2n = fieldLen
 	// 6.  c = string_to_int(truncated_c_string)
 	c = new(big.Int).SetBytes(cString[:n])
 	return c
@@ -327,7 +418,7 @@ func (p ECVRFParams) hashPoints(pm ...*big.Int) (c *big.Int) {
 // https://tools.ietf.org/html/draft-irtf-cfrg-vrf-06#section-5.4.4
 func (p ECVRFParams) decodeProof(pi []byte) (Gx, Gy, c, s *big.Int, err error) {
 	n := p.fieldLen / 2
-	if got, want := uint(len(pi)), p.ptLen+n+p.qLen; got != want {
+	if got, want := len(pi), p.proofLen(); got != want {
 		return nil, nil, nil, nil, fmt.Errorf("len(pi): %v, want %v", got, want)
 	}
 
@@ -349,6 +440,10 @@ func (p ECVRFParams) decodeProof(pi []byte) (Gx, Gy, c, s *big.Int, err error) {
 	c = new(big.Int).SetBytes(cStr)
 	//    7.  s = string_to_int(s_string)
 	s = new(big.Int).SetBytes(sStr)
+	//        RFC 9381: if s >= q output "INVALID" and stop.
+	if p.rfc9381 && s.Cmp(p.ec.Params().N) >= 0 {
+		return nil, nil, nil, nil, errors.New("s out of range")
+	}
 	//    8.  Output Gamma, c, and s
 	return Gx, Gy, c, s, nil
 }
@@ -360,14 +455,14 @@ func (p ECVRFParams) hashToFieldElement(x []byte) *big.Int {
 	cString := hc.Sum(nil)
 
 	// 5.  truncated_c_string = c_string[0]...c_string[n-1]
-	n := p.fieldLen / 2 //   2n = fieldLen = 32
+	n := p.fieldLen / 2 //   2n = fieldLen
 	// 6.  c = string_to_int(truncated_c_string)
 	c := new(big.Int).SetBytes(cString[:n])
 	return c
 }
 
 func (p ECVRFParams) truncateToFieldElement(x []byte) *big.Int {
-	n := p.fieldLen / 2 //   2n = fieldLen = 32
+	n := p.fieldLen / 2 //   2n = fieldLen
 	c := new(big.Int).SetBytes(x[:n])
 	return c
 }
@@ -444,24 +539,39 @@ func (p ECVRFParams) encodeAndHash(o interface{}) ([]byte, error) {
 	return wr.Sum(nil), nil
 }
 
+// errRotationUnsupported is returned when rotating the key of an RFC 9381
+// suite: those hash the public key to the curve along with the input, so the
+// outputs of a rotated key are not multiples of the old ones.
+var errRotationUnsupported = errors.New("key rotation is not supported by RFC 9381 suites")
+
 func (p ECVRFParams) Rotate(sk *PrivateKey, xs [][]byte) (sk2 *PrivateKey, pi RotationProof, err error) {
 	sk2, pi, _, err = p.StatefulRotate(sk, xs, nil)
 	return sk2, pi, err
 }
 
 func (p ECVRFParams) StatefulRotate(sk *PrivateKey, xs [][]byte, oldProofs [][]byte) (sk2 *PrivateKey, pi RotationProof, newProofs [][]byte, err error) {
-	alpha := p.randomFieldElement()
-	sk2d := new(big.Int)
-	sk2d.Mul(sk.d, alpha)
-	sk2 = NewKey(p.ec, sk2d.Bytes())
+	return p.StatefulRotateContext(context.Background(), sk, xs, oldProofs)
+}
+
+// StatefulRotateContext is StatefulRotate, stopping with the error of ctx
+// once it is cancelled or past its deadline.
+func (p ECVRFParams) StatefulRotateContext(ctx context.Context, sk *PrivateKey, xs [][]byte, oldProofs [][]byte) (sk2 *PrivateKey, pi RotationProof, newProofs [][]byte, err error) {
+	r, err := p.NewRotator(sk)
+	if err != nil {
+		return nil, RotationProof{}, nil, err
+	}
+	sk2 = r.NewKey()
 
 	newProofs = make([][]byte, len(xs))
 	mappings := make([]RotationMapping, len(xs))
-	g := new(errgroup.Group)
+	g, gctx := errgroup.WithContext(ctx)
 	g.SetLimit(32)
 	for i, x := range xs {
 		i, x := i, x
 		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			var oldx, oldy *big.Int
 			if len(oldProofs) == 0 {
 				var err error
@@ -497,32 +607,43 @@ func (p ECVRFParams) StatefulRotate(sk *PrivateKey, xs [][]byte, oldProofs [][]b
 		return nil, RotationProof{}, nil, err
 	}
 
-	mappingsHash, err := p.encodeAndHash(mappings)
+	if err := ctx.Err(); err != nil {
+		return nil, RotationProof{}, nil, err
+	}
+	if err := r.HashMappings(len(mappings), mappings); err != nil {
+		return nil, RotationProof{}, nil, err
+	}
+	if err := r.CombineMappings(mappings); err != nil {
+		return nil, RotationProof{}, nil, err
+	}
+	pi, err = r.Proof()
 	if err != nil {
 		return nil, RotationProof{}, nil, err
 	}
+	return sk2, pi, newProofs, nil
+}
 
+// mappingCoefficients returns the coefficients of the mappings in Y and Y'.
+func (p ECVRFParams) mappingCoefficients(pk, pk2 *PublicKey, mappings []RotationMapping, mappingsHash []byte) []*big.Int {
 	var as []*big.Int
-	for idx, _ := range xs {
-		inp := mappings[idx].OldX.Bytes()
-		inp = append(inp, mappings[idx].OldY.Bytes()...)
-		inp = append(inp, sk.Public().X.Bytes()...)
-		inp = append(inp, sk.Public().Y.Bytes()...)
-		inp = append(inp, sk2.Public().X.Bytes()...)
-		inp = append(inp, sk2.Public().Y.Bytes()...)
+	for _, mapping := range mappings {
+		inp := mapping.OldX.Bytes()
+		inp = append(inp, mapping.OldY.Bytes()...)
+		inp = append(inp, pk.X.Bytes()...)
+		inp = append(inp, pk.Y.Bytes()...)
+		inp = append(inp, pk2.X.Bytes()...)
+		inp = append(inp, pk2.Y.Bytes()...)
 		inp = append(inp, mappingsHash...)
 		as = append(as, p.hashToFieldElement(inp))
 	}
+	return as
+}
 
+// rotationProof proves that sk2 = alpha*sk and Y' = alpha*Y.
+func (p ECVRFParams) rotationProof(sk, sk2 *PrivateKey, alpha, yX, yY, y2X, y2Y *big.Int) (RotationProof, error) {
 	r := p.randomFieldElement()
-	pkexpX, pkexpY := p.ec.ScalarMult(sk.Public().X, sk.Public().Y, r.Bytes())
-
-	yX, yY, y2X, y2Y, err := p.combineY(mappings, as)
-	if err != nil {
-		return nil, RotationProof{}, nil, err
-	}
-
-	yexpX, yexpY := p.ec.ScalarMult(yX, yY, r.Bytes())
+	pkexpX, pkexpY := secretScalarMult(p.ec, sk.Public().X, sk.Public().Y, secretBytes(r))
+	yexpX, yexpY := secretScalarMult(p.ec, yX, yY, secretBytes(r))
 	cst := cstruct{
 		pk:     *sk.Public(),
 		yX:     yX,
@@ -537,23 +658,19 @@ func (p ECVRFParams) StatefulRotate(sk *PrivateKey, xs [][]byte, oldProofs [][]b
 	}
 	cbytes, err := p.encodeAndHash(cst)
 	if err != nil {
-		return nil, RotationProof{}, nil, err
+		return RotationProof{}, err
 	}
 	c := p.truncateToFieldElement(cbytes)
 
-	zint := new(big.Int)
-	z := new(big.Int)
-	zint.Mul(c, alpha).Mod(zint, p.ec.Params().N)
-	z.Sub(r, zint).Mod(z, p.ec.Params().N)
-	proof := RotationProof{
+	// z = r - c*alpha mod q
+	z := secretMulAdd(p.ec, r, new(big.Int).Sub(p.ec.Params().N, c), alpha)
+	return RotationProof{
 		PkExpX: pkexpX,
 		PkExpY: pkexpY,
 		YExpX:  yexpX,
 		YExpY:  yexpY,
 		Z:      z,
-	}
-
-	return sk2, proof, newProofs, nil
+	}, nil
 }
 
 func (p ECVRFParams) combineY(mappings []RotationMapping, as []*big.Int) (*big.Int, *big.Int, *big.Int, *big.Int, error) {
@@ -592,6 +709,9 @@ func (p ECVRFParams) combineY(mappings []RotationMapping, as []*big.Int) (*big.I
 }
 
 func (p ECVRFParams) VerifyRotate(pk *PublicKey, pk2 *PublicKey, mappings []RotationMapping, pi RotationProof) (err error) {
+	if p.rfc9381 {
+		return errRotationUnsupported
+	}
 
 	invalidx, invalidy := p.ec.ScalarBaseMult(big.NewInt(0).Bytes())
 
@@ -607,18 +727,7 @@ func (p ECVRFParams) VerifyRotate(pk *PublicKey, pk2 *PublicKey, mappings []Rota
 		return err
 	}
 
-	var as []*big.Int
-	for _, mapping := range mappings {
-		inp := mapping.OldX.Bytes()
-		inp = append(inp, mapping.OldY.Bytes()...)
-		inp = append(inp, pk.X.Bytes()...)
-		inp = append(inp, pk.Y.Bytes()...)
-		inp = append(inp, pk2.X.Bytes()...)
-		inp = append(inp, pk2.Y.Bytes()...)
-		inp = append(inp, mappingsHash...)
-		as = append(as, p.hashToFieldElement(inp))
-	}
-
+	as := p.mappingCoefficients(pk, pk2, mappings, mappingsHash)
 	yX, yY, y2X, y2Y, err := p.combineY(mappings, as)
 	if err != nil {
 		return err
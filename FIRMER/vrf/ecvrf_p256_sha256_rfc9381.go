@@ -0,0 +1,100 @@
+package vrf
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"math/big"
+)
+
+// The suites of RFC 9381 keep the parameters and suite strings of the draft
+// ones, but bind the challenge to the public key, terminate the hashed strings
+// with a zero octet, and reject proofs with s >= q. ECVRF-P256-SHA256-SSWU
+// hashes to the curve as in RFC 9380 instead of the draft simplified SWU.
+//
+// Since they hash the public key to the curve, both suites do not support key
+// rotation.
+
+type (
+	p256SHA256TAIRFC9381Suite  struct{ *ECVRFParams }
+	p256SHA256SSWURFC9381Suite struct{ *ECVRFParams }
+	p256SHA256SSWURFC9381Aux   struct {
+		p256SHA256TAIAux
+		h2c h2cSuite
+		dst []byte
+	}
+)
+
+var (
+	p256SHA256TAIRFC9381  p256SHA256TAIRFC9381Suite
+	p256SHA256SSWURFC9381 p256SHA256SSWURFC9381Suite
+)
+
+// ECVRFP256SHA256TAIRFC9381 returns the ECVRF-P256-SHA256-TAI suite of RFC
+// 9381.
+func ECVRFP256SHA256TAIRFC9381() ECVRF {
+	initonce.Do(initAll)
+	return p256SHA256TAIRFC9381
+}
+
+// ECVRFP256SHA256SSWURFC9381 returns the ECVRF-P256-SHA256-SSWU suite of RFC
+// 9381, which hashes to the curve with the P256_XMD:SHA-256_SSWU_NU_ suite of
+// RFC 9380.
+func ECVRFP256SHA256SSWURFC9381() ECVRF {
+	initonce.Do(initAll)
+	return p256SHA256SSWURFC9381
+}
+
+func newP256SHA256RFC9381Params(suite byte) *ECVRFParams {
+	// https://www.rfc-editor.org/rfc/rfc9381#section-5.5
+	return &ECVRFParams{
+		suite:    suite,
+		ec:       elliptic.P256(),
+		fieldLen: 32,
+		qLen:     32,
+		ptLen:    33,
+		cofactor: big.NewInt(1),
+		hash:     crypto.SHA256,
+		rfc9381:  true,
+	}
+}
+
+func initP256SHA256TAIRFC9381() {
+	p := newP256SHA256RFC9381Params(0x01)
+	p.aux = p256SHA256TAIAux{params: p}
+	p256SHA256TAIRFC9381.ECVRFParams = p
+}
+
+func initP256SHA256SSWURFC9381() {
+	p := newP256SHA256RFC9381Params(0x02)
+	p.aux = p256SHA256SSWURFC9381Aux{
+		p256SHA256TAIAux: p256SHA256TAIAux{params: p},
+		h2c: h2cSuite{
+			ec:   p.ec,
+			hash: crypto.SHA256,
+			z:    new(big.Int).Sub(p.ec.Params().P, big.NewInt(10)), // Z = -10
+			l:    48,
+		},
+		// DST = "ECVRF_" || h2c_suite_ID_string || suite_string
+		dst: append([]byte("ECVRF_P256_XMD:SHA-256_SSWU_NU_"), p.suite),
+	}
+	p256SHA256SSWURFC9381.ECVRFParams = p
+}
+
+// Params returns the parameters for the ECVRF.
+func (s p256SHA256TAIRFC9381Suite) Params() *ECVRFParams { return s.ECVRFParams }
+
+// Params returns the parameters for the ECVRF.
+func (s p256SHA256SSWURFC9381Suite) Params() *ECVRFParams { return s.ECVRFParams }
+
+// HashToCurve implements ECVRF_encode_to_curve_h2c_suite, encoding
+// PK_string || alpha_string to the curve.
+//
+// https://www.rfc-editor.org/rfc/rfc9381#section-5.4.1.2
+func (a p256SHA256SSWURFC9381Aux) HashToCurve(pub *PublicKey, alpha []byte) (Hx, Hy *big.Int) {
+	msg := append(a.PointToString(pub.X, pub.Y), alpha...)
+	Hx, Hy, err := a.h2c.encodeToCurve(msg, a.dst)
+	if err != nil {
+		panic(err) // Only for a DST longer than 255 bytes.
+	}
+	return Hx, Hy
+}
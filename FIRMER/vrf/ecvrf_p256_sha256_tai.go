@@ -29,7 +29,13 @@ import (
 
 type (
 	p256SHA256TAISuite struct{ *ECVRFParams }
-	p256SHA256TAIAux   struct{ params *ECVRFParams }
+	p256SHA256TAIAux   struct {
+		params *ECVRFParams
+		// omitPK leaves the public key out of HashToCurve, as the SWU suite
+		// does, so that the outputs of a rotated key are multiples of the old
+		// ones.
+		omitPK bool
+	}
 )
 
 var p256SHA256TAI p256SHA256TAISuite
@@ -81,12 +87,16 @@ func (a p256SHA256TAIAux) IntToString(x *big.Int, xLen uint) []byte {
 
 // ArbitraryString2Point returns StringToPoint(0x02 || h).
 // Attempts to interpret an arbitrary string as a compressed elliptic code point.
-// The input h is a 32-octet string.  Returns either an EC point or "INVALID".
+// The input h is a fieldLen-octet string, or a shorter one which is padded
+// with zeros on the left.  Returns either an EC point or "INVALID".
 func (a p256SHA256TAIAux) ArbitraryStringToPoint(h []byte) (Px, Py *big.Int, err error) {
-	if got, want := len(h), 32; got != want {
-		return nil, nil, fmt.Errorf("len(s): %v, want %v", got, want)
+	if got, max := uint(len(h)), a.params.fieldLen; got > max {
+		return nil, nil, fmt.Errorf("len(s): %v, want at most %v", got, max)
 	}
-	return a.StringToPoint(append([]byte{0x02}, h...))
+	str := make([]byte, 1+a.params.fieldLen)
+	str[0] = 0x02
+	copy(str[1+a.params.fieldLen-uint(len(h)):], h)
+	return a.StringToPoint(str)
 }
 
 // GenerateNonce implements RFC 6979 section 3.2
@@ -176,9 +186,9 @@ func (a p256SHA256TAIAux) GenerateNonce(sk *PrivateKey, h []byte) (k *big.Int) {
 	for {
 		// 1.  Set T to the empty sequence.  The length of T (in bits) is
 		//     denoted tlen; thus, at that point, tlen = 0.
-		T := make([]byte, 0, qlen/8)
+		T := make([]byte, 0, (qlen+7)/8)
 		//  2.  While tlen < qlen, do the following:
-		for len(T) < qlen/8 {
+		for len(T)*8 < qlen {
 			//         V = HMAC_K(V)
 			vm = hmac.New(hash.New, K)
 			vm.Write(V)
@@ -188,10 +198,9 @@ func (a p256SHA256TAIAux) GenerateNonce(sk *PrivateKey, h []byte) (k *big.Int) {
 		}
 		//  3.  Compute: k = bits2int(T)
 		k := bits2int(T, qlen)
-		one := big.NewInt(1)
 		// If that value of k is within the [1,q-1] range, then the generation of k is finished.
 		// (The "suitable for DSA or ECDSA" check in step h.3 is omitted.)
-		if k.Cmp(one) >= 0 && k.Cmp(q) < 0 {
+		if ctInRange(k, q) {
 			return k
 		}
 
@@ -254,9 +263,14 @@ func (a p256SHA256TAIAux) hashToCurve(pub *PublicKey, alpha []byte) (Hx, Hy *big
 		//     PK_string || alpha_string || ctr_string)
 		h.Reset()
 		h.Write([]byte{a.params.suite, 0x01})
-		h.Write(pkStr)
+		if !a.omitPK {
+			h.Write(pkStr)
+		}
 		h.Write(alpha)
 		h.Write([]byte{ctr}) // ctr_string = int_to_string(ctr, 1)
+		if a.params.rfc9381 {
+			h.Write([]byte{0x00}) // zero_string
+		}
 		hashString := h.Sum(nil)
 		// C.  H = arbitrary_string_to_point(hash_string)
 		Hx, Hy, err = a.ArbitraryStringToPoint(hashString)
@@ -0,0 +1,43 @@
+package vrf
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"math/big"
+
+	_ "crypto/sha512"
+)
+
+type p384SHA384TAISuite struct{ *ECVRFParams }
+
+var p384SHA384TAI p384SHA384TAISuite
+
+// ECVRFP384SHA384TAI returns a elliptic curve based VRF instantiated with
+// P384, SHA384, and the "Try And Increment" strategy for hashing to the curve.
+//
+// This suite is non-standard: neither draft-irtf-cfrg-vrf-06 nor RFC 9381
+// defines it, and its suite_string 0x05 is made up here and appears in no
+// spec. Other implementations will not interoperate with it. It follows
+// ECVRF-P256-SHA256-TAI, except that, like the SWU suite, it leaves the
+// public key out of the hash to the curve so that its keys can be rotated.
+func ECVRFP384SHA384TAI() ECVRF {
+	initonce.Do(initAll)
+	return p384SHA384TAI
+}
+
+func initP384SHA384TAI() {
+	p := &ECVRFParams{
+		suite:    0x05,
+		ec:       elliptic.P384(), // NIST P-384 elliptic curve, [FIPS-186-4] (Section D.1.2).
+		fieldLen: 48,              // ceil(Params().BitSize / 8) = 2n. Must be a multiple of 2.
+		qLen:     48,              // ceil(Params().N.BitLen() / 8)
+		ptLen:    49,              // Size of a compressed EC point
+		cofactor: big.NewInt(1),
+		hash:     crypto.SHA384,
+	}
+	p.aux = p256SHA256TAIAux{params: p, omitPK: true}
+	p384SHA384TAI.ECVRFParams = p
+}
+
+// Params returns the parameters for the ECVRF.
+func (s p384SHA384TAISuite) Params() *ECVRFParams { return s.ECVRFParams }
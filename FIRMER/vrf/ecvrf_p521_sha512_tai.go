@@ -0,0 +1,48 @@
+package vrf
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"math/big"
+
+	_ "crypto/sha512"
+)
+
+type p521SHA512TAISuite struct{ *ECVRFParams }
+
+var p521SHA512TAI p521SHA512TAISuite
+
+// ECVRFP521SHA512TAI returns a elliptic curve based VRF instantiated with
+// P521, SHA512, and the "Try And Increment" strategy for hashing to the curve.
+//
+// This suite is non-standard: neither draft-irtf-cfrg-vrf-06 nor RFC 9381
+// defines it, and its suite_string 0x06 is made up here and appears in no
+// spec. Other implementations will not interoperate with it. It follows
+// ECVRF-P256-SHA256-TAI, except that, like the SWU suite, it leaves the
+// public key out of the hash to the curve so that its keys can be rotated.
+//
+// Try and increment takes the 64-byte SHA-512 output as the x-coordinate,
+// left-padded with zeros to the 66 bytes of a P-521 field element, so the
+// hash to the curve only reaches points with x < 2^512, a small fraction of
+// the curve.
+func ECVRFP521SHA512TAI() ECVRF {
+	initonce.Do(initAll)
+	return p521SHA512TAI
+}
+
+func initP521SHA512TAI() {
+	p := &ECVRFParams{
+		suite:    0x06,
+		ec:       elliptic.P521(), // NIST P-521 elliptic curve, [FIPS-186-4] (Section D.1.2).
+		fieldLen: 66,              // ceil(Params().BitSize / 8) = 2n. Must be a multiple of 2.
+		qLen:     66,              // ceil(Params().N.BitLen() / 8)
+		ptLen:    67,              // Size of a compressed EC point
+		cofactor: big.NewInt(1),
+		hash:     crypto.SHA512,
+	}
+	p.aux = p256SHA256TAIAux{params: p, omitPK: true}
+	p521SHA512TAI.ECVRFParams = p
+}
+
+// Params returns the parameters for the ECVRF.
+func (s p521SHA512TAISuite) Params() *ECVRFParams { return s.ECVRFParams }
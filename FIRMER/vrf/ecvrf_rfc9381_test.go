@@ -0,0 +1,177 @@
+package vrf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Test vectors of P256_XMD:SHA-256_SSWU_NU_ from RFC 9380 appendix J.1.2.
+func TestEncodeToCurveP256(t *testing.T) {
+	ECVRFP256SHA256SSWURFC9381()
+	h2c := p256SHA256SSWURFC9381.aux.(p256SHA256SSWURFC9381Aux).h2c
+	dst := []byte("QUUX-V01-CS02-with-P256_XMD:SHA-256_SSWU_NU_")
+	for _, tc := range []struct {
+		msg, x, y string
+	}{
+		{
+			msg: "",
+			x:   "f871caad25ea3b59c16cf87c1894902f7e7b2c822c3d3f73596c5ace8ddd14d1",
+			y:   "87b9ae23335bee057b99bac1e68588b18b5691af476234b8971bc4f011ddc99b",
+		},
+		{
+			msg: "abc",
+			x:   "fc3f5d734e8dce41ddac49f47dd2b8a57257522a865c124ed02b92b5237befa4",
+			y:   "fe4d197ecf5a62645b9690599e1d80e82c500b22ac705a0b421fac7b47157866",
+		},
+	} {
+		x, y, err := h2c.encodeToCurve([]byte(tc.msg), dst)
+		if err != nil {
+			t.Fatalf("encodeToCurve(%q): %v", tc.msg, err)
+		}
+		if got := hex.EncodeToString(i2osp(x, 32)); got != tc.x {
+			t.Errorf("encodeToCurve(%q).x: %v, want %v", tc.msg, got, tc.x)
+		}
+		if got := hex.EncodeToString(i2osp(y, 32)); got != tc.y {
+			t.Errorf("encodeToCurve(%q).y: %v, want %v", tc.msg, got, tc.y)
+		}
+	}
+}
+
+// rfc9381Vector is an example of RFC 9381 appendix B, in hex.
+type rfc9381Vector struct {
+	sk, pk, alpha, h, pi, beta string
+}
+
+func testRFC9381Vectors(t *testing.T, v ECVRF, vectors []rfc9381Vector) {
+	t.Helper()
+	for _, tc := range vectors {
+		sk := NewKey(v.Params().EC(), mustDecodeHex(t, tc.sk))
+		aux := v.Params().aux
+		if got := hex.EncodeToString(aux.PointToString(sk.X, sk.Y)); got != tc.pk {
+			t.Errorf("PK: %v, want %v", got, tc.pk)
+		}
+		alpha := mustDecodeHex(t, tc.alpha)
+		if got := hex.EncodeToString(aux.PointToString(aux.HashToCurve(sk.Public(), alpha))); got != tc.h {
+			t.Errorf("H(%q): %v, want %v", alpha, got, tc.h)
+		}
+		pi := v.Prove(sk, alpha)
+		if got := hex.EncodeToString(pi); got != tc.pi {
+			t.Errorf("Prove(%q): %v, want %v", alpha, got, tc.pi)
+		}
+		beta, err := v.Verify(sk.Public(), mustDecodeHex(t, tc.pi), alpha)
+		if err != nil {
+			t.Fatalf("Verify(%q): %v", alpha, err)
+		}
+		if got := hex.EncodeToString(beta); got != tc.beta {
+			t.Errorf("Verify(%q): %v, want %v", alpha, got, tc.beta)
+		}
+	}
+}
+
+// Test vectors of ECVRF-P256-SHA256-TAI from RFC 9381 appendix B.1.
+func TestECVRFP256SHA256TAIRFC9381Vectors(t *testing.T) {
+	testRFC9381Vectors(t, ECVRFP256SHA256TAIRFC9381(), []rfc9381Vector{
+		{
+			sk:    "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721",
+			pk:    "0360fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6",
+			alpha: "73616d706c65",
+			h:     "0272a877532e9ac193aff4401234266f59900a4a9e3fc3cfc6a4b7e467a15d06d4",
+			pi:    "035b5c726e8c0e2c488a107c600578ee75cb702343c153cb1eb8dec77f4b5071b4a53f0a46f018bc2c56e58d383f2305e0975972c26feea0eb122fe7893c15af376b33edf7de17c6ea056d4d82de6bc02f",
+			beta:  "a3ad7b0ef73d8fc6655053ea22f9bede8c743f08bbed3d38821f0e16474b505e",
+		},
+		{
+			sk:    "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721",
+			pk:    "0360fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6",
+			alpha: "74657374",
+			h:     "02173119b4fff5e6f8afed4868a29fe8920f1b54c2cf89cc7b301d0d473de6b974",
+			pi:    "034dac60aba508ba0c01aa9be80377ebd7562c4a52d74722e0abae7dc3080ddb56c19e067b15a8a8174905b13617804534214f935b94c2287f797e393eb0816969d864f37625b443f30f1a5a33f2b3c854",
+			beta:  "a284f94ceec2ff4b3794629da7cbafa49121972671b466cab4ce170aa365f26d",
+		},
+	})
+}
+
+// Test vectors of ECVRF-P256-SHA256-SSWU from RFC 9381 appendix B.2,
+// examples 13 to 15.
+func TestECVRFP256SHA256SSWURFC9381Vectors(t *testing.T) {
+	testRFC9381Vectors(t, ECVRFP256SHA256SSWURFC9381(), []rfc9381Vector{
+		{
+			sk:    "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721",
+			pk:    "0360fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6",
+			alpha: "73616d706c65",
+			h:     "02b31973e872d4a097e2cfae9f37af9f9d73428fde74ac537dda93b5f18dbc5842",
+			pi:    "0331d984ca8fece9cbb9a144c0d53df3c4c7a33080c1e02ddb1a96a365394c7888782fffde7b842c38c20c08de6ec6c2e7027a97000f2c9fa4425d5c03e639fb48fde58114d755985498d7eb234cf4aed9",
+			beta:  "21e66dc9747430f17ed9efeda054cf4a264b097b9e8956a1787526ed00dc664b",
+		},
+		{
+			sk:    "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721",
+			pk:    "0360fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6",
+			alpha: "74657374",
+			h:     "03ccc747fa7318b9486ce4044adbbecaa084c27be6eda88eb7b7f3d688fd0968c7",
+			pi:    "03f814c0455d32dbc75ad3aea08c7e2db31748e12802db23640203aebf1fa8db2743aad348a3006dc1caad7da28687320740bf7dd78fe13c298867321ce3b36b79ec3093b7083ac5e4daf3465f9f43c627",
+			beta:  "8e7185d2b420e4f4681f44ce313a26d05613323837da09a69f00491a83ad25dd",
+		},
+		{
+			sk:    "2ca1411a41b17b24cc8c3b089cfd033f1920202a6c0de8abb97df1498d50d2c8",
+			pk:    "03596375e6ce57e0f20294fc46bdfcfd19a39f8161b58695b3ec5b3d16427c274d",
+			alpha: "4578616d706c65207573696e67204543445341206b65792066726f6d20417070656e646978204c2e342e32206f6620414e53492e58392d36322d32303035",
+			h:     "022dd5150e5a2a24c66feab2f68532be1486e28e07f1b9a055cf38ccc16f6595ff",
+			pi:    "039f8d9cdc162c89be2871cbcb1435144739431db7fab437ab7bc4e2651a9e99d5488405a11a6c7fc8defddd9e1573a563b7333aab4effe73ae9803274174c659269fd39b53e133dcd9e0d24f01288de9a",
+			beta:  "4fbadf33b42a5f42f23a6f89952d2e634a6e3810f15878b46ef1bb85a04fe95a",
+		},
+	})
+}
+
+func TestECVRFRFC9381(t *testing.T) {
+	for _, v := range []ECVRF{ECVRFP256SHA256TAIRFC9381(), ECVRFP256SHA256SSWURFC9381()} {
+		sk := NewKey(v.Params().EC(), bytes.Repeat([]byte{0x2a}, 32))
+		other := NewKey(v.Params().EC(), bytes.Repeat([]byte{0x2b}, 32))
+		alpha := []byte("alice")
+
+		pi := v.Prove(sk, alpha)
+		beta, err := v.Verify(sk.Public(), pi, alpha)
+		if err != nil {
+			t.Fatalf("Verify(): %v", err)
+		}
+		if beta2, _ := v.ProofToHash(pi); !bytes.Equal(beta, beta2) {
+			t.Errorf("ProofToHash(): %x, want %x", beta2, beta)
+		}
+		if _, err := v.Verify(sk.Public(), pi, []byte("bob")); err == nil {
+			t.Errorf("Verify() with another input succeeded")
+		}
+		if _, err := v.Verify(other.Public(), pi, alpha); err == nil {
+			t.Errorf("Verify() with another key succeeded")
+		}
+		invalid := &PublicKey{Curve: sk.Curve, X: new(big.Int), Y: new(big.Int)}
+		if _, err := v.Verify(invalid, pi, alpha); err == nil {
+			t.Errorf("Verify() with the identity as key succeeded")
+		}
+
+		// s must be reduced mod q.
+		tampered := append(append([]byte{}, pi[:33+16]...), i2osp(v.Params().EC().Params().N, 32)...)
+		if _, _, _, _, err := v.Params().decodeProof(tampered); err == nil {
+			t.Errorf("decodeProof() with s = q succeeded")
+		}
+
+		if _, _, err := v.Rotate(sk, [][]byte{alpha}); err != errRotationUnsupported {
+			t.Errorf("Rotate(): %v, want %v", err, errRotationUnsupported)
+		}
+	}
+
+	// The RFC suites are not compatible with the draft ones.
+	sk := NewKey(ECVRFP256SHA256TAI().Params().EC(), bytes.Repeat([]byte{0x2a}, 32))
+	pi := ECVRFP256SHA256TAI().Prove(sk, []byte("alice"))
+	if _, err := ECVRFP256SHA256TAIRFC9381().Verify(sk.Public(), pi, []byte("alice")); err == nil {
+		t.Errorf("Verify() of a draft proof succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+package vrf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestECVRFP384P521TAI(t *testing.T) {
+	for _, tc := range []struct {
+		v          ECVRF
+		piLen, out int
+	}{
+		{v: ECVRFP384SHA384TAI(), piLen: 49 + 24 + 48, out: 48},
+		{v: ECVRFP521SHA512TAI(), piLen: 67 + 33 + 66, out: 64},
+	} {
+		v := tc.v
+		if got := v.Params().OutputLen(); got != tc.out {
+			t.Errorf("OutputLen(): %v, want %v", got, tc.out)
+		}
+		sk, err := GenerateKey(v.Params().EC(), rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey(): %v", err)
+		}
+		xs := [][]byte{[]byte("alice"), []byte("bob"), []byte("carol")}
+		for _, x := range xs {
+			pi := v.Prove(sk, x)
+			if len(pi) != tc.piLen {
+				t.Fatalf("len(pi): %v, want %v", len(pi), tc.piLen)
+			}
+			if !bytes.Equal(pi, v.Prove(sk, x)) {
+				t.Errorf("Prove() is not deterministic")
+			}
+			beta, err := v.Verify(sk.Public(), pi, x)
+			if err != nil {
+				t.Fatalf("Verify(): %v", err)
+			}
+			if len(beta) != tc.out {
+				t.Errorf("len(beta): %v, want %v", len(beta), tc.out)
+			}
+			if _, err := v.Verify(sk.Public(), pi, []byte("dave")); err == nil {
+				t.Errorf("Verify() with another input succeeded")
+			}
+			pi[len(pi)-1] ^= 1
+			if _, err := v.Verify(sk.Public(), pi, x); err == nil {
+				t.Errorf("Verify() of a modified proof succeeded")
+			}
+		}
+
+		sk2, rpi, newProofs, err := v.StatefulRotate(sk, xs, nil)
+		if err != nil {
+			t.Fatalf("StatefulRotate(): %v", err)
+		}
+		for i, x := range xs {
+			if _, err := v.Verify(sk2.Public(), newProofs[i], x); err != nil {
+				t.Errorf("Verify() of rotated proof: %v", err)
+			}
+		}
+		mappings, err := GenerateMapping(v, sk, sk2, xs)
+		if err != nil {
+			t.Fatalf("GenerateMapping(): %v", err)
+		}
+		if err := v.VerifyRotate(sk.Public(), sk2.Public(), mappings, rpi); err != nil {
+			t.Errorf("VerifyRotate(): %v", err)
+		}
+		mappings[0].NewX, mappings[0].NewY = mappings[1].NewX, mappings[1].NewY
+		if err := v.VerifyRotate(sk.Public(), sk2.Public(), mappings, rpi); err == nil {
+			t.Errorf("VerifyRotate() of modified mappings succeeded")
+		}
+	}
+}
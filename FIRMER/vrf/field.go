@@ -0,0 +1,212 @@
+package vrf
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// maxLimbs is the number of 64-bit limbs of the largest supported field,
+// that of P-521.
+const maxLimbs = 9
+
+// fe is an element of a montField in Montgomery form, as little endian
+// limbs. Only the first n limbs of the field are used.
+type fe [maxLimbs]uint64
+
+// montField implements arithmetic modulo an odd prime p of n limbs with
+// Montgomery multiplication. The operations run in time independent of their
+// inputs.
+type montField struct {
+	n    int
+	p    fe
+	pInv uint64 // -p^-1 mod 2^64
+	r2   fe     // R^2 mod p, with R = 2^(64n)
+	one  fe     // R mod p
+	pBig *big.Int
+}
+
+func newMontField(p *big.Int) *montField {
+	f := &montField{n: (p.BitLen() + 63) / 64, pBig: p}
+	if f.n > maxLimbs {
+		panic("field too large")
+	}
+	f.p = f.limbs(p)
+
+	// Newton iteration for p^-1 mod 2^64, doubling the correct bits each time.
+	inv := uint64(1)
+	for i := 0; i < 6; i++ {
+		inv *= 2 - f.p[0]*inv
+	}
+	f.pInv = -inv
+
+	R := new(big.Int).Lsh(big.NewInt(1), uint(64*f.n))
+	f.one = f.limbs(new(big.Int).Mod(R, p))
+	f.r2 = f.limbs(new(big.Int).Mod(new(big.Int).Mul(R, R), p))
+	return f
+}
+
+// limbs returns x < 2^(64n) as limbs, without conversion to Montgomery form.
+func (f *montField) limbs(x *big.Int) (z fe) {
+	b := make([]byte, 8*f.n)
+	x.FillBytes(b)
+	return f.limbsFromBytes(b)
+}
+
+// limbsFromBytes returns the big endian 8n-byte integer b as limbs.
+func (f *montField) limbsFromBytes(b []byte) (z fe) {
+	for i := 0; i < f.n; i++ {
+		for j := 0; j < 8; j++ {
+			z[i] |= uint64(b[len(b)-1-8*i-j]) << (8 * j)
+		}
+	}
+	return z
+}
+
+// bytes returns the limbs of x as a big endian 8n-byte integer.
+func (f *montField) bytes(x *fe) []byte {
+	b := make([]byte, 8*f.n)
+	for i := 0; i < f.n; i++ {
+		for j := 0; j < 8; j++ {
+			b[len(b)-1-8*i-j] = byte(x[i] >> (8 * j))
+		}
+	}
+	return b
+}
+
+// fromWide returns the big endian integer b mod p, in Montgomery form. Its
+// running time only depends on the length of b.
+func (f *montField) fromWide(b []byte) (z fe) {
+	chunk := 8 * f.n
+	if r := len(b) % chunk; r != 0 {
+		b = append(make([]byte, chunk-r), b...)
+	}
+	// Horner's rule on the n-limb chunks: z*R + c, in Montgomery form
+	// z*R^2 + c*R.
+	for i := 0; i < len(b); i += chunk {
+		c := f.limbsFromBytes(b[i : i+chunk])
+		f.mul(&z, &z, &f.r2)
+		f.mul(&c, &c, &f.r2)
+		f.add(&z, &z, &c)
+	}
+	return z
+}
+
+// fromBig returns x mod p in Montgomery form.
+func (f *montField) fromBig(x *big.Int) (z fe) {
+	if x.Sign() < 0 || x.Cmp(f.pBig) >= 0 {
+		x = new(big.Int).Mod(x, f.pBig)
+	}
+	l := f.limbs(x)
+	f.mul(&z, &l, &f.r2)
+	return z
+}
+
+// toBig returns the integer represented by x.
+func (f *montField) toBig(x *fe) *big.Int {
+	z := f.fromMont(x)
+	return new(big.Int).SetBytes(f.bytes(&z))
+}
+
+// fromMont returns x out of Montgomery form.
+func (f *montField) fromMont(x *fe) (z fe) {
+	var one fe
+	one[0] = 1
+	f.mul(&z, x, &one)
+	return z
+}
+
+// mul sets z = x*y/R mod p, with the CIOS method.
+func (f *montField) mul(z, x, y *fe) {
+	n := f.n
+	var t [maxLimbs + 2]uint64
+	for i := 0; i < n; i++ {
+		var c, hi, lo uint64
+		for j := 0; j < n; j++ {
+			hi, lo = bits.Mul64(x[j], y[i])
+			lo, cc := bits.Add64(lo, t[j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, c, 0)
+			hi += cc
+			t[j], c = lo, hi
+		}
+		var cc uint64
+		t[n], cc = bits.Add64(t[n], c, 0)
+		t[n+1] = cc
+
+		m := t[0] * f.pInv
+		hi, lo = bits.Mul64(m, f.p[0])
+		_, cc = bits.Add64(lo, t[0], 0)
+		c = hi + cc
+		for j := 1; j < n; j++ {
+			hi, lo = bits.Mul64(m, f.p[j])
+			lo, cc = bits.Add64(lo, t[j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, c, 0)
+			hi += cc
+			t[j-1], c = lo, hi
+		}
+		t[n-1], cc = bits.Add64(t[n], c, 0)
+		t[n] = t[n+1] + cc
+	}
+	f.reduce(z, t[:n+1])
+}
+
+// reduce sets z = t mod p for t < 2p, given as n+1 limbs.
+func (f *montField) reduce(z *fe, t []uint64) {
+	n := f.n
+	var d fe
+	var b uint64
+	for i := 0; i < n; i++ {
+		d[i], b = bits.Sub64(t[i], f.p[i], b)
+	}
+	_, b = bits.Sub64(t[n], 0, b)
+	// Keep t if the subtraction borrowed.
+	mask := -b
+	for i := 0; i < n; i++ {
+		z[i] = t[i]&mask | d[i]&^mask
+	}
+}
+
+func (f *montField) square(z, x *fe) { f.mul(z, x, x) }
+
+// add sets z = x+y mod p.
+func (f *montField) add(z, x, y *fe) {
+	var t [maxLimbs + 1]uint64
+	var c uint64
+	for i := 0; i < f.n; i++ {
+		t[i], c = bits.Add64(x[i], y[i], c)
+	}
+	t[f.n] = c
+	f.reduce(z, t[:f.n+1])
+}
+
+// sub sets z = x-y mod p.
+func (f *montField) sub(z, x, y *fe) {
+	var t fe
+	var b uint64
+	for i := 0; i < f.n; i++ {
+		t[i], b = bits.Sub64(x[i], y[i], b)
+	}
+	// Add p back if the subtraction borrowed.
+	mask := -b
+	var c uint64
+	for i := 0; i < f.n; i++ {
+		z[i], c = bits.Add64(t[i], f.p[i]&mask, c)
+	}
+}
+
+func (f *montField) isZero(x *fe) bool {
+	var acc uint64
+	for i := 0; i < f.n; i++ {
+		acc |= x[i]
+	}
+	return acc == 0
+}
+
+func (f *montField) equal(x, y *fe) bool {
+	var acc uint64
+	for i := 0; i < f.n; i++ {
+		acc |= x[i] ^ y[i]
+	}
+	return acc == 0
+}
@@ -0,0 +1,143 @@
+package vrf
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+)
+
+// This file implements the encoding to short Weierstrass curves with a = -3
+// of RFC 9380, with expand_message_xmd and the simplified SWU map.
+//
+// WARNING: The big.Int operations are *not* constant time.
+
+// h2cSuite is a hash-to-curve suite of RFC 9380 section 8.
+type h2cSuite struct {
+	ec   elliptic.Curve
+	hash crypto.Hash
+	z    *big.Int // The constant Z of the simplified SWU map.
+	l    int      // The length in bytes of the strings reduced to field elements.
+}
+
+// expandMessageXMD implements expand_message_xmd of RFC 9380 section 5.3.1.
+func expandMessageXMD(hash crypto.Hash, msg, dst []byte, lenInBytes int) ([]byte, error) {
+	h := hash.New()
+	bInBytes, sInBytes := h.Size(), h.BlockSize()
+
+	// 1.  ell = ceil(len_in_bytes / b_in_bytes)
+	ell := (lenInBytes + bInBytes - 1) / bInBytes
+	// 2.  ABORT if ell > 255 or len_in_bytes > 65535 or len(DST) > 255
+	if ell > 255 || lenInBytes > 65535 || len(dst) > 255 {
+		return nil, errors.New("expand_message_xmd: invalid length")
+	}
+	// 3.  DST_prime = DST || I2OSP(len(DST), 1)
+	dstPrime := append(append([]byte{}, dst...), byte(len(dst)))
+
+	// 4.-7. msg_prime = Z_pad || msg || l_i_b_str || I2OSP(0, 1) || DST_prime
+	h.Write(make([]byte, sInBytes))
+	h.Write(msg)
+	h.Write([]byte{byte(lenInBytes >> 8), byte(lenInBytes), 0})
+	h.Write(dstPrime)
+	// 8.  b_0 = H(msg_prime)
+	b0 := h.Sum(nil)
+
+	// 9.  b_1 = H(b_0 || I2OSP(1, 1) || DST_prime)
+	h.Reset()
+	h.Write(b0)
+	h.Write([]byte{1})
+	h.Write(dstPrime)
+	bi := h.Sum(nil)
+	uniform := append([]byte{}, bi...)
+
+	// 10. for i in (2, ..., ell):
+	for i := 2; i <= ell; i++ {
+		// 11. b_i = H(strxor(b_0, b_(i - 1)) || I2OSP(i, 1) || DST_prime)
+		x := make([]byte, bInBytes)
+		for j := range x {
+			x[j] = b0[j] ^ bi[j]
+		}
+		h.Reset()
+		h.Write(x)
+		h.Write([]byte{byte(i)})
+		h.Write(dstPrime)
+		bi = h.Sum(nil)
+		uniform = append(uniform, bi...)
+	}
+	// 12. uniform_bytes = b_1 || ... || b_ell
+	// 13. return substr(uniform_bytes, 0, len_in_bytes)
+	return uniform[:lenInBytes], nil
+}
+
+// hashToField implements hash_to_field of RFC 9380 section 5.2 for m = 1.
+func (s h2cSuite) hashToField(msg, dst []byte, count int) ([]*big.Int, error) {
+	uniform, err := expandMessageXMD(s.hash, msg, dst, count*s.l)
+	if err != nil {
+		return nil, err
+	}
+	u := make([]*big.Int, count)
+	for i := range u {
+		u[i] = new(big.Int).SetBytes(uniform[i*s.l : (i+1)*s.l])
+		u[i].Mod(u[i], s.ec.Params().P)
+	}
+	return u, nil
+}
+
+// mapToCurveSSWU implements the simplified SWU map of RFC 9380 section 6.6.2
+// for a = -3.
+func (s h2cSuite) mapToCurveSSWU(u *big.Int) (x, y *big.Int) {
+	p := s.ec.Params().P
+	A := new(big.Int).Sub(p, big.NewInt(3))
+	B := s.ec.Params().B
+	mod := func(v *big.Int) *big.Int { return v.Mod(v, p) }
+	curve := func(x *big.Int) *big.Int { // x^3 + A*x + B
+		v := new(big.Int).Mul(x, x)
+		v.Mul(v, x)
+		v.Add(v, new(big.Int).Mul(A, x))
+		v.Add(v, B)
+		return mod(v)
+	}
+
+	// 1.  tv1 = inv0(Z^2 * u^4 + Z * u^2)
+	zu2 := mod(new(big.Int).Mul(s.z, mod(new(big.Int).Mul(u, u))))
+	tv1 := mod(new(big.Int).Mul(zu2, zu2))
+	tv1 = mod(tv1.Add(tv1, zu2))
+	var x1 *big.Int
+	if tv1.Sign() == 0 {
+		// 3.  If tv1 == 0, set x1 = B / (Z * A)
+		x1 = mod(new(big.Int).Mul(B, new(big.Int).ModInverse(mod(new(big.Int).Mul(s.z, A)), p)))
+	} else {
+		// 2.  x1 = (-B / A) * (1 + tv1)
+		tv1.ModInverse(tv1, p)
+		x1 = mod(new(big.Int).Mul(new(big.Int).Neg(B), new(big.Int).ModInverse(A, p)))
+		x1 = mod(x1.Mul(x1, tv1.Add(tv1, one)))
+	}
+	// 4.  gx1 = x1^3 + A * x1 + B
+	gx1 := curve(x1)
+	// 7.  If is_square(gx1), set x = x1 and y = sqrt(gx1)
+	// 8.  Else set x = x2 and y = sqrt(gx2)
+	x = x1
+	y = new(big.Int).ModSqrt(gx1, p)
+	if y == nil {
+		// 5.  x2 = Z * u^2 * x1
+		x = mod(new(big.Int).Mul(zu2, x1))
+		// 6.  gx2 = x2^3 + A * x2 + B
+		y = new(big.Int).ModSqrt(curve(x), p)
+	}
+	// 9.  If sgn0(u) != sgn0(y), set y = -y
+	if u.Bit(0) != y.Bit(0) {
+		y = mod(y.Neg(y))
+	}
+	return x, y
+}
+
+// encodeToCurve implements encode_to_curve of RFC 9380 section 3, for the
+// _NU_ suites.
+func (s h2cSuite) encodeToCurve(msg, dst []byte) (x, y *big.Int, err error) {
+	u, err := s.hashToField(msg, dst, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	x, y = s.mapToCurveSSWU(u[0])
+	return x, y, nil
+}
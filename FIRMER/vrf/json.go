@@ -0,0 +1,133 @@
+package vrf
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// hexInt is a non-negative integer encoded to JSON as a minimal lowercase hex
+// string ("0" for zero).
+type hexInt struct {
+	*big.Int
+}
+
+func (h hexInt) MarshalJSON() ([]byte, error) {
+	if h.Int == nil {
+		return nil, fmt.Errorf("cannot encode a nil integer")
+	}
+	if h.Sign() < 0 {
+		return nil, fmt.Errorf("cannot encode a negative integer")
+	}
+	return json.Marshal(h.Text(16))
+}
+
+func (h *hexInt) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok || n.Sign() < 0 || n.Text(16) != s {
+		return fmt.Errorf("%q is not a canonical hex integer", s)
+	}
+	h.Int = n
+	return nil
+}
+
+// InvalidJSONError is returned when a JSON encoded proof or mapping cannot be
+// decoded.
+type InvalidJSONError struct {
+	typ    string
+	reason string
+}
+
+func (e InvalidJSONError) Error() string {
+	return fmt.Sprintf("invalid %s JSON: %s", e.typ, e.reason)
+}
+
+// strictUnmarshalJSON decodes data into v, which must be a pointer to a struct
+// whose json tags are exactly fields. Missing and unknown fields, including
+// fields only differing in case, and trailing data are rejected.
+func strictUnmarshalJSON(typ string, data []byte, v interface{}, fields ...string) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return InvalidJSONError{typ, err.Error()}
+	}
+	if raw == nil {
+		return InvalidJSONError{typ, "expected an object, got null"}
+	}
+	for _, f := range fields {
+		if _, found := raw[f]; !found {
+			return InvalidJSONError{typ, fmt.Sprintf("missing field %q", f)}
+		}
+		delete(raw, f)
+	}
+	if len(raw) > 0 {
+		unknown := make([]string, 0, len(raw))
+		for f := range raw {
+			unknown = append(unknown, f)
+		}
+		sort.Strings(unknown)
+		return InvalidJSONError{typ, fmt.Sprintf("unknown field %q", unknown[0])}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return InvalidJSONError{typ, err.Error()}
+	}
+	return nil
+}
+
+type rotationProofJSON struct {
+	PkExpX hexInt `json:"pk_exp_x"`
+	PkExpY hexInt `json:"pk_exp_y"`
+	YExpX  hexInt `json:"y_exp_x"`
+	YExpY  hexInt `json:"y_exp_y"`
+	Z      hexInt `json:"z"`
+}
+
+// MarshalJSON encodes the proof with every integer as a hex string.
+func (p RotationProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rotationProofJSON{
+		PkExpX: hexInt{p.PkExpX},
+		PkExpY: hexInt{p.PkExpY},
+		YExpX:  hexInt{p.YExpX},
+		YExpY:  hexInt{p.YExpY},
+		Z:      hexInt{p.Z},
+	})
+}
+
+func (p *RotationProof) UnmarshalJSON(b []byte) error {
+	var j rotationProofJSON
+	if err := strictUnmarshalJSON("RotationProof", b, &j, "pk_exp_x", "pk_exp_y", "y_exp_x", "y_exp_y", "z"); err != nil {
+		return err
+	}
+	*p = RotationProof{PkExpX: j.PkExpX.Int, PkExpY: j.PkExpY.Int, YExpX: j.YExpX.Int, YExpY: j.YExpY.Int, Z: j.Z.Int}
+	return nil
+}
+
+type rotationMappingJSON struct {
+	OldX hexInt `json:"old_x"`
+	OldY hexInt `json:"old_y"`
+	NewX hexInt `json:"new_x"`
+	NewY hexInt `json:"new_y"`
+}
+
+// MarshalJSON encodes the mapping with every coordinate as a hex string.
+func (m RotationMapping) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rotationMappingJSON{
+		OldX: hexInt{m.OldX},
+		OldY: hexInt{m.OldY},
+		NewX: hexInt{m.NewX},
+		NewY: hexInt{m.NewY},
+	})
+}
+
+func (m *RotationMapping) UnmarshalJSON(b []byte) error {
+	var j rotationMappingJSON
+	if err := strictUnmarshalJSON("RotationMapping", b, &j, "old_x", "old_y", "new_x", "new_y"); err != nil {
+		return err
+	}
+	*m = RotationMapping{OldX: j.OldX.Int, OldY: j.OldY.Int, NewX: j.NewX.Int, NewY: j.NewY.Int}
+	return nil
+}
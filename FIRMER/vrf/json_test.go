@@ -0,0 +1,93 @@
+package vrf
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRotationProofJSONRoundTrip(t *testing.T) {
+	v := ECVRFP256SHA256SWU()
+	sk := NewKey(v.Params().EC(), bytes.Repeat([]byte{0x2a}, 32))
+	xs := [][]byte{[]byte("alice"), []byte("bob"), []byte("carol")}
+
+	sk2, pi, err := v.Rotate(sk, xs)
+	if err != nil {
+		t.Fatalf("Rotate(): %v", err)
+	}
+	mappings, err := GenerateMapping(v, sk, sk2, xs)
+	if err != nil {
+		t.Fatalf("GenerateMapping(): %v", err)
+	}
+
+	piJSON, err := json.Marshal(pi)
+	if err != nil {
+		t.Fatalf("json.Marshal(proof): %v", err)
+	}
+	mappingsJSON, err := json.Marshal(mappings)
+	if err != nil {
+		t.Fatalf("json.Marshal(mappings): %v", err)
+	}
+
+	var pi2 RotationProof
+	if err := json.Unmarshal(piJSON, &pi2); err != nil {
+		t.Fatalf("json.Unmarshal(proof): %v", err)
+	}
+	var mappings2 []RotationMapping
+	if err := json.Unmarshal(mappingsJSON, &mappings2); err != nil {
+		t.Fatalf("json.Unmarshal(mappings): %v", err)
+	}
+	if err := v.VerifyRotate(sk.Public(), sk2.Public(), mappings2, pi2); err != nil {
+		t.Fatalf("VerifyRotate() on decoded values: %v", err)
+	}
+
+	piJSON2, err := json.Marshal(pi2)
+	if err != nil {
+		t.Fatalf("json.Marshal(decoded proof): %v", err)
+	}
+	if !bytes.Equal(piJSON, piJSON2) {
+		t.Errorf("re-encoded proof differs:\n%s\n%s", piJSON, piJSON2)
+	}
+}
+
+func TestRotationProofJSONStrict(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		in   string
+	}{
+		{"missing field", `{"pk_exp_x":"1","pk_exp_y":"2","y_exp_x":"3","y_exp_y":"4"}`},
+		{"unknown field", `{"pk_exp_x":"1","pk_exp_y":"2","y_exp_x":"3","y_exp_y":"4","z":"5","w":"6"}`},
+		{"null field", `{"pk_exp_x":null,"pk_exp_y":"2","y_exp_x":"3","y_exp_y":"4","z":"5"}`},
+		{"uppercase hex", `{"pk_exp_x":"AB","pk_exp_y":"2","y_exp_x":"3","y_exp_y":"4","z":"5"}`},
+		{"leading zero", `{"pk_exp_x":"0ab","pk_exp_y":"2","y_exp_x":"3","y_exp_y":"4","z":"5"}`},
+		{"negative", `{"pk_exp_x":"-1","pk_exp_y":"2","y_exp_x":"3","y_exp_y":"4","z":"5"}`},
+		{"number", `{"pk_exp_x":1,"pk_exp_y":"2","y_exp_x":"3","y_exp_y":"4","z":"5"}`},
+		{"not an object", `[]`},
+		{"null", `null`},
+		// encoding/json matches field names case-insensitively.
+		{"field in another case", `{"pk_exp_x":"1","pk_exp_y":"2","y_exp_x":"3","y_exp_y":"4","z":"5","Z":"6"}`},
+		{"trailing data", `{"pk_exp_x":"1","pk_exp_y":"2","y_exp_x":"3","y_exp_y":"4","z":"5"} junk`},
+	} {
+		var pi RotationProof
+		err := pi.UnmarshalJSON([]byte(tc.in))
+		if _, ok := err.(InvalidJSONError); !ok {
+			t.Errorf("%s: UnmarshalJSON(%s): %v, want an InvalidJSONError", tc.desc, tc.in, err)
+		} else if !strings.Contains(err.Error(), "RotationProof") {
+			t.Errorf("%s: error %q does not name the type", tc.desc, err)
+		}
+	}
+
+	var m RotationMapping
+	for _, in := range []string{
+		`{"old_x":"1","old_y":"2","new_x":"3","new_y":"4","NEW_Y":"5"}`,
+		`{"old_x":"1","old_y":"2","new_x":"3","new_y":"4"}{}`,
+	} {
+		if err := m.UnmarshalJSON([]byte(in)); err == nil {
+			t.Errorf("UnmarshalJSON(%s) succeeded, want error", in)
+		}
+	}
+	if err := m.UnmarshalJSON([]byte(`{"old_x":"1","old_y":"2","new_x":"3","new_y":"4"}`)); err != nil {
+		t.Errorf("UnmarshalJSON(): %v", err)
+	}
+}
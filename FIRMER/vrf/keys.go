@@ -0,0 +1,111 @@
+package vrf
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Keys are encoded with a leading tag identifying their suite:
+//
+//	public key:  tag || point_to_string(Y)
+//	private key: tag || int_to_string(x mod q, qLen)
+//
+// The tag is the suite string, with the high bit set for the RFC 9381 suites,
+// whose suite strings are those of the draft suites.
+
+// keyTag returns the tag of the key encodings of the suite, or 0 if the
+// suite has none.
+func (p ECVRFParams) keyTag() byte {
+	if p.aux == nil {
+		return 0
+	}
+	if p.rfc9381 {
+		return p.suite | 0x80
+	}
+	return p.suite
+}
+
+// SuiteOfKey returns the suite of the encoded public or private key b.
+func SuiteOfKey(b []byte) (ECVRF, error) {
+	initonce.Do(initAll)
+	if len(b) > 0 {
+		for _, v := range []ECVRF{p256SHA256TAI, p256SHA256SWU, p256SHA256TAIRFC9381,
+			p256SHA256SSWURFC9381, p384SHA384TAI, p521SHA512TAI} {
+			if v.Params().keyTag() == b[0] {
+				return v, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown VRF key encoding")
+}
+
+// checkKeyTag checks that b is a key encoding of the suite of length 1+n.
+func (p ECVRFParams) checkKeyTag(b []byte, n uint) error {
+	tag := p.keyTag()
+	if tag == 0 {
+		return fmt.Errorf("the VRF suite has no key encoding")
+	}
+	if len(b) == 0 || b[0] != tag {
+		return fmt.Errorf("not a key of this VRF suite")
+	}
+	if uint(len(b)) != 1+n {
+		return fmt.Errorf("len(key): %v, want %v", len(b), 1+n)
+	}
+	return nil
+}
+
+// MarshalPublicKey returns the encoding of pub, a key of the suite.
+func (p ECVRFParams) MarshalPublicKey(pub *PublicKey) ([]byte, error) {
+	tag := p.keyTag()
+	if tag == 0 {
+		return nil, fmt.Errorf("the VRF suite has no key encoding")
+	}
+	if !p.validateKey(pub) {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	return append([]byte{tag}, p.aux.PointToString(pub.X, pub.Y)...), nil
+}
+
+// UnmarshalPublicKey decodes a public key of the suite. It rejects the keys
+// of other suites and the encodings of points not on the curve.
+func (p ECVRFParams) UnmarshalPublicKey(b []byte) (*PublicKey, error) {
+	if err := p.checkKeyTag(b, p.ptLen); err != nil {
+		return nil, err
+	}
+	x, y, err := p.aux.StringToPoint(b[1:])
+	if err != nil {
+		return nil, err
+	}
+	pub := &PublicKey{Curve: p.ec, X: x, Y: y}
+	if !p.validateKey(pub) {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	return pub, nil
+}
+
+// MarshalPrivateKey returns the encoding of sk, a key of the suite. The
+// scalar of a rotated key is reduced mod q: the decoded key has the same
+// public key and outputs, though its proofs may differ.
+func (p ECVRFParams) MarshalPrivateKey(sk *PrivateKey) ([]byte, error) {
+	tag := p.keyTag()
+	if tag == 0 {
+		return nil, fmt.Errorf("the VRF suite has no key encoding")
+	}
+	x := secretReduce(p.ec, sk.d)
+	if x.Sign() == 0 {
+		return nil, fmt.Errorf("invalid private key")
+	}
+	return append([]byte{tag}, x.FillBytes(make([]byte, p.qLen))...), nil
+}
+
+// UnmarshalPrivateKey decodes a private key of the suite. It rejects the keys
+// of other suites and scalars not in [1, q-1].
+func (p ECVRFParams) UnmarshalPrivateKey(b []byte) (*PrivateKey, error) {
+	if err := p.checkKeyTag(b, p.qLen); err != nil {
+		return nil, err
+	}
+	if !ctInRange(new(big.Int).SetBytes(b[1:]), p.ec.Params().N) {
+		return nil, fmt.Errorf("invalid private key")
+	}
+	return NewKey(p.ec, b[1:]), nil
+}
@@ -0,0 +1,153 @@
+package vrf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+func TestKeyEncoding(t *testing.T) {
+	for _, v := range []ECVRF{ECVRFP256SHA256TAI(), ECVRFP256SHA256SWU(), ECVRFP256SHA256TAIRFC9381(),
+		ECVRFP256SHA256SSWURFC9381(), ECVRFP384SHA384TAI(), ECVRFP521SHA512TAI()} {
+		p := v.Params()
+		sk, err := GenerateKey(p.EC(), rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey(): %v", err)
+		}
+
+		b, err := p.MarshalPublicKey(sk.Public())
+		if err != nil {
+			t.Fatalf("MarshalPublicKey(): %v", err)
+		}
+		if got, want := len(b), 1+int(p.ptLen); got != want {
+			t.Errorf("len(MarshalPublicKey()): %v, want %v", got, want)
+		}
+		pub, err := p.UnmarshalPublicKey(b)
+		if err != nil {
+			t.Fatalf("UnmarshalPublicKey(): %v", err)
+		}
+		if pub.X.Cmp(sk.X) != 0 || pub.Y.Cmp(sk.Y) != 0 {
+			t.Errorf("UnmarshalPublicKey(): (%x, %x), want (%x, %x)", pub.X, pub.Y, sk.X, sk.Y)
+		}
+		if got, err := SuiteOfKey(b); err != nil || got != v {
+			t.Errorf("SuiteOfKey(): %v, %v, want %v", got, err, v)
+		}
+
+		b, err = p.MarshalPrivateKey(sk)
+		if err != nil {
+			t.Fatalf("MarshalPrivateKey(): %v", err)
+		}
+		if got, want := len(b), 1+int(p.qLen); got != want {
+			t.Errorf("len(MarshalPrivateKey()): %v, want %v", got, want)
+		}
+		sk2, err := p.UnmarshalPrivateKey(b)
+		if err != nil {
+			t.Fatalf("UnmarshalPrivateKey(): %v", err)
+		}
+		if sk2.d.Cmp(sk.d) != 0 || sk2.X.Cmp(sk.X) != 0 {
+			t.Errorf("UnmarshalPrivateKey(): %x, want %x", sk2.d, sk.d)
+		}
+		if got, err := SuiteOfKey(b); err != nil || got != v {
+			t.Errorf("SuiteOfKey(): %v, %v, want %v", got, err, v)
+		}
+	}
+
+	// The draft and RFC 9381 suites share their suite strings, but not their
+	// key encodings.
+	sk := NewKey(ECVRFP256SHA256TAI().Params().EC(), bytes.Repeat([]byte{0x2a}, 32))
+	b, err := ECVRFP256SHA256TAI().Params().MarshalPublicKey(sk.Public())
+	if err != nil {
+		t.Fatalf("MarshalPublicKey(): %v", err)
+	}
+	if _, err := ECVRFP256SHA256TAIRFC9381().Params().UnmarshalPublicKey(b); err == nil {
+		t.Errorf("UnmarshalPublicKey() of a key of another suite succeeded")
+	}
+	if _, err := SuiteOfKey([]byte{0x7f}); err == nil {
+		t.Errorf("SuiteOfKey() of an unknown tag succeeded")
+	}
+}
+
+func TestUnmarshalPublicKeyInvalid(t *testing.T) {
+	p := ECVRFP256SHA256SWU().Params()
+	sk := NewKey(p.EC(), bytes.Repeat([]byte{0x2a}, 32))
+	b, err := p.MarshalPublicKey(sk.Public())
+	if err != nil {
+		t.Fatalf("MarshalPublicKey(): %v", err)
+	}
+
+	// An x coordinate of no point of the curve.
+	notOnCurve := append([]byte{}, b...)
+	for x := big.NewInt(1); ; x.Add(x, one) {
+		copy(notOnCurve[2:], i2osp(x, 32))
+		if _, _, err := p.aux.StringToPoint(notOnCurve[1:]); err != nil {
+			break
+		}
+	}
+	// The x coordinate of a point, plus the field prime.
+	notReduced := append([]byte{b[0], 0x02}, i2osp(new(big.Int).Add(big.NewInt(3), p.EC().Params().P), 33)...)
+
+	for _, tc := range []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"truncated", b[:len(b)-1]},
+		{"extended", append(append([]byte{}, b...), 0)},
+		{"uncompressed", append([]byte{b[0]}, marshalUncompressed(sk.Public())...)},
+		{"infinity", append([]byte{b[0], 0x00}, make([]byte, 32)...)},
+		{"prefix", append([]byte{b[0], 0x05}, b[2:]...)},
+		{"not on curve", notOnCurve},
+		{"not reduced", notReduced},
+	} {
+		if _, err := p.UnmarshalPublicKey(tc.b); err == nil {
+			t.Errorf("UnmarshalPublicKey() of %v key succeeded", tc.name)
+		}
+	}
+
+	if _, err := p.MarshalPublicKey(&PublicKey{X: new(big.Int), Y: new(big.Int)}); err == nil {
+		t.Errorf("MarshalPublicKey() of the identity succeeded")
+	}
+}
+
+func marshalUncompressed(pub *PublicKey) []byte {
+	return append(append([]byte{0x04}, i2osp(pub.X, 32)...), i2osp(pub.Y, 32)...)
+}
+
+func TestUnmarshalPrivateKeyInvalid(t *testing.T) {
+	p := ECVRFP256SHA256SWU().Params()
+	tag := p.keyTag()
+	N := p.EC().Params().N
+	for _, tc := range []struct {
+		name string
+		b    []byte
+	}{
+		{"zero", append([]byte{tag}, make([]byte, 32)...)},
+		{"q", append([]byte{tag}, i2osp(N, 32)...)},
+		{"short", append([]byte{tag}, 1)},
+	} {
+		if _, err := p.UnmarshalPrivateKey(tc.b); err == nil {
+			t.Errorf("UnmarshalPrivateKey() of %v scalar succeeded", tc.name)
+		}
+	}
+
+	// A rotated key is not reduced, and is encoded as the equivalent reduced
+	// key.
+	sk := NewKey(p.EC(), new(big.Int).Add(N, big.NewInt(42)).Bytes())
+	b, err := p.MarshalPrivateKey(sk)
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey(): %v", err)
+	}
+	sk2, err := p.UnmarshalPrivateKey(b)
+	if err != nil {
+		t.Fatalf("UnmarshalPrivateKey(): %v", err)
+	}
+	if sk2.d.Int64() != 42 || sk2.X.Cmp(sk.X) != 0 || sk2.Y.Cmp(sk.Y) != 0 {
+		t.Errorf("UnmarshalPrivateKey(): %x, want 42", sk2.d)
+	}
+	beta, _ := p.ProofToHash(p.Prove(sk, []byte("alice")))
+	beta2, _ := p.ProofToHash(p.Prove(sk2, []byte("alice")))
+	if !bytes.Equal(beta, beta2) {
+		t.Errorf("output of the decoded key: %x, want %x", beta2, beta)
+	}
+}
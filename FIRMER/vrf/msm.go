@@ -0,0 +1,251 @@
+package vrf
+
+import (
+	"crypto/elliptic"
+	"math/big"
+)
+
+// jacobianCurve implements the group law of a short Weierstrass curve with
+// a = -3 in Jacobian coordinates over a montField, for multi-scalar
+// multiplication.
+type jacobianCurve struct {
+	f *montField
+}
+
+// jacobianPoint is the point (X/Z^2, Y/Z^3), or the point at infinity if Z = 0.
+type jacobianPoint struct {
+	x, y, z fe
+}
+
+// affinePoint is a finite point.
+type affinePoint struct {
+	x, y fe
+}
+
+func newJacobianCurve(ec elliptic.Curve) *jacobianCurve {
+	return &jacobianCurve{f: newMontField(ec.Params().P)}
+}
+
+// affine converts the finite point (x, y) to Montgomery form.
+func (c *jacobianCurve) affine(x, y *big.Int) affinePoint {
+	return affinePoint{x: c.f.fromBig(x), y: c.f.fromBig(y)}
+}
+
+// neg returns -a.
+func (c *jacobianCurve) neg(a affinePoint) affinePoint {
+	var zero fe
+	c.f.sub(&a.y, &zero, &a.y)
+	return a
+}
+
+func (c *jacobianCurve) isInfinity(p *jacobianPoint) bool {
+	return c.f.isZero(&p.z)
+}
+
+// double sets p = 2p, with the dbl-2001-b formulas.
+func (c *jacobianCurve) double(p *jacobianPoint) {
+	f := c.f
+	if f.isZero(&p.z) {
+		return
+	}
+	var delta, gamma, beta, alpha, t0, t1 fe
+	f.square(&delta, &p.z)
+	f.square(&gamma, &p.y)
+	f.mul(&beta, &p.x, &gamma)
+	// alpha = 3*(X-delta)*(X+delta)
+	f.sub(&t0, &p.x, &delta)
+	f.add(&t1, &p.x, &delta)
+	f.mul(&alpha, &t0, &t1)
+	f.add(&t0, &alpha, &alpha)
+	f.add(&alpha, &t0, &alpha)
+	// Z3 = (Y+Z)^2-gamma-delta
+	f.add(&t0, &p.y, &p.z)
+	f.square(&t0, &t0)
+	f.sub(&t0, &t0, &gamma)
+	f.sub(&p.z, &t0, &delta)
+	// X3 = alpha^2-8*beta
+	f.add(&beta, &beta, &beta)
+	f.add(&beta, &beta, &beta) // 4*beta
+	f.square(&t0, &alpha)
+	f.add(&t1, &beta, &beta)
+	f.sub(&p.x, &t0, &t1)
+	// Y3 = alpha*(4*beta-X3)-8*gamma^2
+	f.sub(&t0, &beta, &p.x)
+	f.mul(&t0, &alpha, &t0)
+	f.square(&gamma, &gamma)
+	f.add(&gamma, &gamma, &gamma)
+	f.add(&gamma, &gamma, &gamma)
+	f.add(&gamma, &gamma, &gamma)
+	f.sub(&p.y, &t0, &gamma)
+}
+
+// addAffine sets p = p+q, with the madd-2007-bl formulas.
+func (c *jacobianCurve) addAffine(p *jacobianPoint, q *affinePoint) {
+	f := c.f
+	if f.isZero(&p.z) {
+		p.x, p.y, p.z = q.x, q.y, f.one
+		return
+	}
+	var z1z1, u2, s2, h, hh, i, j, r, v, t0 fe
+	f.square(&z1z1, &p.z)
+	f.mul(&u2, &q.x, &z1z1)
+	f.mul(&s2, &q.y, &p.z)
+	f.mul(&s2, &s2, &z1z1)
+	f.sub(&h, &u2, &p.x)
+	f.sub(&r, &s2, &p.y)
+	if f.isZero(&h) {
+		if f.isZero(&r) {
+			c.double(p)
+		} else {
+			*p = jacobianPoint{}
+		}
+		return
+	}
+	f.add(&r, &r, &r)
+	f.square(&hh, &h)
+	f.add(&i, &hh, &hh)
+	f.add(&i, &i, &i)
+	f.mul(&j, &h, &i)
+	f.mul(&v, &p.x, &i)
+	// X3 = r^2-J-2*V
+	f.square(&t0, &r)
+	f.sub(&t0, &t0, &j)
+	f.sub(&t0, &t0, &v)
+	f.sub(&t0, &t0, &v)
+	// Y3 = r*(V-X3)-2*Y1*J
+	f.sub(&v, &v, &t0)
+	f.mul(&v, &r, &v)
+	f.mul(&j, &p.y, &j)
+	f.add(&j, &j, &j)
+	f.sub(&p.y, &v, &j)
+	p.x = t0
+	// Z3 = (Z1+H)^2-Z1Z1-HH
+	f.add(&t0, &p.z, &h)
+	f.square(&t0, &t0)
+	f.sub(&t0, &t0, &z1z1)
+	f.sub(&p.z, &t0, &hh)
+}
+
+// add sets p = p+q, with the add-2007-bl formulas.
+func (c *jacobianCurve) add(p, q *jacobianPoint) {
+	f := c.f
+	if f.isZero(&q.z) {
+		return
+	}
+	if f.isZero(&p.z) {
+		*p = *q
+		return
+	}
+	var z1z1, z2z2, u1, u2, s1, s2, h, i, j, r, v, t0 fe
+	f.square(&z1z1, &p.z)
+	f.square(&z2z2, &q.z)
+	f.mul(&u1, &p.x, &z2z2)
+	f.mul(&u2, &q.x, &z1z1)
+	f.mul(&s1, &p.y, &q.z)
+	f.mul(&s1, &s1, &z2z2)
+	f.mul(&s2, &q.y, &p.z)
+	f.mul(&s2, &s2, &z1z1)
+	f.sub(&h, &u2, &u1)
+	f.sub(&r, &s2, &s1)
+	if f.isZero(&h) {
+		if f.isZero(&r) {
+			c.double(p)
+		} else {
+			*p = jacobianPoint{}
+		}
+		return
+	}
+	f.add(&r, &r, &r)
+	f.add(&i, &h, &h)
+	f.square(&i, &i)
+	f.mul(&j, &h, &i)
+	f.mul(&v, &u1, &i)
+	// X3 = r^2-J-2*V
+	f.square(&t0, &r)
+	f.sub(&t0, &t0, &j)
+	f.sub(&t0, &t0, &v)
+	f.sub(&t0, &t0, &v)
+	// Y3 = r*(V-X3)-2*S1*J
+	f.sub(&v, &v, &t0)
+	f.mul(&v, &r, &v)
+	f.mul(&j, &s1, &j)
+	f.add(&j, &j, &j)
+	f.sub(&p.y, &v, &j)
+	p.x = t0
+	// Z3 = ((Z1+Z2)^2-Z1Z1-Z2Z2)*H
+	f.add(&t0, &p.z, &q.z)
+	f.square(&t0, &t0)
+	f.sub(&t0, &t0, &z1z1)
+	f.sub(&t0, &t0, &z2z2)
+	f.mul(&p.z, &t0, &h)
+}
+
+// toAffine returns the coordinates of the finite point p.
+func (c *jacobianCurve) toAffine(p *jacobianPoint) (x, y *big.Int) {
+	zInv := new(big.Int).ModInverse(c.f.toBig(&p.z), c.f.pBig)
+	zInv2 := new(big.Int).Mul(zInv, zInv)
+	x = new(big.Int).Mul(c.f.toBig(&p.x), zInv2)
+	x.Mod(x, c.f.pBig)
+	y = new(big.Int).Mul(c.f.toBig(&p.y), zInv2.Mul(zInv2, zInv))
+	y.Mod(y, c.f.pBig)
+	return x, y
+}
+
+// msmWindow returns the bucket window size in bits for n points of b-bit
+// scalars, minimizing the number of additions, about b/w * (n + 2^(w+1)).
+func msmWindow(n, b int) uint {
+	best, bestCost := uint(1), -1
+	for w := uint(1); w <= 16; w++ {
+		cost := (b + int(w) - 1) / int(w) * (n + 1<<(w+1))
+		if bestCost < 0 || cost < bestCost {
+			best, bestCost = w, cost
+		}
+	}
+	return best
+}
+
+// window returns the w bits of k starting at bit i.
+func window(k *big.Int, i, w uint) int {
+	d := 0
+	for b := w; b > 0; b-- {
+		d = d<<1 | int(k.Bit(int(i+b-1)))
+	}
+	return d
+}
+
+// msm returns the sum of the scalars[i]*points[i], with Pippenger's bucket
+// method. The scalars are nonnegative.
+func (c *jacobianCurve) msm(points []affinePoint, scalars []*big.Int) jacobianPoint {
+	maxBits := 0
+	for _, k := range scalars {
+		if k.BitLen() > maxBits {
+			maxBits = k.BitLen()
+		}
+	}
+	w := msmWindow(len(points), maxBits)
+	buckets := make([]jacobianPoint, 1<<w)
+
+	var acc jacobianPoint
+	for i := (uint(maxBits) + w - 1) / w; i > 0; i-- {
+		for j := uint(0); j < w; j++ {
+			c.double(&acc)
+		}
+		for b := range buckets {
+			buckets[b] = jacobianPoint{}
+		}
+		for idx := range points {
+			if d := window(scalars[idx], (i-1)*w, w); d != 0 {
+				c.addAffine(&buckets[d], &points[idx])
+			}
+		}
+		// The sum of d*buckets[d], as the sum of the running sums from the
+		// top bucket down.
+		var running, sum jacobianPoint
+		for d := len(buckets) - 1; d > 0; d-- {
+			c.add(&running, &buckets[d])
+			c.add(&sum, &running)
+		}
+		c.add(&acc, &sum)
+	}
+	return acc
+}
@@ -0,0 +1,132 @@
+package vrf
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+)
+
+// Rotator builds the rotation proof of a key over mappings supplied in
+// batches, so that the mappings of a large key set need not be held in
+// memory at once. The challenges of the proof depend on a hash of all the
+// mappings, so they are supplied twice and in the same order: first to
+// HashMappings, then to CombineMappings.
+type Rotator interface {
+	// NewKey returns the rotated key.
+	NewKey() *PrivateKey
+
+	// HashMappings adds the next mappings to the hash of the n mappings of
+	// the rotation.
+	HashMappings(n int, mappings []RotationMapping) error
+
+	// CombineMappings adds the next mappings to the proof, once all of them
+	// have been hashed.
+	CombineMappings(mappings []RotationMapping) error
+
+	// Proof returns the rotation proof, once all the mappings have been
+	// combined.
+	Proof() (RotationProof, error)
+}
+
+type rotator struct {
+	p        ECVRFParams
+	sk, sk2  *PrivateKey
+	alpha    *big.Int
+	n        int
+	hashed   int
+	combined int
+
+	h            hash.Hash
+	mappingsHash []byte
+
+	yX, yY, y2X, y2Y *big.Int
+}
+
+// NewRotator returns a Rotator of sk to a new random key.
+func (p ECVRFParams) NewRotator(sk *PrivateKey) (Rotator, error) {
+	if p.rfc9381 {
+		return nil, errRotationUnsupported
+	}
+	alpha := p.randomFieldElement()
+	return &rotator{
+		p:     p,
+		sk:    sk,
+		sk2:   NewKey(p.ec, secretBytes(secretMul(sk.d, alpha))),
+		alpha: alpha,
+		n:     -1,
+		yX:    new(big.Int),
+		yY:    new(big.Int),
+		y2X:   new(big.Int),
+		y2Y:   new(big.Int),
+	}, nil
+}
+
+func (r *rotator) NewKey() *PrivateKey {
+	return r.sk2
+}
+
+// msgpackArrayHeader returns the header of a msgpack array of n elements.
+func msgpackArrayHeader(n int) []byte {
+	switch {
+	case n < 16:
+		return []byte{0x90 | byte(n)}
+	case n <= 0xffff:
+		return []byte{0xdc, byte(n >> 8), byte(n)}
+	default:
+		return []byte{0xdd, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+}
+
+// HashMappings hashes the encoding of the list of mappings as encodeAndHash
+// does: the array header, then each mapping.
+func (r *rotator) HashMappings(n int, mappings []RotationMapping) error {
+	if r.h == nil {
+		if n < 0 {
+			return fmt.Errorf("invalid number of mappings %d", n)
+		}
+		r.n = n
+		r.h = r.p.hash.New()
+		r.h.Write(msgpackArrayHeader(n))
+	}
+	if n != r.n || r.hashed+len(mappings) > r.n {
+		return fmt.Errorf("more than %d mappings", r.n)
+	}
+	for _, m := range mappings {
+		ser, err := msgpackEncode(m)
+		if err != nil {
+			return err
+		}
+		r.h.Write(ser)
+	}
+	r.hashed += len(mappings)
+	return nil
+}
+
+func (r *rotator) CombineMappings(mappings []RotationMapping) error {
+	if r.n < 0 || r.hashed != r.n {
+		return errors.New("the mappings must all be hashed before they are combined")
+	}
+	if r.combined+len(mappings) > r.n {
+		return fmt.Errorf("more than %d mappings", r.n)
+	}
+	if r.mappingsHash == nil {
+		r.mappingsHash = r.h.Sum(nil)
+	}
+	as := r.p.mappingCoefficients(r.sk.Public(), r.sk2.Public(), mappings, r.mappingsHash)
+	yX, yY, y2X, y2Y, err := r.p.combineY(mappings, as)
+	if err != nil {
+		return err
+	}
+	r.yX, r.yY = r.p.ec.Add(r.yX, r.yY, yX, yY)
+	r.y2X, r.y2Y = r.p.ec.Add(r.y2X, r.y2Y, y2X, y2Y)
+	r.combined += len(mappings)
+	return nil
+}
+
+func (r *rotator) Proof() (RotationProof, error) {
+	if r.n < 0 || r.combined != r.n {
+		return RotationProof{}, errors.New("the mappings must all be combined before the proof")
+	}
+	return r.p.rotationProof(r.sk, r.sk2, r.alpha, r.yX, r.yY, r.y2X, r.y2Y)
+}
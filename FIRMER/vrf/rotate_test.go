@@ -0,0 +1,99 @@
+package vrf
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMsgpackArrayHeader(t *testing.T) {
+	for _, n := range []int{0, 1, 15, 16, 0xffff, 0x10000} {
+		enc, err := msgpackEncode(make([]RotationMapping, n))
+		if err != nil {
+			t.Fatalf("msgpackEncode(): %v", err)
+		}
+		if h := msgpackArrayHeader(n); !bytes.HasPrefix(enc, h) {
+			t.Errorf("msgpackArrayHeader(%d): %x, want a prefix of %x", n, h, enc[:8])
+		}
+	}
+}
+
+func TestRotator(t *testing.T) {
+	v := ECVRFP256SHA256SWU()
+	p := v.Params()
+	sk, err := GenerateKey(p.EC(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey(): %v", err)
+	}
+	var xs [][]byte
+	for i := 0; i < 20; i++ {
+		xs = append(xs, []byte(fmt.Sprintf("user%d", i)))
+	}
+
+	r, err := v.NewRotator(sk)
+	if err != nil {
+		t.Fatalf("NewRotator(): %v", err)
+	}
+	mappings, err := GenerateMapping(v, sk, r.NewKey(), xs)
+	if err != nil {
+		t.Fatalf("GenerateMapping(): %v", err)
+	}
+	if err := r.CombineMappings(mappings); err == nil {
+		t.Errorf("CombineMappings() before HashMappings() succeeded")
+	}
+	for i := 0; i < len(mappings); i += 7 {
+		end := i + 7
+		if end > len(mappings) {
+			end = len(mappings)
+		}
+		if err := r.HashMappings(len(mappings), mappings[i:end]); err != nil {
+			t.Fatalf("HashMappings(): %v", err)
+		}
+	}
+	if err := r.HashMappings(len(mappings), mappings[:1]); err == nil {
+		t.Errorf("HashMappings() of too many mappings succeeded")
+	}
+	for i := 0; i < len(mappings); i += 7 {
+		if _, err := r.Proof(); err == nil {
+			t.Errorf("Proof() before CombineMappings() succeeded")
+		}
+		end := i + 7
+		if end > len(mappings) {
+			end = len(mappings)
+		}
+		if err := r.CombineMappings(mappings[i:end]); err != nil {
+			t.Fatalf("CombineMappings(): %v", err)
+		}
+	}
+	pi, err := r.Proof()
+	if err != nil {
+		t.Fatalf("Proof(): %v", err)
+	}
+	if err := v.VerifyRotate(sk.Public(), r.NewKey().Public(), mappings, pi); err != nil {
+		t.Errorf("VerifyRotate(): %v", err)
+	}
+
+	if _, err := ECVRFP256SHA256SSWURFC9381().NewRotator(sk); err == nil {
+		t.Errorf("NewRotator() of an RFC 9381 suite succeeded")
+	}
+}
+
+func TestStatefulRotateContext(t *testing.T) {
+	p := ECVRFP256SHA256SWU().Params()
+	sk, err := GenerateKey(p.EC(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey(): %v", err)
+	}
+	xs := [][]byte{[]byte("alice"), []byte("bob")}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, err := p.StatefulRotateContext(ctx, sk, xs, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("StatefulRotateContext() with a cancelled context: %v, want %v", err, context.Canceled)
+	}
+	if _, _, _, err := p.StatefulRotateContext(context.Background(), sk, xs, nil); err != nil {
+		t.Errorf("StatefulRotateContext(): %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package vrf
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"math"
+	"math/big"
+	mrand "math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// The timing tests follow dudect (Reparaz, Balasch and Verbauwhede, "Dude,
+// is my code constant time?"): the operation runs on a fixed secret and on
+// random secrets, in random order, and Welch's t-test checks whether the two
+// distributions of running times differ. |t| above timingThreshold is
+// evidence of a leak.
+//
+// They are statistical and slow, and would be flaky on a loaded machine, so
+// they only run with FIRMER_TIMING_TESTS=1, e.g.
+//
+//	FIRMER_TIMING_TESTS=1 go test -run 'TestTimingHarness|TestConstantTime' ./vrf
+const timingThreshold = 10
+
+// skipUnlessTimingTests skips timing tests unless they were asked for.
+func skipUnlessTimingTests(t *testing.T) {
+	t.Helper()
+	if os.Getenv("FIRMER_TIMING_TESTS") != "1" {
+		t.Skip("timing test, set FIRMER_TIMING_TESTS=1 to run it")
+	}
+}
+
+// timingSamples is the number of measurements of each timing test.
+const timingSamples = 4000
+
+// timingTTest measures op(true) on the fixed input and op(false) on random
+// inputs n times in total, and returns Welch's t statistic. The slowest
+// measurements, mostly due to interruptions, are discarded.
+func timingTTest(n int, op func(fixed bool)) float64 {
+	type sample struct {
+		fixed bool
+		d     float64
+	}
+	samples := make([]sample, n)
+	for i := range samples {
+		fixed := mrand.Intn(2) == 0
+		start := time.Now()
+		op(fixed)
+		samples[i] = sample{fixed, float64(time.Since(start))}
+	}
+
+	ds := make([]float64, n)
+	for i, s := range samples {
+		ds[i] = s.d
+	}
+	sort.Float64s(ds)
+	cutoff := ds[n*9/10]
+
+	var count [2]float64
+	var mean, m2 [2]float64
+	for _, s := range samples {
+		if s.d > cutoff {
+			continue
+		}
+		c := 0
+		if s.fixed {
+			c = 1
+		}
+		// Welford's online mean and variance.
+		count[c]++
+		delta := s.d - mean[c]
+		mean[c] += delta / count[c]
+		m2[c] += delta * (s.d - mean[c])
+	}
+	v0, v1 := m2[0]/(count[0]-1), m2[1]/(count[1]-1)
+	return (mean[0] - mean[1]) / math.Sqrt(v0/count[0]+v1/count[1])
+}
+
+// timingScalars returns a fixed scalar of low weight and random scalars of
+// the same length.
+func timingScalars(t *testing.T) (fixed []byte, random [][]byte) {
+	N := elliptic.P256().Params().N
+	fixed = make([]byte, 32)
+	fixed[0], fixed[31] = 0x80, 1
+	for i := 0; i < 64; i++ {
+		k, err := rand.Int(rand.Reader, N)
+		if err != nil {
+			t.Fatal(err)
+		}
+		random = append(random, k.FillBytes(make([]byte, 32)))
+	}
+	return fixed, random
+}
+
+// The harness must detect a leak as obvious as that of big.Int.Exp.
+func TestTimingHarness(t *testing.T) {
+	skipUnlessTimingTests(t)
+	_, random := timingScalars(t)
+	fixed := []byte{1}
+	base, mod := big.NewInt(3), elliptic.P256().Params().P
+	tt := timingTTest(timingSamples, func(f bool) {
+		k := random[mrand.Intn(len(random))]
+		if f {
+			k = fixed
+		}
+		new(big.Int).Exp(base, new(big.Int).SetBytes(k), mod)
+	})
+	t.Logf("t of big.Int.Exp: %.1f", tt)
+	if math.Abs(tt) < timingThreshold {
+		t.Errorf("t of big.Int.Exp: %.1f, want at least %v", tt, timingThreshold)
+	}
+}
+
+func TestConstantTime(t *testing.T) {
+	skipUnlessTimingTests(t)
+	ec := elliptic.P256()
+	fixed, random := timingScalars(t)
+	Px, Py := ec.ScalarBaseMult([]byte{42})
+	v := ECVRFP256SHA256SWU()
+	fixedKey := NewKey(ec, fixed)
+	var randomKeys []*PrivateKey
+	for _, k := range random {
+		randomKeys = append(randomKeys, NewKey(ec, k))
+	}
+	c := new(big.Int).Lsh(one, 127)
+
+	for _, tc := range []struct {
+		name    string
+		samples int
+		op      func(k []byte, sk *PrivateKey)
+	}{
+		{"secretScalarBaseMult", timingSamples, func(k []byte, sk *PrivateKey) { secretScalarBaseMult(ec, k) }},
+		{"secretScalarMult", timingSamples, func(k []byte, sk *PrivateKey) { secretScalarMult(ec, Px, Py, k) }},
+		{"secretMulAdd", timingSamples, func(k []byte, sk *PrivateKey) { secretMulAdd(ec, sk.d, c, sk.d) }},
+		{"GenerateNonce", timingSamples, func(k []byte, sk *PrivateKey) { v.Params().aux.GenerateNonce(sk, []byte("alice")) }},
+		// The input is the same, so that only the key varies.
+		{"Prove", timingSamples / 4, func(k []byte, sk *PrivateKey) { v.Prove(sk, []byte("alice")) }},
+	} {
+		tt := timingTTest(tc.samples, func(f bool) {
+			i := mrand.Intn(len(random))
+			k, sk := random[i], randomKeys[i]
+			if f {
+				k, sk = fixed, fixedKey
+			}
+			tc.op(k, sk)
+		})
+		t.Logf("t of %v: %.1f", tc.name, tt)
+		if math.Abs(tt) > timingThreshold {
+			t.Errorf("t of %v: %.1f, want at most %v", tc.name, tt, timingThreshold)
+		}
+	}
+}